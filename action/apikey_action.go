@@ -0,0 +1,68 @@
+package action
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"tagged-fs/db"
+	"time"
+)
+
+const apiKeyPrefix = "tfs_"
+
+// apiKeyTouchInterval is how stale a key's last use can get, so that most requests do not write to the database
+const apiKeyTouchInterval = time.Minute
+
+func HashApiKey(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateApiKey returns the key's id and its secret, which is only stored hashed and cannot be shown again.
+// Requests made with the key only see what userId can see, or everything if it is nil.
+func CreateApiKey(db_ db.DB, name string, scope string, userId *int /* nilable */) (int, string) {
+	requireAdmin(db_)
+
+	if name == "" {
+		panic(InvalidError{"Missing name"})
+	}
+	if scope != db.ScopeRead && scope != db.ScopeReadWrite {
+		panic(InvalidError{fmt.Sprintf("Invalid scope: '%v'", scope)})
+	}
+	if userId != nil && !db_.UserExists(*userId) {
+		panic(NotFoundError{fmt.Sprintf("User id '%v' does not exist", *userId)})
+	}
+
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	must(err)
+	secret := apiKeyPrefix + hex.EncodeToString(random)
+
+	id := db_.InsertApiKey(name, HashApiKey(secret), scope, userId)
+	return id, secret
+}
+
+func ListApiKeys(db db.DB) []db.ApiKey {
+	requireAdmin(db)
+	return db.GetAllApiKeys()
+}
+
+func RevokeApiKey(db db.DB, id int) {
+	requireAdmin(db)
+	if !db.ApiKeyExists(id) {
+		panic(NotFoundError{fmt.Sprintf("API key '%v' does not exist", id)})
+	}
+
+	db.RevokeApiKey(id)
+}
+
+// Authenticate returns the active key matching secret, or nil.
+// Its last use is updated at most once per apiKeyTouchInterval.
+func Authenticate(db db.DB, secret string) *db.ApiKey {
+	key := db.ApiKeyFromHash(HashApiKey(secret))
+	if key != nil && (key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= apiKeyTouchInterval) {
+		db.TouchApiKey(key.Id)
+	}
+	return key
+}
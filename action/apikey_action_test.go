@@ -0,0 +1,33 @@
+package action
+
+import (
+	"path/filepath"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Authenticating records when a key was last used, but not on every request
+func TestAuthenticateThrottlesLastUse(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	_, secret := CreateApiKey(db_, "key", db.ScopeRead, nil)
+
+	// A dry run shows whether authenticating writes to the database
+	changes := func() map[string]db.TableChanges {
+		tx := db_.BeginDryRun()
+		defer tx.Rollback()
+		if Authenticate(tx, secret) == nil {
+			t.Fatal("the key was not authenticated")
+		}
+		return tx.DryRunSummary().Tables
+	}
+
+	testutil.Equal(t, "changes of the first use", map[string]db.TableChanges{"api_key": {Updated: 1}}, changes())
+	Authenticate(db_, secret)
+	testutil.Equal(t, "last use", true, ListApiKeys(db_)[0].LastUsedAt != nil)
+	testutil.Equal(t, "changes of the next use", map[string]db.TableChanges{}, changes())
+	testutil.Equal(t, "unknown key", (*db.ApiKey)(nil), Authenticate(db_, "tfs_unknown"))
+
+	RevokeApiKey(db_, ListApiKeys(db_)[0].Id)
+	testutil.Equal(t, "revoked key", (*db.ApiKey)(nil), Authenticate(db_, secret))
+}
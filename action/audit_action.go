@@ -0,0 +1,23 @@
+package action
+
+import (
+	"fmt"
+	"tagged-fs/db"
+)
+
+// SearchAudit returns a page of matching audit entries, most recent first, and the total number of matches.
+// The audit log records private tags, so only administrators can search it.
+func SearchAudit(db db.DB, filter db.AuditFilter, limit int, offset int) ([]db.AuditEntry, int) {
+	requireAdmin(db)
+	if limit <= 0 {
+		panic(InvalidError{fmt.Sprintf("Invalid limit: '%v'", limit)})
+	}
+	if offset < 0 {
+		panic(InvalidError{fmt.Sprintf("Invalid offset: '%v'", offset)})
+	}
+	if filter.Since != nil && filter.Until != nil && filter.Until.Before(*filter.Since) {
+		panic(InvalidError{"Invalid date range. Until is before since."})
+	}
+
+	return db.SearchAudit(filter, limit, offset)
+}
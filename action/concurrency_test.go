@@ -0,0 +1,193 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tagged-fs/db"
+	"testing"
+)
+
+const (
+	// writerDirEnv is the directory of the database a process started by TestConcurrentWrites writes to
+	writerDirEnv = "TAGGED_FS_WRITER_DIR"
+	// writerProcesses are the processes started by TestConcurrentWrites, besides the test's
+	writerProcesses = 2
+	// writerGoroutines write at once in each process
+	writerGoroutines = 8
+	// writerOps are run by each goroutine
+	writerOps = 30
+)
+
+// writeResult counts what goroutines writing at once did
+type writeResult struct {
+	Added    int      `json:"added"`
+	Edited   int      `json:"edited"`
+	Stale    int      `json:"stale"`
+	Searches int      `json:"searches"`
+	Errors   []string `json:"errors"`
+}
+
+func (r *writeResult) add(other writeResult) {
+	r.Added += other.Added
+	r.Edited += other.Edited
+	r.Stale += other.Stale
+	r.Searches += other.Searches
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+func sharedFile(dir string) string {
+	return filepath.Join(dir, "shared.txt")
+}
+
+// writeAtOnce runs goroutines adding files, editing the shared file and searching
+func writeAtOnce(db_ db.DB, dir string, process string) writeResult {
+	tagId := ListTags(db_)[0].Id
+	sharedId := db_.FileIdFromPath(sharedFile(dir))
+
+	var result writeResult
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	for g := 0; g < writerGoroutines; g++ {
+		wait.Add(1)
+		go func(g int) {
+			defer wait.Done()
+			for op := 0; op < writerOps; op++ {
+				outcome := writeOp(db_, dir, fmt.Sprintf("%v-%v-%v", process, g, op), op, tagId, sharedId)
+
+				lock.Lock()
+				result.add(outcome)
+				lock.Unlock()
+			}
+		}(g)
+	}
+	wait.Wait()
+	return result
+}
+
+// writeOp adds a file, edits the shared file or searches, and counts it in the result.
+// Edits that lost the race to another are stale, anything else that panics is an error.
+func writeOp(db_ db.DB, dir string, name string, op int, tagId int, sharedId int) (result writeResult) {
+	defer func() {
+		r := recover()
+		if _, ok := r.(StaleRevisionError); ok {
+			result.Stale++
+		} else if r != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%v: %v", name, r))
+		}
+	}()
+
+	switch op % 3 {
+	case 0:
+		path := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			panic(err)
+		}
+		AddFile(db_, path, []int{tagId})
+		result.Added++
+	case 1:
+		// Alternates between untagging and tagging it
+		revision := GetFile(db_, sharedId).Revision
+		tagIds := []int{}
+		if revision%2 == 0 {
+			tagIds = []int{tagId}
+		}
+		EditFile(db_, sharedId, &revision, tagIds)
+		result.Edited++
+	case 2:
+		ListFiles(db_, db.FileSearch{TagIds: []int{tagId}})
+		result.Searches++
+	}
+	return result
+}
+
+// Goroutines of this process and of others adding and editing files in the same database at once neither fail, on a
+// locked database or otherwise, nor lose changes. The other processes run this test binary again.
+func TestConcurrentWrites(t *testing.T) {
+	if dir := os.Getenv(writerDirEnv); dir != "" {
+		result := writeAtOnce(db.Init(filepath.Join(dir, "db.sqlite3")), dir, fmt.Sprint(os.Getpid()))
+		out, err := json.Marshal(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("result-%v.json", os.Getpid())), out, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	tagId := AddTag(db_, "shared", "#808080", false, nil)
+	if err := os.WriteFile(sharedFile(dir), []byte("shared"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sharedId := AddFile(db_, sharedFile(dir), []int{tagId})
+
+	var total writeResult
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	for i := 0; i < writerProcesses; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestConcurrentWrites$")
+			cmd.Env = append(os.Environ(), writerDirEnv+"="+dir)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				total.Errors = append(total.Errors, fmt.Sprintf("process: %v\n%s", err, out))
+			}
+		}()
+	}
+	result := writeAtOnce(db_, dir, "test")
+	wait.Wait()
+	total.add(result)
+
+	results, err := filepath.Glob(filepath.Join(dir, "result-*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != writerProcesses {
+		t.Errorf("expected the results of %v processes, got %v", writerProcesses, len(results))
+	}
+	for _, path := range results {
+		var result writeResult
+		out, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(out, &result)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		total.add(result)
+	}
+
+	for _, err := range total.Errors {
+		if strings.Contains(err, "database is locked") {
+			t.Errorf("expected writes to wait for each other, got %v", err)
+		} else {
+			t.Error(err)
+		}
+	}
+
+	added := 0
+	for _, file := range ListFiles(db_, db.FileSearch{TagIds: []int{tagId}}) {
+		if file.Id != sharedId {
+			added++
+		}
+	}
+	if added != total.Added {
+		t.Errorf("expected the %v added files, got %v", total.Added, added)
+	}
+	if revision := GetFile(db_, sharedId).Revision; revision != 1+total.Edited {
+		t.Errorf("expected the revision of the shared file to be %v after %v edits, got %v", 1+total.Edited, total.Edited, revision)
+	}
+	if total.Edited == 0 || total.Stale+total.Edited != (1+writerProcesses)*writerGoroutines*writerOps/3 {
+		t.Errorf("expected every edit to be done or stale, got %v done and %v stale", total.Edited, total.Stale)
+	}
+}
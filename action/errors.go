@@ -0,0 +1,37 @@
+package action
+
+// NotFoundError is panicked when a change or lookup refers to a tag, file or other entity that does not exist
+type NotFoundError struct {
+	Message string
+}
+
+func (e NotFoundError) Error() string {
+	return e.Message
+}
+
+// ConflictError is panicked when a change is valid but cannot be applied to the current state
+type ConflictError struct {
+	Message string
+}
+
+func (e ConflictError) Error() string {
+	return e.Message
+}
+
+// InvalidError is panicked when the arguments of a change are invalid regardless of the current state
+type InvalidError struct {
+	Message string
+}
+
+func (e InvalidError) Error() string {
+	return e.Message
+}
+
+// UnsupportedError is panicked when a file's content cannot be processed, like a thumbnail of a text file
+type UnsupportedError struct {
+	Message string
+}
+
+func (e UnsupportedError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,280 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"tagged-fs/db"
+	"time"
+)
+
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+	// EventReset is sent instead of the changes a client missed when there are too many, it must reload everything
+	EventReset = "reset"
+)
+
+// Event notifies that a tag, file or the tag order changed. Its id is the id of the audit entry it comes from.
+type Event struct {
+	Id        int       `json:"id"`
+	Type      string    `json:"type"`
+	Entity    string    `json:"entity"`
+	EntityId  int       `json:"entityId"`
+	Operation string    `json:"operation"`
+	Origin    string    `json:"origin"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// private tags are only sent to their owner
+	private bool
+	ownerId *int
+	// fileTags are the live tags of each state of a file, which is only sent to users who can see it in one of them
+	fileTags [][]db.Tag
+}
+
+// VisibleTo reports whether the event can be sent to user, nil meaning an administrator
+func (e Event) VisibleTo(user *int /* nilable */) bool {
+	if user == nil {
+		return true
+	}
+	if e.private {
+		return e.ownerId != nil && *e.ownerId == *user
+	}
+	if e.fileTags == nil {
+		return true
+	}
+	for _, tags := range e.fileTags {
+		if fileVisibleTo(tags, *user) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileVisibleTo reports whether a file with tags is visible to user, like DB does: it is hidden when every tag it has
+// is private to someone else
+func fileVisibleTo(tags []db.Tag, user int) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if !tag.Private || (tag.OwnerId != nil && *tag.OwnerId == user) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventState holds the fields of tag and file states needed to describe an event
+type eventState struct {
+	OwnerId   *int       `json:"ownerId"`
+	Private   bool       `json:"private"`
+	TagIds    []int      `json:"tagIds"`
+	DeletedAt *time.Time `json:"deletedAt"`
+}
+
+// parseEventState returns nil if the entity did not exist or was in the trash
+func parseEventState(state json.RawMessage) *eventState {
+	var parsed *eventState
+	err := json.Unmarshal(state, &parsed)
+	must(err)
+
+	if parsed == nil || parsed.DeletedAt != nil {
+		return nil
+	}
+	return parsed
+}
+
+// eventFromAudit describes an audit entry from the point of view of clients, for which trashed entities do not exist
+func eventFromAudit(entry db.AuditEntry) Event {
+	event := Event{
+		Id:        entry.Id,
+		Type:      EventUpdated,
+		Entity:    entry.Entity,
+		EntityId:  entry.EntityId,
+		Operation: entry.Operation,
+		Origin:    entry.Origin,
+		CreatedAt: entry.CreatedAt,
+	}
+	if entry.Entity == db.EntityTagOrder {
+		return event
+	}
+
+	before := parseEventState(entry.Before)
+	after := parseEventState(entry.After)
+	switch {
+	case before == nil && after == nil:
+		// Purged from the trash, clients never saw it
+		event.Type = EventDeleted
+	case before == nil:
+		event.Type = EventCreated
+	case after == nil:
+		event.Type = EventDeleted
+	}
+
+	for _, state := range []*eventState{before, after} {
+		if state != nil && state.Private {
+			event.private = true
+			event.ownerId = state.OwnerId
+		}
+	}
+
+	return event
+}
+
+// eventPageSize is the number of audit entries read at once when publishing
+const eventPageSize = 500
+
+// eventBufferSize is the number of events a slow subscriber can fall behind before being dropped
+const eventBufferSize = 256
+
+// EventBus publishes the changes made by every process using the database, by following the audit log.
+// Commits made through DBs returned by Notify are published immediately, others on the next poll.
+type EventBus struct {
+	db     db.DB
+	wake   chan struct{}
+	lastId int
+
+	mu          sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func NewEventBus(db_ db.DB) *EventBus {
+	return &EventBus{
+		db:          db_,
+		wake:        make(chan struct{}, 1),
+		lastId:      db_.LastAuditId(),
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// Notify returns a DB whose commits wake the bus up
+func (b *EventBus) Notify(db_ db.DB) db.DB {
+	return db_.WithCommitNotify(b.wake)
+}
+
+// Run publishes new audit entries when woken up and every interval, until ctx is done
+func (b *EventBus) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		b.publishNew()
+	}
+}
+
+// publishNew publishes the audit entries added since the last call, errors are logged and retried on the next one
+func (b *EventBus) publishNew() {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("events: %v", err)
+		}
+	}()
+
+	for {
+		events, complete := b.Since(b.lastId, eventPageSize)
+		for _, event := range events {
+			b.publish(event)
+			b.lastId = event.Id
+		}
+		if complete {
+			return
+		}
+	}
+}
+
+// Since returns up to limit events after the one with id afterId, so clients can resume after reconnecting, and
+// whether they are all the events since
+func (b *EventBus) Since(afterId int, limit int) ([]Event, bool) {
+	entries := b.db.GetAuditAfter(afterId, limit+1)
+	complete := len(entries) <= limit
+	if !complete {
+		entries = entries[:limit]
+	}
+
+	events := make([]Event, 0, len(entries))
+	tags := make(map[int]*db.Tag)
+	for _, entry := range entries {
+		event := eventFromAudit(entry)
+		if entry.Entity == db.EntityFile {
+			event.fileTags = b.fileTags(entry, tags)
+		}
+		events = append(events, event)
+	}
+	return events, complete
+}
+
+// LastId returns the id of the latest event, published or not
+func (b *EventBus) LastId() int {
+	return b.db.LastAuditId()
+}
+
+// fileTags returns the live tags of the states of a file audit entry, looked up in tags first
+func (b *EventBus) fileTags(entry db.AuditEntry, tags map[int]*db.Tag) [][]db.Tag {
+	var fileTags [][]db.Tag
+	for _, state := range []json.RawMessage{entry.Before, entry.After} {
+		parsed := parseEventState(state)
+		if parsed == nil {
+			continue
+		}
+
+		live := make([]db.Tag, 0)
+		for _, id := range parsed.TagIds {
+			tag, ok := tags[id]
+			if !ok {
+				tag = b.db.GetTag(id)
+				tags[id] = tag
+			}
+			if tag != nil {
+				live = append(live, *tag)
+			}
+		}
+		fileTags = append(fileTags, live)
+	}
+	return fileTags
+}
+
+func (b *EventBus) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			// Dropped subscribers can resume with Since
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on, closed if the subscriber falls too far behind.
+// The returned function must be called once the channel is no longer read.
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	subscriber := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[subscriber] = true
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.subscribers[subscriber] {
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return subscriber, unsubscribe
+}
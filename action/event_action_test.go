@@ -0,0 +1,110 @@
+package action
+
+import (
+	"context"
+	"path/filepath"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+	"time"
+)
+
+// fileEvents returns the events of a file published by the bus since afterId
+func fileEvents(bus *EventBus, afterId int, fileId int) []Event {
+	events := make([]Event, 0)
+	all, _ := bus.Since(afterId, 1000)
+	for _, event := range all {
+		if event.Entity == db.EntityFile && event.EntityId == fileId {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Events of files are only sent to users who can see the file before or after the change
+func TestFileEventsAreVisibleToWhoSeesTheFile(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	alice := AddUser(db_, "alice")
+	bob := AddUser(db_, "bob")
+	private := AddTag(db_.WithUser(alice), "private", "#000000", true, nil)
+	shared := AddTag(db_, "shared", "#000000", false, nil)
+	bus := NewEventBus(db_)
+
+	fileId := AddFile(db_.WithUser(alice), testutil.WriteFile(t, dir, "f.txt", ""), []int{private})
+	untaggedId := AddFile(db_, testutil.WriteFile(t, dir, "g.txt", ""), nil)
+
+	added := fileEvents(bus, 0, fileId)
+	if len(added) != 1 || !added[0].VisibleTo(&alice) || added[0].VisibleTo(&bob) || !added[0].VisibleTo(nil) {
+		t.Errorf("a file with a private tag must only be shown to its owner and administrators, got %+v", added)
+	}
+	if events := fileEvents(bus, 0, untaggedId); len(events) != 1 || !events[0].VisibleTo(&bob) {
+		t.Errorf("a file without tags must be shown to everyone, got %+v", events)
+	}
+
+	lastId := added[0].Id
+	EditFile(db_, fileId, nil, []int{shared})
+	edited := fileEvents(bus, lastId, fileId)
+	if len(edited) != 1 || !edited[0].VisibleTo(&bob) || !edited[0].VisibleTo(&alice) {
+		t.Errorf("a file that became shared must be shown to everyone, got %+v", edited)
+	}
+}
+
+// A failing poll must not stop the bus, which publishes the events on the next one
+func TestEventBusSurvivesErrors(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	bus := NewEventBus(db_)
+	events, unsubscribe := bus.Subscribe()
+	defer unsubscribe()
+
+	AddTag(db_, "tag", "#000000", false, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bus.db = db_.WithContext(ctx)
+	bus.publishNew()
+	if len(events) != 0 {
+		t.Fatalf("expected no event to be published, got %v", len(events))
+	}
+
+	bus.db = db_
+	bus.publishNew()
+	if len(events) != 1 {
+		t.Fatalf("expected the event to be published, got %v events", len(events))
+	}
+}
+
+// Since returns at most limit events, and tells whether there are more
+func TestEventBusSinceLimit(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	bus := NewEventBus(db_)
+	for _, name := range []string{"first", "second", "third"} {
+		AddTag(db_, name, "#000000", false, nil)
+	}
+
+	events, complete := bus.Since(0, 2)
+	testutil.Equal(t, "events", 2, len(events))
+	testutil.Equal(t, "complete", false, complete)
+	events, complete = bus.Since(events[1].Id, 2)
+	testutil.Equal(t, "events", 1, len(events))
+	testutil.Equal(t, "complete", true, complete)
+	testutil.Equal(t, "last id", events[0].Id, bus.LastId())
+}
+
+// Run returns once its context is done
+func TestEventBusStops(t *testing.T) {
+	bus := NewEventBus(db.Init(filepath.Join(t.TempDir(), "db.sqlite3")))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bus.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the bus is still running after its context was cancelled")
+	}
+}
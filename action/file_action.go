@@ -12,51 +12,157 @@ func must(err error) {
 	}
 }
 
-func AddFile(db db.DB, path string, tagIds []int) {
+// StaleRevisionError is panicked when a write was based on an outdated revision of a file or tag
+type StaleRevisionError struct {
+	Entity   string
+	Id       int
+	Expected int
+	Actual   int
+}
+
+func (e StaleRevisionError) Error() string {
+	return fmt.Sprintf("%v '%v' was modified concurrently (expected revision %v, current revision %v)", e.Entity, e.Id, e.Expected, e.Actual)
+}
+
+// AddFile starts tracking a file and returns its id
+func AddFile[S db.Store[S]](db S, path string, tagIds []int) int {
 	abs, err := filepath.Abs(path)
 	must(err)
 
-	if db.FileExistsPath(abs) {
-		panic(fmt.Sprintf("File '%v' already exists", abs))
+	// Read before the transaction, as large files can take a while
+	content := readAddedContent(db, abs)
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if tx.FileExistsPath(abs) {
+		panic(ConflictError{fmt.Sprintf("File '%v' already exists", abs)})
+	}
+	if tx.FileInTrashPath(abs) {
+		panic(ConflictError{fmt.Sprintf("File '%v' is in the trash, restore it instead", abs)})
 	}
 
 	for _, tagId := range tagIds {
-		if !db.TagExists(tagId) {
-			panic(fmt.Sprintf("Tag id '%v' does not exist", tagId))
+		if !tx.TagExists(tagId) {
+			panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", tagId)})
 		}
 	}
 
-	db.AddFile(abs, tagIds)
+	id := tx.AddFile(abs, tagIds)
+	content.save(tx, id)
+
+	tx.RecordOperation("file.add", fmt.Sprintf("Add file '%v'", abs), fileChange(id, nil, tx.GetFileState(id)))
+	tx.Commit()
+
+	return id
 }
 
-func EditFile(db db.DB, id int, tagIds []int) {
-	if !db.FileExists(id) {
-		panic(fmt.Sprintf("File '%v' does not exists", id))
+// EditFile replaces the file's tags and returns its new revision.
+// If expectedRevision is set and the file was modified since, a StaleRevisionError is panicked.
+func EditFile[S db.Store[S]](db S, id int, expectedRevision *int /* nilable */, tagIds []int) int {
+	// Read before the transaction, as large files can take a while
+	content := readEditedContent(db, id)
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.FileExists(id) {
+		panic(NotFoundError{fmt.Sprintf("File '%v' does not exists", id)})
 	}
 
 	for _, tagId := range tagIds {
-		if !db.TagExists(tagId) {
-			panic(fmt.Sprintf("Tag id '%v' does not exist", tagId))
+		if !tx.TagExists(tagId) {
+			panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", tagId)})
 		}
 	}
 
-	db.UpdateFileTags(id, tagIds)
-}
+	before := tx.GetFileState(id)
 
-func ListFiles(db db.DB, name *string /* nilable */, tagIds []int) []db.File {
+	// Assignments made by other users can only be removed by them
+	wanted := make(map[int]bool)
 	for _, tagId := range tagIds {
+		wanted[tagId] = true
+	}
+	for _, tagId := range before.TagIds {
+		if !wanted[tagId] && tx.TagExists(tagId) && !isOwner(tx, tx.FileTagOwner(id, tagId)) {
+			panic(PermissionError{fmt.Sprintf("Tag id '%v' was assigned to file '%v' by another user", tagId, id)})
+		}
+	}
+
+	revision, ok := tx.UpdateFileTags(id, expectedRevision, tagIds)
+	if !ok {
+		panic(StaleRevisionError{"File", id, *expectedRevision, tx.FileRevision(id)})
+	}
+	if content != nil {
+		content.save(tx, id)
+	}
+
+	tx.RecordOperation("file.edit", fmt.Sprintf("Edit tags of file '%v'", before.Path), fileChange(id, before, tx.GetFileState(id)))
+	tx.Commit()
+
+	return revision
+}
+
+// ListFiles returns the files matching search, whose name can contain kind:x words to search by kind
+func ListFiles[S db.Store[S]](db S, search db.FileSearch) []db.File {
+	search = parseKindTokens(search)
+	checkKinds(search.Kinds)
+	if search.Text != nil && !db.HasSearchIndex() {
+		panic(InvalidError{"Content search is not available, SQLite was built without FTS5"})
+	}
+	if search.Fuzzy && !db.HasSearchIndex() {
+		panic(InvalidError{"Fuzzy search is not available, SQLite was built without FTS5"})
+	}
+
+	for _, tagId := range search.TagIds {
 		if !db.TagExists(tagId) {
-			panic(fmt.Sprintf("Tag id '%v' does not exist", tagId))
+			panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", tagId)})
 		}
 	}
 
-	return db.SearchFiles(name, tagIds)
+	return db.SearchFiles(search)
 }
 
-func RmFile(db db.DB, id int) {
+func GetFile[S db.Store[S]](db S, id int) db.File {
+	file := db.GetFile(id)
+	if file == nil {
+		panic(NotFoundError{fmt.Sprintf("File '%v' does not exists", id)})
+	}
+	return *file
+}
+
+// GetFiles returns the files of ids, leaving out those that do not exist
+func GetFiles[S db.Store[S]](db S, ids []int) []db.File {
+	return db.GetFiles(ids)
+}
+
+// FilePath returns the path of a tracked file
+func FilePath[S db.Store[S]](db S, id int) string {
 	if !db.FileExists(id) {
-		panic(fmt.Sprintf("File '%v' does not exists", id))
+		panic(NotFoundError{fmt.Sprintf("File '%v' does not exists", id)})
+	}
+
+	return db.FilePathFromId(id)
+}
+
+// RmFile moves a file to the trash
+func RmFile[S db.Store[S]](db S, id int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.FileExists(id) {
+		panic(NotFoundError{fmt.Sprintf("File '%v' does not exists", id)})
 	}
 
-	db.DeleteFile(id)
+	before := tx.GetFileState(id)
+	for _, tagId := range before.TagIds {
+		if !tx.TagExists(tagId) && !tx.TagInTrash(tagId) {
+			panic(PermissionError{fmt.Sprintf("File '%v' has private tags of another user", id)})
+		}
+	}
+
+	tx.DeleteFile(id)
+
+	tx.RecordOperation("file.rm", fmt.Sprintf("Delete file '%v'", before.Path), fileChange(id, before, tx.GetFileState(id)))
+	tx.Commit()
 }
@@ -0,0 +1,365 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"tagged-fs/db"
+	"time"
+)
+
+func marshalState(state any) json.RawMessage {
+	data, err := json.Marshal(state)
+	must(err)
+	return data
+}
+
+func tagChange(id int, before *db.TagState /* nilable */, after *db.TagState /* nilable */) db.Change {
+	return db.Change{Entity: db.EntityTag, Id: id, Before: marshalState(before), After: marshalState(after)}
+}
+
+func fileChange(id int, before *db.FileState /* nilable */, after *db.FileState /* nilable */) db.Change {
+	return db.Change{Entity: db.EntityFile, Id: id, Before: marshalState(before), After: marshalState(after)}
+}
+
+func tagOrderChange(before []int, after []int) db.Change {
+	return db.Change{Entity: db.EntityTagOrder, Before: marshalState(before), After: marshalState(after)}
+}
+
+// reverseChanges returns the changes that undo changes, in the order they are applied
+func reverseChanges(changes []db.Change) []db.Change {
+	reversed := make([]db.Change, 0, len(changes))
+	for i := len(changes) - 1; i >= 0; i-- {
+		change := changes[i]
+		change.Before, change.After = change.After, change.Before
+		reversed = append(reversed, change)
+	}
+	return reversed
+}
+
+// stateConflict is panicked when an entity no longer is in the state an operation left it in
+func stateConflict(change db.Change, undo bool) {
+	verb := "redone"
+	if undo {
+		verb = "undone"
+	}
+	switch change.Entity {
+	case db.EntityTag:
+		panic(ConflictError{fmt.Sprintf("Tag id '%v' was changed since, the operation cannot be %v", change.Id, verb)})
+	case db.EntityFile:
+		panic(ConflictError{fmt.Sprintf("File '%v' was changed since, the operation cannot be %v", change.Id, verb)})
+	default:
+		panic(ConflictError{fmt.Sprintf("The order of tags was changed since, the operation cannot be %v", verb)})
+	}
+}
+
+// revertValue sets *current to to if from and to differ, which they do when the operation changed the value.
+// It returns false if the value was changed since, *current not being from anymore.
+func revertValue[T any](current *T, from T, to T, equal func(a T, b T) bool) bool {
+	if equal(from, to) {
+		return true
+	}
+	if !equal(*current, from) {
+		return false
+	}
+	*current = to
+	return true
+}
+
+func sameValue[T comparable](a T, b T) bool {
+	return a == b
+}
+
+func sameInt(a *int /* nilable */, b *int /* nilable */) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+func sameTime(a *time.Time /* nilable */, b *time.Time /* nilable */) bool {
+	return a == nil && b == nil || a != nil && b != nil && a.Equal(*b)
+}
+
+func sameIds(a []int, b []int) bool {
+	return reflect.DeepEqual(sortedIds(a), sortedIds(b))
+}
+
+func sortedIds(ids []int) []int {
+	ids = append([]int{}, ids...)
+	sort.Ints(ids)
+	return ids
+}
+
+func hasId(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// revertIds removes from current the ids that are in from but not to, and adds those that are in to but not from.
+// It returns false if one of them was added or removed since.
+func revertIds(current *[]int, from []int, to []int) bool {
+	result := make([]int, 0, len(*current))
+	for _, id := range *current {
+		if hasId(from, id) && !hasId(to, id) {
+			continue
+		}
+		result = append(result, id)
+	}
+	for _, id := range from {
+		if !hasId(to, id) && !hasId(*current, id) {
+			return false
+		}
+	}
+	for _, id := range to {
+		if !hasId(from, id) {
+			if hasId(*current, id) {
+				return false
+			}
+			result = append(result, id)
+		}
+	}
+	*current = result
+	return true
+}
+
+// revertOrder puts the tags of current in the order to, the tags created since coming after them.
+// It returns false if the tags of from were reordered since.
+func revertOrder(current []int, from []int, to []int) ([]int, bool) {
+	only := func(ids []int, in []int) []int {
+		result := make([]int, 0, len(ids))
+		for _, id := range ids {
+			if hasId(in, id) {
+				result = append(result, id)
+			}
+		}
+		return result
+	}
+
+	if !reflect.DeepEqual(only(current, from), only(from, current)) {
+		return nil, false
+	}
+	order := only(to, current)
+	for _, id := range current {
+		if !hasId(to, id) {
+			order = append(order, id)
+		}
+	}
+	return order, true
+}
+
+// revertTagState returns the state of the tag after reverting what a change did from from to to, only touching what
+// the change changed. It returns false if the tag was changed since.
+func revertTagState(current *db.TagState /* nilable */, from *db.TagState /* nilable */, to *db.TagState /* nilable */) (*db.TagState, bool) {
+	if from == nil || to == nil {
+		// Creating or deleting the tag can only be reverted if nothing happened to it since
+		same := current == nil && from == nil || current != nil && from != nil && current.Name == from.Name &&
+			current.Color == from.Color && sameInt(current.OwnerId, from.OwnerId) && current.Private == from.Private &&
+			sameIds(current.ParentIds, from.ParentIds) && sameIds(current.ChildIds, from.ChildIds) &&
+			sameIds(current.FileIds, from.FileIds) && sameTime(current.DeletedAt, from.DeletedAt)
+		return to, same
+	}
+	if current == nil {
+		return nil, false
+	}
+
+	state := *current
+	ok := revertValue(&state.Name, from.Name, to.Name, sameValue[string]) &&
+		revertValue(&state.Color, from.Color, to.Color, sameValue[string]) &&
+		revertValue(&state.Order, from.Order, to.Order, sameValue[int]) &&
+		revertValue(&state.OwnerId, from.OwnerId, to.OwnerId, sameInt) &&
+		revertValue(&state.Private, from.Private, to.Private, sameValue[bool]) &&
+		revertValue(&state.DeletedAt, from.DeletedAt, to.DeletedAt, sameTime) &&
+		revertIds(&state.ParentIds, from.ParentIds, to.ParentIds) &&
+		revertIds(&state.ChildIds, from.ChildIds, to.ChildIds) &&
+		revertIds(&state.FileIds, from.FileIds, to.FileIds)
+	return &state, ok
+}
+
+// revertFileState is revertTagState for files
+func revertFileState(current *db.FileState /* nilable */, from *db.FileState /* nilable */, to *db.FileState /* nilable */) (*db.FileState, bool) {
+	if from == nil || to == nil {
+		same := current == nil && from == nil || current != nil && from != nil && current.Path == from.Path &&
+			current.Name == from.Name && sameIds(current.TagIds, from.TagIds) && sameTime(current.DeletedAt, from.DeletedAt)
+		return to, same
+	}
+	if current == nil {
+		return nil, false
+	}
+
+	state := *current
+	ok := revertValue(&state.Path, from.Path, to.Path, sameValue[string]) &&
+		revertValue(&state.Name, from.Name, to.Name, sameValue[string]) &&
+		revertValue(&state.DeletedAt, from.DeletedAt, to.DeletedAt, sameTime) &&
+		revertIds(&state.TagIds, from.TagIds, to.TagIds)
+	return &state, ok
+}
+
+// changedIds returns the ids that are in only one of a and b
+func changedIds(a []int, b []int) []int {
+	changed := make([]int, 0)
+	for _, id := range a {
+		if !hasId(b, id) {
+			changed = append(changed, id)
+		}
+	}
+	for _, id := range b {
+		if !hasId(a, id) {
+			changed = append(changed, id)
+		}
+	}
+	return changed
+}
+
+// checkTagVisible panics a PermissionError if the tag exists but is private to another user.
+// Tags that no longer exist are skipped when restoring states.
+func checkTagVisible(tx db.DB, tagId int) {
+	if !tx.TagExists(tagId) && !tx.TagInTrash(tagId) && tx.GetTagState(tagId) != nil {
+		panic(PermissionError{fmt.Sprintf("Tag id '%v' is private to another user", tagId)})
+	}
+}
+
+// checkAssignmentOwner panics a PermissionError unless the DB's user can remove the assignment, like in EditFile
+func checkAssignmentOwner(tx db.DB, fileId int, tagId int) {
+	if !isOwner(tx, tx.FileTagOwner(fileId, tagId)) {
+		panic(PermissionError{fmt.Sprintf("Tag id '%v' was assigned to file '%v' by another user", tagId, fileId)})
+	}
+}
+
+// checkTagChange panics a PermissionError if moving the tag from current to state is something the DB's user could
+// not do with the tag actions
+func checkTagChange(tx db.DB, id int, current *db.TagState /* nilable */, state *db.TagState /* nilable */) {
+	if current != nil {
+		checkCanEditTag(tx, id)
+	} else if state != nil && !isOwner(tx, state.OwnerId) {
+		panic(PermissionError{fmt.Sprintf("Tag id '%v' belongs to another user", id)})
+	}
+
+	var before, after db.TagState
+	if current != nil {
+		before = *current
+	}
+	if state != nil {
+		after = *state
+	}
+	for _, tagId := range append(changedIds(before.ParentIds, after.ParentIds), changedIds(before.ChildIds, after.ChildIds)...) {
+		checkTagVisible(tx, tagId)
+	}
+	for _, fileId := range changedIds(before.FileIds, after.FileIds) {
+		if hasId(before.FileIds, fileId) {
+			checkAssignmentOwner(tx, fileId, id)
+		}
+	}
+}
+
+// checkFileChange is checkTagChange for files, whose assignments made by other users can only be removed by them
+func checkFileChange(tx db.DB, id int, current *db.FileState /* nilable */, state *db.FileState /* nilable */) {
+	var before, after db.FileState
+	if current != nil {
+		before = *current
+	}
+	if state != nil {
+		after = *state
+	}
+	for _, tagId := range changedIds(before.TagIds, after.TagIds) {
+		checkTagVisible(tx, tagId)
+		if hasId(before.TagIds, tagId) {
+			checkAssignmentOwner(tx, id, tagId)
+		}
+	}
+}
+
+// applyChange moves an entity from the state from of a change to its state to, keeping what others changed since.
+// A ConflictError is panicked if what the change changed was changed since.
+func applyChange(tx db.DB, change db.Change, from json.RawMessage, to json.RawMessage, undo bool) {
+	switch change.Entity {
+	case db.EntityTag:
+		var fromState, toState *db.TagState
+		must(json.Unmarshal(from, &fromState))
+		must(json.Unmarshal(to, &toState))
+		current := tx.GetTagState(change.Id)
+		state, ok := revertTagState(current, fromState, toState)
+		if !ok {
+			stateConflict(change, undo)
+		}
+		checkTagChange(tx, change.Id, current, state)
+		tx.RestoreTagState(change.Id, state)
+	case db.EntityFile:
+		var fromState, toState *db.FileState
+		must(json.Unmarshal(from, &fromState))
+		must(json.Unmarshal(to, &toState))
+		current := tx.GetFileState(change.Id)
+		state, ok := revertFileState(current, fromState, toState)
+		if !ok {
+			stateConflict(change, undo)
+		}
+		checkFileChange(tx, change.Id, current, state)
+		tx.RestoreFileState(change.Id, state)
+	case db.EntityTagOrder:
+		var fromIds, toIds []int
+		must(json.Unmarshal(from, &fromIds))
+		must(json.Unmarshal(to, &toIds))
+		order, ok := revertOrder(tx.GetTagsOrder(), fromIds, toIds)
+		if !ok {
+			stateConflict(change, undo)
+		}
+		tx.UpdateTagsOrder(order)
+	default:
+		panic(fmt.Sprintf("Unknown journal entity: '%v'", change.Entity))
+	}
+}
+
+// Undo reverts the most recent operation of the DB's user that has not been undone and returns it.
+// Only what the operation changed is reverted, and a ConflictError is panicked if that was changed since.
+// Like with the tag and file actions, a PermissionError is panicked if it touches what belongs to another user.
+func Undo(db db.DB) db.Operation {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	op := tx.LastDoneOperation()
+	if op == nil {
+		panic(ConflictError{"Nothing to undo"})
+	}
+
+	reversed := reverseChanges(op.Changes)
+	for _, change := range reversed {
+		applyChange(tx, change, change.Before, change.After, true)
+	}
+	tx.SetOperationUndone(op.Id, true)
+	tx.RecordAudit("history.undo", reversed...)
+
+	tx.Commit()
+	return *op
+}
+
+// Redo reapplies the earliest undone operation of the DB's user and returns it.
+// Like Undo, a ConflictError is panicked if what the operation changed was changed since it was undone, and a
+// PermissionError if it touches what belongs to another user.
+func Redo(db db.DB) db.Operation {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	op := tx.FirstUndoneOperation()
+	if op == nil {
+		panic(ConflictError{"Nothing to redo"})
+	}
+
+	for _, change := range op.Changes {
+		applyChange(tx, change, change.Before, change.After, false)
+	}
+	tx.SetOperationUndone(op.Id, false)
+	tx.RecordAudit("history.redo", op.Changes...)
+
+	tx.Commit()
+	return *op
+}
+
+func History(db db.DB, limit int) []db.Operation {
+	if limit <= 0 {
+		panic(InvalidError{fmt.Sprintf("Invalid limit: '%v'", limit)})
+	}
+
+	return db.GetOperations(limit)
+}
@@ -0,0 +1,142 @@
+package action
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"tagged-fs/db"
+	"tagged-fs/metadata"
+)
+
+// fileContent is what is read from a file when it is added or edited
+type fileContent struct {
+	mimeType string
+	kind     string
+	metadata map[string]string
+	text     string
+}
+
+// readContent reads a file, its text only being extracted when db can index it
+func readContent[S db.Store[S]](db S, path string) (fileContent, error) {
+	mimeType, err := metadata.MimeType(path)
+	if err != nil {
+		return fileContent{}, err
+	}
+	values, err := metadata.Extract(path)
+	if err != nil {
+		return fileContent{}, err
+	}
+
+	var text string
+	if db.HasSearchIndex() {
+		text, err = metadata.Text(path)
+		if err != nil {
+			return fileContent{}, err
+		}
+	}
+	return fileContent{mimeType, metadata.Kind(mimeType), values, text}, nil
+}
+
+// readAddedContent reads a file being added. A file that cannot be read is still tracked, as an unknown type without metadata.
+func readAddedContent[S db.Store[S]](db S, path string) fileContent {
+	content, err := readContent(db, path)
+	if err != nil {
+		log.Printf("content of '%v': %v", path, err)
+		return fileContent{"application/octet-stream", metadata.KindOther, nil, ""}
+	}
+	return content
+}
+
+// readEditedContent reads a file whose tags are being edited, so changes to its content are picked up.
+// Nil is returned if the file cannot be read, what was read before being kept.
+func readEditedContent[S db.Store[S]](db S, id int) *fileContent /* nilable */ {
+	if !db.FileExists(id) {
+		return nil
+	}
+	path := db.FilePathFromId(id)
+	content, err := readContent(db, path)
+	if err != nil {
+		log.Printf("content of '%v': %v", path, err)
+		return nil
+	}
+	return &content
+}
+
+// contentStore is the part of a store the content of files is saved to
+type contentStore interface {
+	SetFileType(id int, mimeType string, kind string)
+	SetFileMetadata(fileId int, values map[string]string)
+	SetFileText(fileId int, text string)
+}
+
+func (c fileContent) save(db contentStore, fileId int) {
+	db.SetFileType(fileId, c.mimeType, c.kind)
+	db.SetFileMetadata(fileId, c.metadata)
+	db.SetFileText(fileId, c.text)
+}
+
+// kindToken is the prefix of the words of a name search that select a kind, like kind:video
+const kindToken = "kind:"
+
+// parseKindTokens moves the kind:x words of the searched name to the searched kinds
+func parseKindTokens(search db.FileSearch) db.FileSearch {
+	if search.Name == nil {
+		return search
+	}
+
+	search.Kinds = append([]string{}, search.Kinds...)
+	words := make([]string, 0)
+	for _, word := range strings.Fields(*search.Name) {
+		if lower := strings.ToLower(word); strings.HasPrefix(lower, kindToken) {
+			search.Kinds = append(search.Kinds, strings.TrimPrefix(lower, kindToken))
+		} else {
+			words = append(words, word)
+		}
+	}
+
+	name := strings.Join(words, " ")
+	search.Name = &name
+	if name == "" {
+		search.Name = nil
+	}
+	return search
+}
+
+func checkKinds(kinds []string) {
+	for _, kind := range kinds {
+		if !metadata.IsKind(kind) {
+			panic(InvalidError{fmt.Sprintf("Kind '%v' does not exist, it must be one of %v", kind, strings.Join(metadata.Kinds, ", "))})
+		}
+	}
+}
+
+// MetadataKeys returns the metadata keys of the visible files, with how many files have each
+func MetadataKeys(db db.DB) map[string]int {
+	return db.GetMetadataKeys()
+}
+
+type MetadataRebuild struct {
+	Updated int
+	// Failed maps the paths that could not be read to the error
+	Failed map[string]string
+}
+
+// RebuildMetadata reads the type, metadata and text of every visible file again, for files added before they were extracted or changed since
+func RebuildMetadata(db_ db.DB) MetadataRebuild {
+	tx := db_.Begin()
+	defer tx.Rollback()
+
+	result := MetadataRebuild{Failed: make(map[string]string)}
+	for _, file := range tx.SearchFiles(db.FileSearch{}) {
+		content, err := readContent(tx, file.Path)
+		if err != nil {
+			result.Failed[file.Path] = err.Error()
+			continue
+		}
+		content.save(tx, file.Id)
+		result.Updated++
+	}
+
+	tx.Commit()
+	return result
+}
@@ -0,0 +1,41 @@
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"tagged-fs/metadata"
+	"testing"
+)
+
+// Malformed files are added with what could be read, and files that cannot be read are reported by rebuilds
+func TestMalformedFileContent(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+
+	photo := AddFile(db_, testutil.WriteFile(t, dir, "photo.jpg", "\xFF\xD8\xFF\xE1\xFF\xFFExif\x00\x00MM"), nil)
+	songPath := testutil.WriteFile(t, dir, "song.mp3", "ID3\x03\x00\x00\x7F\x7F\x7F\x7FTIT2\x00\x00\x00\x06\x00\x00\x00Title")
+	song := AddFile(db_, songPath, nil)
+
+	file := db_.GetFile(photo)
+	testutil.Equal(t, "photo type", "image/jpeg", file.MimeType)
+	testutil.Equal(t, "photo kind", metadata.KindImage, file.Kind)
+	testutil.Equal(t, "photo metadata", 0, len(file.Metadata))
+	file = db_.GetFile(song)
+	testutil.Equal(t, "song kind", metadata.KindAudio, file.Kind)
+	testutil.Equal(t, "song metadata", map[string]string{metadata.KeyTitle: "Title"}, file.Metadata)
+
+	if err := os.Remove(songPath); err != nil {
+		t.Fatal(err)
+	}
+	result := RebuildMetadata(db_)
+	testutil.Equal(t, "updated", 1, result.Updated)
+	if _, ok := result.Failed[songPath]; !ok || len(result.Failed) != 1 {
+		t.Errorf("expected the removed file to fail, got %v", result.Failed)
+	}
+
+	content := readAddedContent(db_, songPath)
+	testutil.Equal(t, "unreadable type", "application/octet-stream", content.mimeType)
+	testutil.Equal(t, "unreadable kind", metadata.KindOther, content.kind)
+}
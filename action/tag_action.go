@@ -25,31 +25,65 @@ func isHexColor(color string) bool {
 
 func validateColor(color *string) {
 	if !isHexColor(*color) {
-		panic(fmt.Sprintf("Invalid hex color: '%v'", *color))
+		panic(InvalidError{fmt.Sprintf("Invalid hex color: '%v'", *color)})
 	}
 	*color = strings.ToUpper(*color)
 }
 
-func AddTag(db db.DB, name string, color string, parentIds []int) {
+// AddTag creates a tag owned by the store's user and returns its id
+func AddTag[S db.Store[S]](db S, name string, color string, private bool, parentIds []int) int {
 	validateColor(&color)
+	if private && db.User() == nil {
+		panic(InvalidError{"Only tags with an owner can be private"})
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
 
 	// check that parent ids exist
 	for _, v := range parentIds {
-		if !db.TagExists(v) {
-			panic(fmt.Sprintf("Tag id '%v' does not exist", v))
+		if !tx.TagExists(v) {
+			panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", v)})
 		}
 	}
 
-	db.InsertTag(name, color, parentIds)
+	id := tx.InsertTag(name, color, private, parentIds)
+
+	tx.RecordOperation("tag.add", fmt.Sprintf("Add tag '%v'", name), tagChange(id, nil, tx.GetTagState(id)))
+	tx.Commit()
+
+	return id
 }
 
-func ListTags(db db.DB) []db.Tag {
+func ListTags[S db.Store[S]](db S) []db.Tag {
 	return db.GetAllTags()
 }
 
-func EditTag(db db.DB, tagId int, name *string /* nilable */, color *string /* nilable */, parentIds *[]int /* nilable */) {
-	if name == nil && color == nil && parentIds == nil {
-		panic("No change specified")
+func GetTag[S db.Store[S]](db S, tagId int) db.Tag {
+	tag := db.GetTag(tagId)
+	if tag == nil {
+		panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", tagId)})
+	}
+	return *tag
+}
+
+// EditTag applies the given changes and returns the tag's new revision.
+// If expectedRevision is set and the tag was modified since, a StaleRevisionError is panicked.
+func EditTag[S db.Store[S]](db S, tagId int, expectedRevision *int /* nilable */, name *string /* nilable */, color *string /* nilable */, private *bool /* nilable */, parentIds *[]int /* nilable */) int {
+	if name == nil && color == nil && private == nil && parentIds == nil {
+		panic(InvalidError{"No change specified"})
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.TagExists(tagId) {
+		panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", tagId)})
+	}
+	checkCanEditTag(tx, tagId)
+
+	if private != nil && *private && tx.TagOwner(tagId) == nil {
+		panic(InvalidError{"Only tags with an owner can be private"})
 	}
 
 	if color != nil {
@@ -60,33 +94,70 @@ func EditTag(db db.DB, tagId int, name *string /* nilable */, color *string /* n
 
 		for _, v := range *parentIds {
 			// check that parent ids exist
-			if !db.TagExists(v) {
-				panic(fmt.Sprintf("Tag id '%v' does not exist", v))
+			if !tx.TagExists(v) {
+				panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", v)})
 			}
 
 			// Check for circular references
 			if tagId == v {
-				panic("Circular reference. Tag cannot have itself as parent.")
+				panic(InvalidError{"Circular reference. Tag cannot have itself as parent."})
 			}
 
-			parentTagIds := db.GetAllParentTagIds(v)
+			parentTagIds := tx.GetAllParentTagIds(v)
 			for _, parentTagId := range parentTagIds {
 				if parentTagId == tagId {
-					panic("Circular reference. Tag cannot have a parent that is a descendant of itself.")
+					panic(InvalidError{"Circular reference. Tag cannot have a parent that is a descendant of itself."})
 				}
 			}
 		}
 
 	}
 
-	db.UpdateTag(tagId, name, color, parentIds)
+	before := tx.GetTagState(tagId)
+
+	revision, ok := tx.UpdateTag(tagId, expectedRevision, name, color, private, parentIds)
+	if !ok {
+		panic(StaleRevisionError{"Tag", tagId, *expectedRevision, tx.TagRevision(tagId)})
+	}
+
+	tx.RecordOperation("tag.edit", fmt.Sprintf("Edit tag '%v'", before.Name), tagChange(tagId, before, tx.GetTagState(tagId)))
+	tx.Commit()
+
+	return revision
+}
+
+// ReorderTags sets the display order of tags to the order of ids
+func ReorderTags[S db.Store[S]](db S, ids []int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if !tx.TagExists(id) {
+			panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", id)})
+		}
+	}
+
+	before := tx.GetTagsOrder()
+	tx.UpdateTagsOrder(ids)
+
+	tx.RecordOperation("tag.reorder", "Reorder tags", tagOrderChange(before, tx.GetTagsOrder()))
+	tx.Commit()
 }
 
-func RmTag(db db.DB, tagId int) {
+// RmTag moves a tag to the trash
+func RmTag[S db.Store[S]](db S, tagId int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
 	// check that tag exists
-	if !db.TagExists(tagId) {
-		panic(fmt.Sprintf("Tag id '%v' does not exist", tagId))
+	if !tx.TagExists(tagId) {
+		panic(NotFoundError{fmt.Sprintf("Tag id '%v' does not exist", tagId)})
 	}
+	checkCanEditTag(tx, tagId)
+
+	before := tx.GetTagState(tagId)
+	tx.DeleteTag(tagId)
 
-	db.DeleteTag(tagId)
+	tx.RecordOperation("tag.rm", fmt.Sprintf("Delete tag '%v'", before.Name), tagChange(tagId, before, tx.GetTagState(tagId)))
+	tx.Commit()
 }
@@ -0,0 +1,177 @@
+package action
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"tagged-fs/db"
+	"tagged-fs/thumbnail"
+)
+
+// ContentHash returns the hex SHA-256 of a file, only reading it again when its size or modification time changed
+func ContentHash(db db.DB, path string) string {
+	info, err := os.Stat(path)
+	must(err)
+
+	modifiedAt := info.ModTime().UnixNano()
+	if hash := db.GetContentHash(path, info.Size(), modifiedAt); hash != nil {
+		return *hash
+	}
+
+	file, err := os.Open(path)
+	must(err)
+	defer file.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	must(err)
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	db.SetContentHash(path, info.Size(), modifiedAt, hash)
+	return hash
+}
+
+// ThumbnailCache stores thumbnails by content hash and size, so copies of a file share them and edits make new ones
+type ThumbnailCache struct {
+	Dir string
+	// generating bounds how many thumbnails are rendered at once, decoding large photos taking a lot of memory
+	generating chan struct{}
+}
+
+func NewThumbnailCache(dir string) *ThumbnailCache {
+	return &ThumbnailCache{Dir: dir, generating: make(chan struct{}, runtime.NumCPU())}
+}
+
+func (c *ThumbnailCache) path(hash string, size int) string {
+	return filepath.Join(c.Dir, hash[:2], fmt.Sprintf("%v-%v.jpg", hash, size))
+}
+
+var (
+	thumbnailDirPattern  = regexp.MustCompile(`^[0-9a-f]{2}$`)
+	thumbnailFilePattern = regexp.MustCompile(`^[0-9a-f]{64}-[0-9]+\.jpg$`)
+)
+
+// clear deletes the thumbnails of the cache. Its directory is configurable, so other files are left alone.
+func (c *ThumbnailCache) clear() {
+	dirs, err := os.ReadDir(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	must(err)
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || !thumbnailDirPattern.MatchString(dir.Name()) {
+			continue
+		}
+		dirPath := filepath.Join(c.Dir, dir.Name())
+		files, err := os.ReadDir(dirPath)
+		must(err)
+		for _, file := range files {
+			if file.Type().IsRegular() && thumbnailFilePattern.MatchString(file.Name()) && strings.HasPrefix(file.Name(), dir.Name()) {
+				must(os.Remove(filepath.Join(dirPath, file.Name())))
+			}
+		}
+		// Fails unless only thumbnails were in it
+		os.Remove(dirPath)
+	}
+}
+
+func checkThumbnailSize(size int) {
+	if !thumbnail.IsSize(size) {
+		panic(InvalidError{fmt.Sprintf("Invalid thumbnail size: '%v', expected one of %v", size, thumbnail.Sizes)})
+	}
+}
+
+// Thumbnail returns the path of the cached JPEG thumbnail of the file at path, rendering it if needed
+func Thumbnail(db db.DB, cache *ThumbnailCache, path string, size int) string {
+	checkThumbnailSize(size)
+
+	thumbPath := cache.path(ContentHash(db, path), size)
+	if _, err := os.Stat(thumbPath); err == nil {
+		return thumbPath
+	}
+
+	cache.generating <- struct{}{}
+	defer func() { <-cache.generating }()
+
+	data, err := thumbnail.Generate(path, size)
+	if errors.Is(err, thumbnail.ErrUnsupported) {
+		panic(UnsupportedError{fmt.Sprintf("No thumbnail for '%v': %v", path, err)})
+	}
+	must(err)
+
+	// Written aside then renamed, so concurrent requests never read a partial thumbnail
+	must(os.MkdirAll(filepath.Dir(thumbPath), 0o755))
+	tmp, err := os.CreateTemp(filepath.Dir(thumbPath), "*.tmp")
+	must(err)
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), thumbPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		panic(err)
+	}
+	return thumbPath
+}
+
+type ThumbnailRebuild struct {
+	Generated   int
+	Unsupported int
+	// Failed maps the paths whose thumbnail could not be rendered to the error
+	Failed map[string]string
+}
+
+// RebuildThumbnails deletes the cached thumbnails and renders those of every visible file in the given sizes
+func RebuildThumbnails(db_ db.DB, cache *ThumbnailCache, sizes []int) ThumbnailRebuild {
+	for _, size := range sizes {
+		checkThumbnailSize(size)
+	}
+
+	cache.clear()
+
+	result := ThumbnailRebuild{Failed: make(map[string]string)}
+	for _, file := range db_.SearchFiles(db.FileSearch{}) {
+		for _, size := range sizes {
+			err := rebuildThumbnail(db_, cache, file.Path, size)
+			var unsupported UnsupportedError
+			switch {
+			case errors.As(err, &unsupported):
+				result.Unsupported++
+			case err != nil:
+				result.Failed[file.Path] = err.Error()
+			default:
+				result.Generated++
+			}
+			if err != nil {
+				// Other sizes would fail the same way
+				break
+			}
+		}
+	}
+	return result
+}
+
+func rebuildThumbnail(db db.DB, cache *ThumbnailCache, path string, size int) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			e, ok := recovered.(error)
+			if !ok {
+				e = fmt.Errorf("%v", recovered)
+			}
+			err = e
+		}
+	}()
+
+	Thumbnail(db, cache, path, size)
+	return nil
+}
@@ -0,0 +1,59 @@
+package action
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Rebuilding deletes the cached thumbnails only, the cache directory being configurable
+func TestRebuildThumbnailsKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+		t.Fatal(err)
+	}
+	path := testutil.WriteFile(t, dir, "a.png", data.String())
+	AddFile(db_, path, nil)
+
+	cache := NewThumbnailCache(filepath.Join(dir, "cache"))
+	thumb := Thumbnail(db_, cache, path, 64)
+	hash := ContentHash(db_, path)
+	stale := testutil.WriteFile(t, filepath.Dir(thumb), hash[:2]+strings.Repeat("0", 62)+"-1024.jpg", "stale")
+	for _, name := range []string{"documents", "ab"} {
+		if err := os.Mkdir(filepath.Join(cache.Dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	others := []string{
+		testutil.WriteFile(t, cache.Dir, "notes.txt", "notes"),
+		testutil.WriteFile(t, cache.Dir, hash+"-64.jpg", "not in its hash's directory"),
+		testutil.WriteFile(t, filepath.Join(cache.Dir, "documents"), "report.txt", "report"),
+		testutil.WriteFile(t, filepath.Join(cache.Dir, "ab"), "report.txt", "report"),
+		testutil.WriteFile(t, filepath.Dir(thumb), "notes.txt", "notes"),
+	}
+
+	result := RebuildThumbnails(db_, cache, []int{128})
+	testutil.Equal(t, "generated", 1, result.Generated)
+
+	for _, deleted := range []string{thumb, stale} {
+		if _, err := os.Stat(deleted); !os.IsNotExist(err) {
+			t.Errorf("expected the thumbnail %v to be deleted, got %v", deleted, err)
+		}
+	}
+	for _, kept := range others {
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("expected %v to be kept, got %v", kept, err)
+		}
+	}
+	if _, err := os.Stat(cache.path(hash, 128)); err != nil {
+		t.Errorf("expected the new thumbnail, got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package action
+
+import (
+	"fmt"
+	"strconv"
+	"tagged-fs/db"
+	"time"
+)
+
+// DefaultTrashRetentionDays is used until a retention is configured
+const DefaultTrashRetentionDays = 30
+
+const trashRetentionSetting = "trash_retention_days"
+
+func ListTrash(db db.DB) ([]db.Tag, []db.File) {
+	return db.GetTrashedTags(), db.GetTrashedFiles()
+}
+
+func RestoreTag(db db.DB, tagId int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.TagInTrash(tagId) {
+		panic(NotFoundError{fmt.Sprintf("Tag id '%v' is not in the trash", tagId)})
+	}
+	checkCanEditTag(tx, tagId)
+
+	before := tx.GetTagState(tagId)
+	tx.RestoreTag(tagId)
+
+	tx.RecordOperation("tag.restore", fmt.Sprintf("Restore tag '%v'", before.Name), tagChange(tagId, before, tx.GetTagState(tagId)))
+	tx.Commit()
+}
+
+func RestoreFile(db db.DB, id int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.FileInTrash(id) {
+		panic(NotFoundError{fmt.Sprintf("File '%v' is not in the trash", id)})
+	}
+
+	before := tx.GetFileState(id)
+	if tx.FileExistsPath(before.Path) {
+		panic(ConflictError{fmt.Sprintf("File '%v' was added again since it was deleted", before.Path)})
+	}
+
+	tx.RestoreFile(id)
+
+	tx.RecordOperation("file.restore", fmt.Sprintf("Restore file '%v'", before.Path), fileChange(id, before, tx.GetFileState(id)))
+	tx.Commit()
+}
+
+// PurgeTag permanently deletes a tag from the trash. It is audited but cannot be undone.
+func PurgeTag(db db.DB, tagId int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.TagInTrash(tagId) {
+		panic(NotFoundError{fmt.Sprintf("Tag id '%v' is not in the trash", tagId)})
+	}
+	checkCanEditTag(tx, tagId)
+
+	before := tx.GetTagState(tagId)
+	tx.PurgeTag(tagId)
+
+	tx.RecordAudit("tag.purge", tagChange(tagId, before, nil))
+	tx.Commit()
+}
+
+// canPurgeFile reports whether the DB's user can purge a file, which deletes its assignments: like when editing its
+// tags, those made by other users can only be removed by them
+func canPurgeFile(db db.DB, id int) bool {
+	for _, tagId := range db.GetFileState(id).TagIds {
+		if !isOwner(db, db.FileTagOwner(id, tagId)) {
+			return false
+		}
+	}
+	return true
+}
+
+// PurgeFile permanently deletes a file from the trash. It is audited but cannot be undone.
+func PurgeFile(db db.DB, id int) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if !tx.FileInTrash(id) {
+		panic(NotFoundError{fmt.Sprintf("File '%v' is not in the trash", id)})
+	}
+
+	if !canPurgeFile(tx, id) {
+		panic(PermissionError{fmt.Sprintf("File '%v' has tags assigned by another user", id)})
+	}
+
+	before := tx.GetFileState(id)
+	tx.PurgeFile(id)
+
+	tx.RecordAudit("file.purge", fileChange(id, before, nil))
+	tx.Commit()
+}
+
+// PurgeTrash permanently deletes everything that was trashed more than olderThan ago and returns the number of purged tags and files.
+// Tags belonging to other users and files with tags assigned by them are skipped.
+func PurgeTrash(db db.DB, olderThan time.Duration) (int, int) {
+	if olderThan < 0 {
+		panic(InvalidError{fmt.Sprintf("Invalid age: '%v'", olderThan)})
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	tags, files := ListTrash(tx)
+
+	purgedTags := 0
+	for _, tag := range tags {
+		if tag.DeletedAt.Before(cutoff) && canEditTag(tx, tag.Id) {
+			PurgeTag(tx, tag.Id)
+			purgedTags++
+		}
+	}
+
+	purgedFiles := 0
+	for _, file := range files {
+		if file.DeletedAt.Before(cutoff) && canPurgeFile(tx, file.Id) {
+			PurgeFile(tx, file.Id)
+			purgedFiles++
+		}
+	}
+
+	tx.Commit()
+	return purgedTags, purgedFiles
+}
+
+// TrashRetentionDays returns how long trashed items are kept before being purged automatically, 0 meaning forever
+func TrashRetentionDays(db db.DB) int {
+	value := db.GetSetting(trashRetentionSetting)
+	if value == nil {
+		return DefaultTrashRetentionDays
+	}
+
+	days, err := strconv.Atoi(*value)
+	must(err)
+	return days
+}
+
+func SetTrashRetentionDays(db db.DB, days int) {
+	requireAdmin(db)
+	if days < 0 {
+		panic(InvalidError{fmt.Sprintf("Invalid retention: '%v' days", days)})
+	}
+
+	db.SetSetting(trashRetentionSetting, strconv.Itoa(days))
+}
+
+// PurgeExpiredTrash purges items trashed for longer than the configured retention
+func PurgeExpiredTrash(db db.DB) {
+	days := TrashRetentionDays(db)
+	if days == 0 {
+		return
+	}
+
+	PurgeTrash(db, time.Duration(days)*24*time.Hour)
+}
@@ -0,0 +1,66 @@
+package action
+
+import (
+	"fmt"
+	"tagged-fs/db"
+)
+
+// PermissionError is panicked when the DB's user is not allowed to make a change
+type PermissionError struct {
+	Message string
+}
+
+func (e PermissionError) Error() string {
+	return e.Message
+}
+
+func AddUser(db db.DB, name string) int {
+	requireAdmin(db)
+
+	if name == "" {
+		panic(InvalidError{"Missing name"})
+	}
+	if db.UserIdFromName(name) != nil {
+		panic(ConflictError{fmt.Sprintf("User '%v' already exists", name)})
+	}
+
+	return db.InsertUser(name)
+}
+
+func ListUsers(db db.DB) []db.User {
+	requireAdmin(db)
+
+	return db.GetAllUsers()
+}
+
+// UserId returns the id of the user with this name
+func UserId(db db.DB, name string) int {
+	id := db.UserIdFromName(name)
+	if id == nil {
+		panic(NotFoundError{fmt.Sprintf("User '%v' does not exist", name)})
+	}
+	return *id
+}
+
+// requireAdmin panics unless the DB is not restricted to a user
+func requireAdmin(db db.DB) {
+	if db.User() != nil {
+		panic(PermissionError{"Only administrators can do this"})
+	}
+}
+
+func isOwner[S db.Store[S]](db S, owner *int /* nilable */) bool {
+	return db.User() == nil || owner == nil || *owner == *db.User()
+}
+
+// canEditTag reports whether the DB's user can edit, delete or restore a tag.
+// Tags without an owner can be edited by everyone.
+func canEditTag[S db.Store[S]](db S, tagId int) bool {
+	return isOwner(db, db.TagOwner(tagId))
+}
+
+func checkCanEditTag[S db.Store[S]](db S, tagId int) {
+	if !canEditTag(db, tagId) {
+		panic(PermissionError{fmt.Sprintf("Tag id '%v' belongs to another user", tagId)})
+	}
+}
@@ -0,0 +1,442 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"tagged-fs/db"
+	"time"
+)
+
+// Webhook events, WebhookAllEvents matches every one of them
+const (
+	WebhookFileAdded    = "file.added"
+	WebhookFileDeleted  = "file.deleted"
+	WebhookFileTagged   = "file.tagged"
+	WebhookFileUntagged = "file.untagged"
+	WebhookTagAdded     = "tag.added"
+	WebhookTagUpdated   = "tag.updated"
+	WebhookTagDeleted   = "tag.deleted"
+	WebhookAllEvents    = "*"
+)
+
+var webhookEvents = []string{WebhookFileAdded, WebhookFileDeleted, WebhookFileTagged, WebhookFileUntagged, WebhookTagAdded, WebhookTagUpdated, WebhookTagDeleted, WebhookAllEvents}
+
+const (
+	webhookSecretPrefix = "whsec_"
+	// webhookCursorSetting is the id of the last audit entry turned into deliveries
+	webhookCursorSetting = "webhook_audit_cursor"
+	webhookMaxAttempts   = 8
+	webhookFirstRetry    = 30 * time.Second
+	webhookTimeout       = 10 * time.Second
+	// webhookConcurrency is the number of webhooks delivered to at once
+	webhookConcurrency = 8
+)
+
+// AddWebhook returns the created webhook with its secret, which is generated if empty
+func AddWebhook(db db.DB, rawUrl string, events []string, secret string) db.Webhook {
+	requireAdmin(db)
+
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		panic(InvalidError{fmt.Sprintf("Invalid webhook URL: '%v'", rawUrl)})
+	}
+	if len(events) == 0 {
+		panic(InvalidError{"Missing events"})
+	}
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			panic(InvalidError{fmt.Sprintf("Invalid webhook event: '%v', expected one of %v", event, webhookEvents)})
+		}
+	}
+
+	if secret == "" {
+		random := make([]byte, 32)
+		_, err := rand.Read(random)
+		must(err)
+		secret = webhookSecretPrefix + hex.EncodeToString(random)
+	}
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	id := tx.InsertWebhook(rawUrl, secret, events)
+	// Deliver every change made from now on, even if no server is running yet
+	tx.AdvanceSetting(webhookCursorSetting, nil, strconv.Itoa(tx.LastAuditId()))
+
+	tx.Commit()
+
+	return *db.GetWebhook(id)
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ListWebhooks returns the webhooks without their secret
+func ListWebhooks(db db.DB) []db.Webhook {
+	requireAdmin(db)
+
+	webhooks := db.GetAllWebhooks()
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks
+}
+
+// GetWebhook returns the webhook without its secret
+func GetWebhook(db db.DB, id int) db.Webhook {
+	requireAdmin(db)
+
+	webhook := db.GetWebhook(id)
+	if webhook == nil {
+		panic(NotFoundError{fmt.Sprintf("Webhook '%v' does not exist", id)})
+	}
+	webhook.Secret = ""
+	return *webhook
+}
+
+// RmWebhook deletes a webhook with its delivery log
+func RmWebhook(db db.DB, id int) {
+	GetWebhook(db, id)
+
+	db.DeleteWebhook(id)
+}
+
+// WebhookDeliveries returns the most recent deliveries of a webhook first
+func WebhookDeliveries(db db.DB, id int, limit int) []db.WebhookDelivery {
+	GetWebhook(db, id)
+	if limit <= 0 {
+		panic(InvalidError{fmt.Sprintf("Invalid limit: '%v'", limit)})
+	}
+
+	return db.GetWebhookDeliveries(id, limit)
+}
+
+// RedeliverWebhook schedules a delivery to be sent again as soon as possible
+func RedeliverWebhook(db db.DB, deliveryId int) {
+	requireAdmin(db)
+
+	if db.WebhookDeliveryWebhookId(deliveryId) == nil {
+		panic(NotFoundError{fmt.Sprintf("Webhook delivery '%v' does not exist", deliveryId)})
+	}
+	db.RetryWebhookDelivery(deliveryId)
+}
+
+// webhookEvent is one event derived from an audit entry, with the data sent to webhooks
+type webhookEvent struct {
+	Name string
+	Data map[string]any
+}
+
+// webhookEventsFromAudit returns the webhook events of an audit entry, a file edit producing one event per added or removed tag
+func webhookEventsFromAudit(entry db.AuditEntry) []webhookEvent {
+	event := eventFromAudit(entry)
+
+	switch entry.Entity {
+	case db.EntityFile:
+		var before, after *db.FileState
+		must(json.Unmarshal(entry.Before, &before))
+		must(json.Unmarshal(entry.After, &after))
+
+		switch event.Type {
+		case EventCreated:
+			return []webhookEvent{{WebhookFileAdded, map[string]any{"fileId": entry.EntityId, "file": after}}}
+		case EventDeleted:
+			return []webhookEvent{{WebhookFileDeleted, map[string]any{"fileId": entry.EntityId, "file": before}}}
+		}
+
+		tagged := make(map[int]bool)
+		for _, tagId := range before.TagIds {
+			tagged[tagId] = true
+		}
+		events := make([]webhookEvent, 0)
+		for _, tagId := range after.TagIds {
+			if !tagged[tagId] {
+				events = append(events, webhookEvent{WebhookFileTagged, map[string]any{"fileId": entry.EntityId, "tagId": tagId, "file": after}})
+			}
+			delete(tagged, tagId)
+		}
+		for _, tagId := range before.TagIds {
+			if tagged[tagId] {
+				events = append(events, webhookEvent{WebhookFileUntagged, map[string]any{"fileId": entry.EntityId, "tagId": tagId, "file": after}})
+			}
+		}
+		return events
+
+	case db.EntityTag:
+		var before, after *db.TagState
+		must(json.Unmarshal(entry.Before, &before))
+		must(json.Unmarshal(entry.After, &after))
+
+		switch event.Type {
+		case EventCreated:
+			return []webhookEvent{{WebhookTagAdded, map[string]any{"tagId": entry.EntityId, "tag": after}}}
+		case EventDeleted:
+			return []webhookEvent{{WebhookTagDeleted, map[string]any{"tagId": entry.EntityId, "tag": before}}}
+		default:
+			return []webhookEvent{{WebhookTagUpdated, map[string]any{"tagId": entry.EntityId, "tag": after}}}
+		}
+	}
+
+	return nil
+}
+
+func webhookWants(webhook db.Webhook, event string) bool {
+	for _, e := range webhook.Events {
+		if e == event || e == WebhookAllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookSignature is sent in the X-Tagged-Fs-Signature header so receivers can check a payload comes from us
+func WebhookSignature(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay doubles after every failed attempt
+func webhookRetryDelay(attempts int) time.Duration {
+	return webhookFirstRetry << (attempts - 1)
+}
+
+// WebhookDispatcher turns audit entries into webhook deliveries and sends them.
+// It follows the audit log like EventBus, so changes made by other processes are delivered too.
+// Each webhook is delivered to in order by its own goroutine, so a slow receiver only delays its own deliveries.
+type WebhookDispatcher struct {
+	db     db.DB
+	bus    *EventBus
+	client *http.Client
+	// slots bounds the number of webhooks delivered to at once
+	slots chan struct{}
+
+	mu sync.Mutex
+	// busy are the webhooks being delivered to, whose next due deliveries wait for the next dispatch
+	busy map[int]bool
+	wg   sync.WaitGroup
+}
+
+func NewWebhookDispatcher(db_ db.DB, bus *EventBus) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:     db_,
+		bus:    bus,
+		client: &http.Client{Timeout: webhookTimeout},
+		slots:  make(chan struct{}, webhookConcurrency),
+		busy:   make(map[int]bool),
+	}
+}
+
+// Run enqueues and sends deliveries on every change and every interval, until ctx is done.
+// Deliveries in progress are then cancelled, Run returning once they are finished.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer d.wait()
+
+	events, unsubscribe := d.bus.Subscribe()
+	defer func() { unsubscribe() }()
+
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				events, unsubscribe = d.bus.Subscribe()
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		d.dispatch(ctx)
+	}
+}
+
+// logPanic logs errors instead of panicking, so a failure does not take the server down
+func logPanic() {
+	if err := recover(); err != nil {
+		log.Printf("webhook: %v", err)
+	}
+}
+
+// dispatch enqueues the deliveries of new changes and starts sending the due ones, without waiting for them
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	defer logPanic()
+
+	d.enqueue()
+	d.deliverDue(ctx)
+}
+
+// wait returns once the deliveries started by dispatch are finished
+func (d *WebhookDispatcher) wait() {
+	d.wg.Wait()
+}
+
+// enqueue creates the deliveries of the audit entries after the cursor
+func (d *WebhookDispatcher) enqueue() {
+	for d.enqueueBatch(500) {
+	}
+}
+
+// enqueueBatch creates the deliveries of up to limit audit entries and returns whether there may be more.
+// The cursor starts at the end of the log, so existing history is not delivered.
+func (d *WebhookDispatcher) enqueueBatch(limit int) bool {
+	tx := d.db.Begin()
+	defer tx.Rollback()
+
+	cursor := tx.GetSetting(webhookCursorSetting)
+	if cursor == nil {
+		tx.AdvanceSetting(webhookCursorSetting, nil, strconv.Itoa(tx.LastAuditId()))
+		tx.Commit()
+		return false
+	}
+	lastId, err := strconv.Atoi(*cursor)
+	must(err)
+
+	entries := tx.GetAuditAfter(lastId, limit)
+	if len(entries) == 0 {
+		return false
+	}
+
+	webhooks := tx.GetAllWebhooks()
+	for _, entry := range entries {
+		for _, event := range webhookEventsFromAudit(entry) {
+			payload, err := json.Marshal(map[string]any{
+				"event":     event.Name,
+				"auditId":   entry.Id,
+				"origin":    entry.Origin,
+				"createdAt": entry.CreatedAt,
+				"data":      event.Data,
+			})
+			must(err)
+
+			for _, webhook := range webhooks {
+				if webhookWants(webhook, event.Name) {
+					tx.InsertWebhookDelivery(webhook.Id, event.Name, entry.Id, payload)
+				}
+			}
+		}
+	}
+
+	// Another process enqueued these entries first
+	if !tx.AdvanceSetting(webhookCursorSetting, cursor, strconv.Itoa(entries[len(entries)-1].Id)) {
+		return false
+	}
+	tx.Commit()
+
+	return len(entries) == limit
+}
+
+// deliverDue starts a goroutine sending the due deliveries of each webhook that is not already being delivered to
+func (d *WebhookDispatcher) deliverDue(ctx context.Context) {
+	webhookIds := make([]int, 0)
+	due := make(map[int][]db.WebhookDelivery)
+	for _, delivery := range d.db.GetDueWebhookDeliveries(time.Now(), 100) {
+		if due[delivery.WebhookId] == nil {
+			webhookIds = append(webhookIds, delivery.WebhookId)
+		}
+		due[delivery.WebhookId] = append(due[delivery.WebhookId], delivery)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, webhookId := range webhookIds {
+		if d.busy[webhookId] {
+			continue
+		}
+		d.busy[webhookId] = true
+		d.wg.Add(1)
+		go d.deliverAll(ctx, webhookId, due[webhookId])
+	}
+}
+
+// deliverAll sends the deliveries of a webhook in order, stopping at the first failure as the receiver is likely down
+func (d *WebhookDispatcher) deliverAll(ctx context.Context, webhookId int, deliveries []db.WebhookDelivery) {
+	defer d.wg.Done()
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.busy, webhookId)
+	}()
+	defer logPanic()
+
+	select {
+	case d.slots <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-d.slots }()
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil || !d.db.ClaimWebhookDelivery(delivery.Id, delivery.Attempts) {
+			return
+		}
+		if !d.deliver(ctx, delivery, delivery.Attempts+1) {
+			return
+		}
+	}
+}
+
+// deliver sends a delivery and returns whether it succeeded
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery db.WebhookDelivery, attempts int) bool {
+	webhook := d.db.GetWebhook(delivery.WebhookId)
+	if webhook == nil {
+		return false
+	}
+
+	statusCode, err := d.send(ctx, *webhook, delivery)
+	if err == nil {
+		d.db.FinishWebhookAttempt(delivery.Id, statusCode, nil, true, nil)
+		return true
+	}
+
+	message := err.Error()
+	var next *time.Time
+	if attempts < webhookMaxAttempts {
+		at := time.Now().Add(webhookRetryDelay(attempts))
+		next = &at
+	}
+	d.db.FinishWebhookAttempt(delivery.Id, statusCode, &message, false, next)
+	return false
+}
+
+// send returns the response's status code, if any, and an error unless it is 2xx
+func (d *WebhookDispatcher) send(ctx context.Context, webhook db.Webhook, delivery db.WebhookDelivery) (*int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tagged-fs")
+	req.Header.Set("X-Tagged-Fs-Event", delivery.Event)
+	req.Header.Set("X-Tagged-Fs-Delivery", strconv.Itoa(delivery.Id))
+	req.Header.Set("X-Tagged-Fs-Signature", WebhookSignature(webhook.Secret, delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &res.StatusCode, fmt.Errorf("unexpected status: %v", res.Status)
+	}
+	return &res.StatusCode, nil
+}
@@ -0,0 +1,227 @@
+package action
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"tagged-fs/db"
+	"testing"
+	"time"
+)
+
+// receivedRequest is a request received by a webhookReceiver
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver answers webhook requests with the given statuses in turn, then with 200
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	received []receivedRequest
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.received = append(r.received, receivedRequest{req.Header, body})
+	status := http.StatusOK
+	if len(r.statuses) != 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) requests() []receivedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedRequest{}, r.received...)
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError}}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	webhook := AddWebhook(db_, ts.URL, []string{WebhookTagAdded}, "secret")
+	dispatcher := NewWebhookDispatcher(db_, NewEventBus(db_))
+	tagId := AddTag(db_, "tag", "#000000", false, nil)
+	// tag.updated is not subscribed to
+	EditTag(db_, tagId, nil, nil, nil, nil, &[]int{})
+
+	// The first attempt fails and is retried later
+	start := time.Now()
+	dispatcher.dispatch(context.Background())
+	dispatcher.wait()
+	requests := receiver.requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected the tag.added event to be sent once, got %v requests", len(requests))
+	}
+	request := requests[0]
+	deliveries := WebhookDeliveries(db_, webhook.Id, 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("expected one delivery, got %v", len(deliveries))
+	}
+	delivery := deliveries[0]
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(request.body)
+	if signature := "sha256=" + hex.EncodeToString(mac.Sum(nil)); request.header.Get("X-Tagged-Fs-Signature") != signature {
+		t.Errorf("expected the signature %v, got %v", signature, request.header.Get("X-Tagged-Fs-Signature"))
+	}
+	if request.header.Get("X-Tagged-Fs-Event") != WebhookTagAdded || request.header.Get("X-Tagged-Fs-Delivery") != strconv.Itoa(delivery.Id) {
+		t.Errorf("expected the event and delivery headers, got %v", request.header)
+	}
+	var payload struct{ Event string }
+	if err := json.Unmarshal(request.body, &payload); err != nil || payload.Event != WebhookTagAdded {
+		t.Errorf("expected a tag.added payload, got %s", request.body)
+	}
+
+	if delivery.Attempts != 1 || delivery.StatusCode == nil || *delivery.StatusCode != http.StatusInternalServerError || delivery.Error == nil || delivery.DeliveredAt != nil {
+		t.Errorf("expected a failed attempt to be logged, got %+v", delivery)
+	}
+	if delivery.NextAttemptAt == nil || delivery.NextAttemptAt.Before(start.Add(webhookFirstRetry-time.Second)) || delivery.NextAttemptAt.After(time.Now().Add(webhookFirstRetry+time.Second)) {
+		t.Errorf("expected a retry in %v, got %v", webhookFirstRetry, delivery.NextAttemptAt)
+	}
+
+	// Nothing is due until the retry
+	dispatcher.dispatch(context.Background())
+	dispatcher.wait()
+	if len(receiver.requests()) != 1 {
+		t.Fatalf("expected no request before the retry, got %v", len(receiver.requests()))
+	}
+
+	RedeliverWebhook(db_, delivery.Id)
+	dispatcher.dispatch(context.Background())
+	dispatcher.wait()
+	requests = receiver.requests()
+	if len(requests) != 2 || string(requests[1].body) != string(request.body) {
+		t.Fatalf("expected the same payload to be sent again, got %v requests", len(requests))
+	}
+	delivery = WebhookDeliveries(db_, webhook.Id, 10)[0]
+	if delivery.Attempts != 2 || delivery.StatusCode == nil || *delivery.StatusCode != http.StatusOK || delivery.DeliveredAt == nil || delivery.NextAttemptAt != nil {
+		t.Errorf("expected a successful attempt to be logged, got %+v", delivery)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for attempts, delay := range map[int]time.Duration{1: webhookFirstRetry, 2: 2 * webhookFirstRetry, 3: 4 * webhookFirstRetry} {
+		if webhookRetryDelay(attempts) != delay {
+			t.Errorf("expected the retry after %v attempts in %v, got %v", attempts, delay, webhookRetryDelay(attempts))
+		}
+	}
+
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	// Nothing listens on a closed server
+	ts := httptest.NewServer(&webhookReceiver{})
+	ts.Close()
+
+	webhook := AddWebhook(db_, ts.URL, []string{WebhookAllEvents}, "")
+	dispatcher := NewWebhookDispatcher(db_, NewEventBus(db_))
+	AddTag(db_, "tag", "#000000", false, nil)
+	dispatcher.enqueue()
+
+	delivery := WebhookDeliveries(db_, webhook.Id, 10)[0]
+	dispatcher.deliver(context.Background(), delivery, 1)
+	delivery = WebhookDeliveries(db_, webhook.Id, 10)[0]
+	if delivery.StatusCode != nil || delivery.Error == nil || delivery.NextAttemptAt == nil {
+		t.Errorf("expected an unreachable receiver to be retried, got %+v", delivery)
+	}
+
+	dispatcher.deliver(context.Background(), delivery, webhookMaxAttempts)
+	delivery = WebhookDeliveries(db_, webhook.Id, 10)[0]
+	if delivery.NextAttemptAt != nil || delivery.DeliveredAt != nil {
+		t.Errorf("expected no retry after %v attempts, got %+v", webhookMaxAttempts, delivery)
+	}
+}
+
+// A receiver that does not answer only delays its own deliveries, until the request times out
+func TestWebhookSlowReceiver(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	release := make(chan struct{})
+	var slowRequests int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&slowRequests, 1)
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	fast := &webhookReceiver{}
+	ts := httptest.NewServer(fast)
+	defer ts.Close()
+
+	slowWebhook := AddWebhook(db_, slow.URL, []string{WebhookAllEvents}, "")
+	AddWebhook(db_, ts.URL, []string{WebhookAllEvents}, "")
+	dispatcher := NewWebhookDispatcher(db_, NewEventBus(db_))
+	dispatcher.client.Timeout = 500 * time.Millisecond
+	AddTag(db_, "first", "#000000", false, nil)
+	AddTag(db_, "second", "#000000", false, nil)
+
+	dispatcher.dispatch(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fast.requests()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(fast.requests()) != 2 {
+		t.Fatalf("expected the fast receiver to get both deliveries while the slow one hangs, got %v", len(fast.requests()))
+	}
+
+	// The slow webhook is still busy, its deliveries are not sent twice
+	dispatcher.dispatch(context.Background())
+	dispatcher.wait()
+	if n := atomic.LoadInt32(&slowRequests); n != 1 {
+		t.Errorf("expected the slow receiver to get one request before its first delivery failed, got %v", n)
+	}
+	deliveries := WebhookDeliveries(db_, slowWebhook.Id, 10)
+	if len(deliveries) != 2 || deliveries[1].Error == nil || deliveries[1].NextAttemptAt == nil || deliveries[0].Attempts != 0 {
+		t.Errorf("expected the first delivery to time out and the second to wait, got %+v", deliveries)
+	}
+}
+
+// Deliveries in progress are cancelled when Run's context is done
+func TestWebhookDispatcherStops(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	received := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The server notices the cancelled request once the body is read
+		io.ReadAll(req.Body)
+		received <- struct{}{}
+		<-req.Context().Done()
+	}))
+	defer slow.Close()
+
+	AddWebhook(db_, slow.URL, []string{WebhookAllEvents}, "")
+	dispatcher := NewWebhookDispatcher(db_, NewEventBus(db_))
+	AddTag(db_, "tag", "#000000", false, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	<-received
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the dispatcher is still running after its context was cancelled")
+	}
+}
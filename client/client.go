@@ -0,0 +1,459 @@
+// Package client talks to a running tagged-fs server over version 1 of its REST API, see server/openapi.json
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is the problem details body the server responds with on errors
+type Error struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	// Code identifies the kind of error, like "not_found" or "stale_revision"
+	Code string `json:"code"`
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%v %v: %v", e.Status, e.Code, e.Detail)
+}
+
+// readError decodes the problem details of an error response
+func readError(res *http.Response) error {
+	problem := Error{Status: res.StatusCode, Title: http.StatusText(res.StatusCode)}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if json.Unmarshal(body, &problem) != nil {
+		problem.Detail = string(body)
+	}
+	return problem
+}
+
+// apiPrefix is prepended to every route, the client only speaks this version of the API
+const apiPrefix = "/api/v1"
+
+type Client struct {
+	// BaseURL is the server's address, like "http://127.0.0.1:8080"
+	BaseURL string
+	// ApiKey is sent as a bearer token when set
+	ApiKey string
+	HTTP   *http.Client
+}
+
+func New(baseURL string, apiKey string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), ApiKey: apiKey, HTTP: http.DefaultClient}
+}
+
+// request sends body as JSON, and decodes the response into out unless it is nil
+func (c *Client) request(method string, path string, query url.Values, header http.Header, body any, out any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := c.BaseURL + apiPrefix + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.ApiKey)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return res, readError(res)
+	}
+
+	if out != nil {
+		err := json.NewDecoder(res.Body).Decode(out)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// ifMatch guards a write with the revision it is based on, if set
+func ifMatch(revision *int /* nilable */) http.Header {
+	if revision == nil {
+		return nil
+	}
+	return http.Header{"If-Match": []string{fmt.Sprintf(`"%d"`, *revision)}}
+}
+
+// etagRevision returns the revision a write responded with
+func etagRevision(res *http.Response) (int, error) {
+	return strconv.Atoi(strings.Trim(res.Header.Get("ETag"), `"`))
+}
+
+// tagList returns ids as a list the server accepts, nil being sent as no tags
+func tagList(ids []int) []int {
+	if ids == nil {
+		return []int{}
+	}
+	return ids
+}
+
+func idPath(format string, id int) string {
+	return fmt.Sprintf(format, id)
+}
+
+// Tags
+
+func (c *Client) ListTags() ([]Tag, error) {
+	var tags []Tag
+	_, err := c.request(http.MethodGet, "/tags", nil, nil, nil, &tags)
+	return tags, err
+}
+
+func (c *Client) GetTag(id int) (Tag, error) {
+	var tag Tag
+	_, err := c.request(http.MethodGet, idPath("/tags/%d", id), nil, nil, nil, &tag)
+	return tag, err
+}
+
+// AddTag returns the created tag
+func (c *Client) AddTag(name string, color string, private bool, parentIds []int) (Tag, error) {
+	var tag Tag
+	body := map[string]any{"name": name, "color": color, "private": private, "parentIds": parentIds}
+	_, err := c.request(http.MethodPost, "/tags", nil, nil, body, &tag)
+	return tag, err
+}
+
+// EditTag returns the tag's new revision. If revision is set and the tag was modified since, the server responds 412.
+func (c *Client) EditTag(id int, update TagUpdate, revision *int /* nilable */) (int, error) {
+	res, err := c.request(http.MethodPut, idPath("/tags/%d", id), nil, ifMatch(revision), update, nil)
+	if err != nil {
+		return 0, err
+	}
+	return etagRevision(res)
+}
+
+func (c *Client) ReorderTags(ids []int) error {
+	_, err := c.request(http.MethodPut, "/tag-order", nil, nil, ids, nil)
+	return err
+}
+
+func (c *Client) RmTag(id int) error {
+	_, err := c.request(http.MethodDelete, idPath("/tags/%d", id), nil, nil, nil, nil)
+	return err
+}
+
+// Files
+
+func (c *Client) ListFiles() ([]File, error) {
+	var files []File
+	_, err := c.request(http.MethodGet, "/files", nil, nil, nil, &files)
+	return files, err
+}
+
+// SearchFiles returns the files matching search, sorted by name or by relevance when searching by name
+func (c *Client) SearchFiles(search FileSearch) ([]File, error) {
+	var files []File
+	_, err := c.request(http.MethodPost, "/files/search", nil, nil, search, &files)
+	return files, err
+}
+
+func (c *Client) GetFile(id int) (File, error) {
+	var file File
+	_, err := c.request(http.MethodGet, idPath("/files/%d", id), nil, nil, nil, &file)
+	return file, err
+}
+
+// AddFile returns the created file
+func (c *Client) AddFile(path string, tagIds []int) (File, error) {
+	var file File
+	body := map[string]any{"path": path, "tags": tagList(tagIds)}
+	_, err := c.request(http.MethodPost, "/files", nil, nil, body, &file)
+	return file, err
+}
+
+// UpdateFile replaces the file's tags and returns its new revision. If revision is set and the file was modified since, the server responds 412.
+func (c *Client) UpdateFile(id int, tagIds []int, revision *int /* nilable */) (int, error) {
+	body := map[string]any{"tags": tagList(tagIds)}
+	res, err := c.request(http.MethodPut, idPath("/files/%d", id), nil, ifMatch(revision), body, nil)
+	if err != nil {
+		return 0, err
+	}
+	return etagRevision(res)
+}
+
+func (c *Client) RmFile(id int) error {
+	_, err := c.request(http.MethodDelete, idPath("/files/%d", id), nil, nil, nil, nil)
+	return err
+}
+
+// FileContent returns the content of a file, which the caller must close
+func (c *Client) FileContent(id int) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL+apiPrefix+idPath("/files/%d/file", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.ApiKey)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		defer res.Body.Close()
+		return nil, readError(res)
+	}
+	return res.Body, nil
+}
+
+// History
+
+// History returns the most recent operations first
+func (c *Client) History(limit int) ([]Operation, error) {
+	var operations []Operation
+	_, err := c.request(http.MethodGet, "/history", url.Values{"limit": {strconv.Itoa(limit)}}, nil, nil, &operations)
+	return operations, err
+}
+
+// Undo reverts the last operation and returns it
+func (c *Client) Undo() (Operation, error) {
+	var operation Operation
+	_, err := c.request(http.MethodPost, "/history/undo", nil, nil, nil, &operation)
+	return operation, err
+}
+
+// Redo reapplies the last undone operation and returns it
+func (c *Client) Redo() (Operation, error) {
+	var operation Operation
+	_, err := c.request(http.MethodPost, "/history/redo", nil, nil, nil, &operation)
+	return operation, err
+}
+
+// Trash
+
+func (c *Client) ListTrash() (Trash, error) {
+	var trash Trash
+	_, err := c.request(http.MethodGet, "/trash", nil, nil, nil, &trash)
+	return trash, err
+}
+
+func (c *Client) RestoreTag(id int) error {
+	_, err := c.request(http.MethodPost, idPath("/trash/tags/%d/restore", id), nil, nil, nil, nil)
+	return err
+}
+
+func (c *Client) RestoreFile(id int) error {
+	_, err := c.request(http.MethodPost, idPath("/trash/files/%d/restore", id), nil, nil, nil, nil)
+	return err
+}
+
+func (c *Client) PurgeTag(id int) error {
+	_, err := c.request(http.MethodDelete, idPath("/trash/tags/%d", id), nil, nil, nil, nil)
+	return err
+}
+
+func (c *Client) PurgeFile(id int) error {
+	_, err := c.request(http.MethodDelete, idPath("/trash/files/%d", id), nil, nil, nil, nil)
+	return err
+}
+
+// PurgeTrash permanently deletes items trashed more than olderThanDays ago and returns the number of purged tags and files
+func (c *Client) PurgeTrash(olderThanDays int) (int, int, error) {
+	var result struct {
+		Tags  int `json:"tags"`
+		Files int `json:"files"`
+	}
+	_, err := c.request(http.MethodDelete, "/trash", url.Values{"olderThanDays": {strconv.Itoa(olderThanDays)}}, nil, nil, &result)
+	return result.Tags, result.Files, err
+}
+
+// TrashRetention returns how many days trashed items are kept, 0 meaning forever
+func (c *Client) TrashRetention() (int, error) {
+	var result struct {
+		Days int `json:"days"`
+	}
+	_, err := c.request(http.MethodGet, "/trash/retention", nil, nil, nil, &result)
+	return result.Days, err
+}
+
+func (c *Client) SetTrashRetention(days int) error {
+	_, err := c.request(http.MethodPut, "/trash/retention", nil, nil, map[string]int{"days": days}, nil)
+	return err
+}
+
+// Audit
+
+func (c *Client) SearchAudit(query AuditQuery) (AuditPage, error) {
+	values := url.Values{}
+	if query.FileId != nil {
+		values.Set("file", strconv.Itoa(*query.FileId))
+	}
+	if query.TagId != nil {
+		values.Set("tag", strconv.Itoa(*query.TagId))
+	}
+	if query.Since != nil {
+		values.Set("since", query.Since.Format(time.RFC3339))
+	}
+	if query.Until != nil {
+		values.Set("until", query.Until.Format(time.RFC3339))
+	}
+	if query.Origin != nil {
+		values.Set("origin", *query.Origin)
+	}
+	if query.Page != 0 {
+		values.Set("page", strconv.Itoa(query.Page))
+	}
+	if query.PerPage != 0 {
+		values.Set("perPage", strconv.Itoa(query.PerPage))
+	}
+
+	var page AuditPage
+	_, err := c.request(http.MethodGet, "/audit", values, nil, nil, &page)
+	return page, err
+}
+
+// Webhooks
+
+func (c *Client) ListWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	_, err := c.request(http.MethodGet, "/webhooks", nil, nil, nil, &webhooks)
+	return webhooks, err
+}
+
+func (c *Client) GetWebhook(id int) (Webhook, error) {
+	var webhook Webhook
+	_, err := c.request(http.MethodGet, idPath("/webhooks/%d", id), nil, nil, nil, &webhook)
+	return webhook, err
+}
+
+// AddWebhook returns the created webhook with its secret, which the server generates if empty
+func (c *Client) AddWebhook(url string, events []string, secret string) (Webhook, error) {
+	body := map[string]any{"url": url, "events": events, "secret": secret}
+	var webhook Webhook
+	_, err := c.request(http.MethodPost, "/webhooks", nil, nil, body, &webhook)
+	return webhook, err
+}
+
+func (c *Client) RmWebhook(id int) error {
+	_, err := c.request(http.MethodDelete, idPath("/webhooks/%d", id), nil, nil, nil, nil)
+	return err
+}
+
+func (c *Client) WebhookDeliveries(id int, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	_, err := c.request(http.MethodGet, idPath("/webhooks/%d/deliveries", id), url.Values{"limit": {strconv.Itoa(limit)}}, nil, nil, &deliveries)
+	return deliveries, err
+}
+
+func (c *Client) RedeliverWebhook(deliveryId int) error {
+	_, err := c.request(http.MethodPost, idPath("/webhook-deliveries/%d/redeliver", deliveryId), nil, nil, nil, nil)
+	return err
+}
+
+// GraphQL
+
+// GraphQL runs a query or mutation of server/schema.graphql and decodes its data into out unless it is nil.
+// The first error of the query is returned as a GraphqlError, out is still decoded as other fields may have resolved.
+func (c *Client) GraphQL(query string, variables map[string]any, out any) error {
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []GraphqlError  `json:"errors"`
+	}
+	body := map[string]any{"query": query, "variables": variables}
+	_, err := c.request(http.MethodPost, "/graphql", nil, nil, body, &result)
+	if err != nil {
+		return err
+	}
+
+	if out != nil && len(result.Data) != 0 {
+		err = json.Unmarshal(result.Data, out)
+		if err != nil {
+			return err
+		}
+	}
+	if len(result.Errors) != 0 {
+		return result.Errors[0]
+	}
+	return nil
+}
+
+// Events
+
+// Events calls onEvent for every change until ctx is done or the stream ends, which is reported as an error.
+// If since is set, the events after it are sent first, so callers can resume with the id of the last event they received.
+// When too many were missed, an event whose Type is "reset" is sent instead, and callers must reload everything.
+func (c *Client) Events(ctx context.Context, since *int /* nilable */, onEvent func(Event)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+apiPrefix+"/events", nil)
+	if err != nil {
+		return err
+	}
+	if since != nil {
+		req.Header.Set("Last-Event-ID", strconv.Itoa(*since))
+	}
+	if c.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.ApiKey)
+	}
+
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return readError(res)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+
+		var event Event
+		err := json.Unmarshal([]byte(data), &event)
+		if err != nil {
+			return err
+		}
+		onEvent(event)
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+	return io.ErrUnexpectedEOF
+}
@@ -0,0 +1,254 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/client"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"tagged-fs/server"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newServer runs a server over a new database until the test ends, with a directory for its files which is its only
+// root. It returns the database so tests can create API keys.
+func newServer(t *testing.T, requireAuth bool) (string, db.DB, string) {
+	return newServerOver(t, db.Init(filepath.Join(t.TempDir(), "db.sqlite3")), requireAuth)
+}
+
+// newServerOver is newServer over an existing database
+func newServerOver(t *testing.T, db_ db.DB, requireAuth bool) (string, db.DB, string) {
+	dir := t.TempDir()
+	db_ = db_.WithOrigin(db.OriginREST)
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := httptest.NewServer(server.SetupGin(db_, server.Config{
+		Roots:        []string{dir},
+		RequireAuth:  requireAuth,
+		ThumbnailDir: t.TempDir(),
+		Context:      ctx,
+	}))
+	t.Cleanup(func() {
+		cancel()
+		ts.Close()
+	})
+	return ts.URL, db_, dir
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectError fails the test unless err is a problem with status and code
+func expectError(t *testing.T, err error, status int, code string) {
+	t.Helper()
+	var problem client.Error
+	if !errors.As(err, &problem) {
+		t.Fatalf("expected a %v %v error, got %v", status, code, err)
+	}
+	testutil.Equal(t, "status", status, problem.Status)
+	testutil.Equal(t, "code", code, problem.Code)
+}
+
+func TestTagsAndFiles(t *testing.T) {
+	url, _, dir := newServer(t, false)
+	c := client.New(url+"/", "")
+
+	root, err := c.AddTag("root", "#abcdef", false, nil)
+	check(t, err)
+	child, err := c.AddTag("child", "#000000", false, []int{root.Id})
+	check(t, err)
+	testutil.Equal(t, "color", "#ABCDEF", root.Color)
+	testutil.Equal(t, "parents", []int{root.Id}, child.ParentIds)
+
+	name := "renamed"
+	revision, err := c.EditTag(root.Id, client.TagUpdate{Name: &name}, &root.Revision)
+	check(t, err)
+	testutil.Equal(t, "revision", root.Revision+1, revision)
+	_, err = c.EditTag(root.Id, client.TagUpdate{Name: &name}, &root.Revision)
+	expectError(t, err, http.StatusPreconditionFailed, "stale_revision")
+	tag, err := c.GetTag(root.Id)
+	check(t, err)
+	testutil.Equal(t, "name", "renamed", tag.Name)
+
+	file, err := c.AddFile(testutil.WriteFile(t, dir, "a.txt", "content"), []int{child.Id})
+	check(t, err)
+	testutil.Equal(t, "name", "a", file.Name)
+	files, err := c.SearchFiles(client.FileSearch{TagIds: []int{root.Id}})
+	check(t, err)
+	testutil.Equal(t, "files of the hierarchy", 1, len(files))
+
+	revision, err = c.UpdateFile(file.Id, []int{root.Id}, &file.Revision)
+	check(t, err)
+	testutil.Equal(t, "revision", file.Revision+1, revision)
+	_, err = c.UpdateFile(file.Id, nil, &file.Revision)
+	expectError(t, err, http.StatusPreconditionFailed, "stale_revision")
+
+	content, err := c.FileContent(file.Id)
+	check(t, err)
+	data, err := io.ReadAll(content)
+	content.Close()
+	check(t, err)
+	testutil.Equal(t, "content", "content", string(data))
+
+	check(t, c.RmFile(file.Id))
+	_, err = c.GetFile(file.Id)
+	expectError(t, err, http.StatusNotFound, "not_found")
+	trash, err := c.ListTrash()
+	check(t, err)
+	testutil.Equal(t, "trashed files", 1, len(trash.Files))
+	check(t, c.RestoreFile(file.Id))
+	files, err = c.ListFiles()
+	check(t, err)
+	testutil.Equal(t, "files", 1, len(files))
+}
+
+func TestErrors(t *testing.T) {
+	url, _, _ := newServer(t, false)
+	c := client.New(url, "")
+
+	_, err := c.GetTag(999)
+	expectError(t, err, http.StatusNotFound, "not_found")
+	_, err = c.AddTag("red", "red", false, nil)
+	expectError(t, err, http.StatusBadRequest, "invalid_request")
+	_, err = c.AddFile(testutil.WriteFile(t, t.TempDir(), "outside.txt", ""), nil)
+	expectError(t, err, http.StatusForbidden, "forbidden_path")
+	_, err = c.Undo()
+	expectError(t, err, http.StatusConflict, "conflict")
+}
+
+func TestHistory(t *testing.T) {
+	url, _, _ := newServer(t, false)
+	c := client.New(url, "")
+
+	tag, err := c.AddTag("tag", "#000000", false, nil)
+	check(t, err)
+
+	operation, err := c.Undo()
+	check(t, err)
+	testutil.Equal(t, "undone", "Add tag 'tag'", operation.Description)
+	_, err = c.GetTag(tag.Id)
+	expectError(t, err, http.StatusNotFound, "not_found")
+
+	_, err = c.Redo()
+	check(t, err)
+	_, err = c.GetTag(tag.Id)
+	check(t, err)
+
+	operations, err := c.History(10)
+	check(t, err)
+	testutil.Equal(t, "operations", 1, len(operations))
+}
+
+func TestApiKey(t *testing.T) {
+	url, db_, _ := newServer(t, true)
+	_, readWrite := action.CreateApiKey(db_, "read-write", db.ScopeReadWrite, nil)
+	_, read := action.CreateApiKey(db_, "read", db.ScopeRead, nil)
+
+	_, err := client.New(url, "").ListTags()
+	expectError(t, err, http.StatusUnauthorized, "unauthorized")
+	_, err = client.New(url, "tfs_invalid").ListTags()
+	expectError(t, err, http.StatusUnauthorized, "unauthorized")
+
+	_, err = client.New(url, readWrite).AddTag("tag", "#000000", false, nil)
+	check(t, err)
+	tags, err := client.New(url, read).ListTags()
+	check(t, err)
+	testutil.Equal(t, "tags", 1, len(tags))
+	_, err = client.New(url, read).AddTag("refused", "#000000", false, nil)
+	expectError(t, err, http.StatusForbidden, "read_only_key")
+}
+
+func TestGraphQL(t *testing.T) {
+	url, _, _ := newServer(t, false)
+	c := client.New(url, "")
+
+	var added struct {
+		AddTag struct{ Id int }
+	}
+	check(t, c.GraphQL(`mutation { addTag(name: "tag", color: "#000000") { id } }`, nil, &added))
+
+	var result struct {
+		Tag struct{ Name string }
+	}
+	check(t, c.GraphQL("query($id: Int!) { tag(id: $id) { name } }", map[string]any{"id": added.AddTag.Id}, &result))
+	testutil.Equal(t, "name", "tag", result.Tag.Name)
+
+	err := c.GraphQL("{ tag(id: 999) { name } }", nil, nil)
+	var graphqlError client.GraphqlError
+	if !errors.As(err, &graphqlError) {
+		t.Fatalf("expected a GraphqlError, got %v", err)
+	}
+	testutil.Equal(t, "code", "not_found", graphqlError.Extensions.Code)
+}
+
+func TestEvents(t *testing.T) {
+	url, _, _ := newServer(t, false)
+	c := client.New(url, "")
+
+	first, err := c.AddTag("first", "#000000", false, nil)
+	check(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan client.Event)
+	done := make(chan error)
+	go func() {
+		// Resuming from the start sends the first tag's event before the second's
+		since := 0
+		done <- c.Events(ctx, &since, func(event client.Event) { events <- event })
+	}()
+
+	testutil.Equal(t, "missed event", first.Id, (<-events).EntityId)
+	second, err := c.AddTag("second", "#000000", false, nil)
+	check(t, err)
+	event := <-events
+	testutil.Equal(t, "event", client.Event{Id: event.Id, Type: "created", Entity: "tag", EntityId: second.Id, Operation: "tag.add", Origin: "rest", CreatedAt: event.CreatedAt}, event)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the stream to end with the context, got %v", err)
+	}
+}
+
+// A client that missed too many changes is told to reload everything, then receives the new changes
+func TestEventsReset(t *testing.T) {
+	// Changed before the server starts, so it does not publish them
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	tx := db_.Begin()
+	for i := 0; i < 1001; i++ {
+		tx.RecordAudit("tag.add", db.Change{Entity: db.EntityTag, Id: i + 1, Before: []byte("null"), After: []byte(`{"name": "tag"}`)})
+	}
+	tx.Commit()
+	lastId := db_.LastAuditId()
+
+	url, _, _ := newServerOver(t, db_, false)
+	c := client.New(url, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan client.Event)
+	go func() {
+		since := 0
+		c.Events(ctx, &since, func(event client.Event) { events <- event })
+	}()
+
+	testutil.Equal(t, "reset", client.Event{Id: lastId, Type: "reset"}, <-events)
+	tag, err := c.AddTag("tag", "#000000", false, nil)
+	check(t, err)
+	testutil.Equal(t, "event after the reset", tag.Id, (<-events).EntityId)
+}
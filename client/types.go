@@ -0,0 +1,161 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The types mirror the schemas of server/openapi.json
+
+type Tag struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Color     string     `json:"color"`
+	ParentIds []int      `json:"parentIds"`
+	OwnerId   *int       `json:"ownerId"`
+	Private   bool       `json:"private"`
+	Revision  int        `json:"revision"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// TagUpdate holds the fields EditTag changes, nil fields are left unchanged
+type TagUpdate struct {
+	Name      *string `json:"name,omitempty"`
+	Color     *string `json:"color,omitempty"`
+	Private   *bool   `json:"private,omitempty"`
+	ParentIds *[]int  `json:"parentIds,omitempty"`
+}
+
+type File struct {
+	Id        int        `json:"id"`
+	Path      string     `json:"path"`
+	Name      string     `json:"name"`
+	Tags      []Tag      `json:"tags"`
+	Revision  int        `json:"revision"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	MimeType  string     `json:"mimeType"`
+	// Kind is one of image, video, audio, document, archive or other
+	Kind string `json:"kind"`
+	// Metadata holds the values read from the file's content, such as width, camera_model or artist
+	Metadata map[string]string `json:"metadata"`
+}
+
+// FileSearch selects the files matching every condition that is set
+type FileSearch struct {
+	// Name are the starts of words of the file's name or path, best matches coming first
+	Name *string `json:"name,omitempty"`
+	// Fuzzy lets the words of Name have a typo or two, the server answers 400 if it was built without FTS5
+	Fuzzy bool `json:"fuzzy,omitempty"`
+	// TagIds must all be on the file, directly or through one of their children
+	TagIds []int `json:"tags,omitempty"`
+	// Metadata maps metadata keys to part of their value
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Kinds are the kinds the file can be of, any if empty
+	Kinds []string `json:"kinds,omitempty"`
+	// Text are words the file's content must contain, the server answers 400 if it was built without FTS5
+	Text *string `json:"text,omitempty"`
+}
+
+type Change struct {
+	Entity string          `json:"entity"`
+	Id     int             `json:"id"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type Operation struct {
+	Id          int       `json:"id"`
+	Description string    `json:"description"`
+	Changes     []Change  `json:"changes"`
+	Undone      bool      `json:"undone"`
+	UserId      *int      `json:"userId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type Trash struct {
+	Tags  []Tag  `json:"tags"`
+	Files []File `json:"files"`
+}
+
+type AuditEntry struct {
+	Id        int             `json:"id"`
+	Operation string          `json:"operation"`
+	Entity    string          `json:"entity"`
+	EntityId  int             `json:"entityId"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Origin    string          `json:"origin"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// AuditQuery filters SearchAudit, nil fields match everything
+type AuditQuery struct {
+	FileId  *int
+	TagId   *int
+	Since   *time.Time
+	Until   *time.Time
+	Origin  *string
+	Page    int
+	PerPage int
+}
+
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"perPage"`
+}
+
+// Event notifies that a tag, file or the tag order changed
+type Event struct {
+	Id        int       `json:"id"`
+	Type      string    `json:"type"`
+	Entity    string    `json:"entity"`
+	EntityId  int       `json:"entityId"`
+	Operation string    `json:"operation"`
+	Origin    string    `json:"origin"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Webhook receives the events it subscribed to as signed POST requests
+type Webhook struct {
+	Id     int      `json:"id"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only returned by AddWebhook
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	Id            int             `json:"id"`
+	WebhookId     int             `json:"webhookId"`
+	Event         string          `json:"event"`
+	AuditId       int             `json:"auditId"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	StatusCode    *int            `json:"statusCode"`
+	Error         *string         `json:"error"`
+	NextAttemptAt *time.Time      `json:"nextAttemptAt"`
+	DeliveredAt   *time.Time      `json:"deliveredAt"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+// GraphqlError is an error of a GraphQL query, Code is in extensions.code of the response
+type GraphqlError struct {
+	Message    string `json:"message"`
+	Path       []any  `json:"path"`
+	Extensions struct {
+		Code string `json:"code"`
+	} `json:"extensions"`
+}
+
+func (e GraphqlError) Error() string {
+	if e.Extensions.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%v: %v", e.Extensions.Code, e.Message)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func CreateApiKey(db db.DB, name string, scope string, user *string /* nilable */) {
+	var userId *int = nil
+	if user != nil {
+		id := action.UserId(db, *user)
+		userId = &id
+	}
+
+	id, secret := action.CreateApiKey(db, name, scope, userId)
+
+	fmt.Printf("Created API key %v. Store it now, it cannot be shown again:\n%v\n", id, secret)
+}
+
+func formatOptionalTime(t *time.Time /* nilable */) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func formatOptionalInt(i *int /* nilable */) string {
+	if i == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", *i)
+}
+
+func ListApiKeys(db db.DB) {
+	keys := action.ListApiKeys(db)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Id", "Name", "Scope", "User Id", "Created At", "Last Used At", "Revoked At"})
+
+	for _, key := range keys {
+		table.Append([]string{
+			fmt.Sprintf("%v", key.Id),
+			key.Name,
+			key.Scope,
+			formatOptionalInt(key.UserId),
+			formatOptionalTime(&key.CreatedAt),
+			formatOptionalTime(key.LastUsedAt),
+			formatOptionalTime(key.RevokedAt),
+		})
+	}
+	table.Render()
+}
+
+func RevokeApiKey(db db.DB, id int) {
+	action.RevokeApiKey(db, id)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// since and until are ignored when zero
+func Log(db_ db.DB, path *string /* nilable */, tagId *int /* nilable */, since time.Time, until time.Time, origin *string /* nilable */, limit int, offset int) {
+	filter := db.AuditFilter{TagId: tagId, Origin: origin}
+
+	if path != nil {
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			panic(err.Error())
+		}
+		// By path rather than id, as the file may be in the trash or purged
+		filter.FilePath = &abs
+	}
+
+	if !since.IsZero() {
+		filter.Since = &since
+	}
+	// until is a day, include all of it
+	if !until.IsZero() {
+		endOfDay := until.Add(24*time.Hour - time.Second)
+		filter.Until = &endOfDay
+	}
+
+	entries, total := action.SearchAudit(db_, filter, limit, offset)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Date", "Origin", "Operation", "Entity", "Id", "Before", "After"})
+	table.SetAutoWrapText(false)
+
+	for _, e := range entries {
+		table.Append([]string{
+			e.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			e.Origin,
+			e.Operation,
+			e.Entity,
+			fmt.Sprintf("%v", e.EntityId),
+			string(e.Before),
+			string(e.After),
+		})
+	}
+	table.Render()
+
+	fmt.Printf("Showing %v of %v entries\n", len(entries), total)
+}
@@ -4,9 +4,13 @@ import (
 	"database/sql"
 	_ "embed"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"tagged-fs/action"
+	"tagged-fs/config"
 	"tagged-fs/db"
+	"time"
 
 	"github.com/alecthomas/kong"
 	_ "github.com/mattn/go-sqlite3"
@@ -31,14 +35,35 @@ func (n *NullInt64) UnmarshalText(text []byte) error {
 	}
 }
 
+// noDryRun lists the commands whose effects a dry run could not roll back: files written or deleted, a secret printed
+// that would not work, or requests sent
+var noDryRun = map[string]string{
+	"serve":                           "serve",
+	"thumbs rebuild":                  "thumbs rebuild",
+	"apikey create <name>":            "apikey create",
+	"webhook redeliver <delivery-id>": "webhook redeliver",
+}
+
+// checkDryRun returns an error if --dry-run was given to a command listed in noDryRun
+func checkDryRun(command string, dryRun bool) error {
+	if name, ok := noDryRun[command]; ok && dryRun {
+		return fmt.Errorf("--dry-run is not available for %v", name)
+	}
+	return nil
+}
+
 var CLI struct {
-	Db string `short:"d" help:"Database file." default:"tagged-fs.sqlite3"`
+	Config string `short:"c" help:"Config file. Defaults to tagged-fs/config.toml in $XDG_CONFIG_HOME (~/.config), ignored if missing." type:"existingfile"`
+	Db     string `short:"d" help:"Database file. Defaults to the config file's db, then tagged-fs/tagged-fs.sqlite3 in $XDG_DATA_HOME (~/.local/share). Takes precedence over the config file's postgres."`
+	DryRun bool   `help:"Show what the command would change without changing anything. Not available for serve, thumbs rebuild, apikey create and webhook redeliver."`
+	AsUser string `help:"Act as this user, only seeing shared tags and their private tags."`
 
 	Tag struct {
 		Add struct {
 			Name     string `arg:"" required:""`
 			Color    string `arg:"" required:"" help:"Hex color code."`
 			ParentId *int   `short:"p" help:"Id of parent tag."`
+			Private  bool   `help:"Only show the tag to its owner, requires --as-user."`
 		} `cmd:"" help:"Add a tag"`
 		Ls   struct{} `cmd:"" help:"List all tags"`
 		Edit struct {
@@ -46,6 +71,8 @@ var CLI struct {
 			Name     *string
 			Color    *string    `help:"Hex color code."`
 			ParentId *NullInt64 `short:"p" help:"Id of parent tag. Set to -1 for NULL"`
+			Private  *bool      `help:"Only show the tag to its owner." negatable:""`
+			Revision *int       `help:"Only edit if the tag is still at this revision."`
 		} `cmd:"" help:"Edit a tag"`
 		Rm struct {
 			TagId int `arg:"" required:"" help:"Tag ID"`
@@ -58,30 +85,158 @@ var CLI struct {
 			Tags []int  `arg:"" required:"" help:"Tag IDs."`
 		} `cmd:"" help:"Add a file"`
 		Ls struct {
-			Name *string `help:"Search by name."`
-			Tags []int   `help:"Search by tags (takes into account parent tags)."`
+			Name  *string           `help:"Search by the start of words of the name or path, best matches first."`
+			Fuzzy bool              `help:"Let the words of the name have a typo or two. Requires a build with -tags sqlite_fts5."`
+			Tags  []int             `help:"Search by tags, files must have every tag or one of its children."`
+			Meta  map[string]string `help:"Search by metadata, as key=part of the value, can be repeated."`
+			Text  *string           `help:"Search by words in the content of text, Markdown, source code and PDF files. Requires a build with -tags sqlite_fts5."`
+			Kind  []string          `help:"Search by kind (image, video, audio, document, archive or other), can be repeated. The name can also contain kind:video."`
 		} `cmd:"" help:"List and search all files"`
 		Edit struct {
-			Path string `arg:"" required:"" type:"existingfile"`
-			Tags []int  `arg:"" required:"" help:"Tag IDs"`
+			Path     string `arg:"" required:"" type:"existingfile"`
+			Tags     []int  `arg:"" required:"" help:"Tag IDs"`
+			Revision *int   `help:"Only edit if the file is still at this revision."`
 		} `cmd:"" help:"Edit file's tags"`
 		Rm struct {
 			Path string `arg:"" required:"" type:"existingfile"`
 		} `cmd:"" help:"Delete a file"`
 	} `cmd:"" help:"File commands."`
+
+	Trash struct {
+		Ls      struct{} `cmd:"" help:"List trashed tags and files"`
+		Restore struct {
+			Kind string `arg:"" required:"" enum:"tag,file" help:"tag or file."`
+			Id   int    `arg:"" required:"" help:"Tag or file ID."`
+		} `cmd:"" help:"Restore a tag or file from the trash"`
+		Purge struct {
+			Tag       *int `help:"Only purge this tag."`
+			File      *int `help:"Only purge this file."`
+			OlderThan int  `help:"Only purge items trashed more than this many days ago."`
+		} `cmd:"" help:"Permanently delete trashed tags and files"`
+		Retention struct {
+			Days *int `arg:"" optional:"" help:"Days trashed items are kept before serve or the GUI purge them, 0 to keep them forever."`
+		} `cmd:"" help:"Show or set how long trashed items are kept"`
+	} `cmd:"" help:"Trash commands."`
+
+	Apikey struct {
+		Create struct {
+			Name  string  `arg:"" required:"" help:"Name identifying the key's owner in the audit log."`
+			Scope string  `default:"read-write" enum:"read,read-write" help:"read or read-write."`
+			User  *string `help:"Only give access to what this user can see. Keys without a user can see everything."`
+		} `cmd:"" help:"Create an API key for the server"`
+		Ls     struct{} `cmd:"" help:"List API keys"`
+		Revoke struct {
+			Id int `arg:"" required:"" help:"API key ID."`
+		} `cmd:"" help:"Revoke an API key"`
+	} `cmd:"" help:"API key commands."`
+
+	Webhook struct {
+		Add struct {
+			Url    string   `arg:"" required:""`
+			Events []string `name:"event" short:"e" required:"" help:"Event to send (file.added, file.deleted, file.tagged, file.untagged, tag.added, tag.updated, tag.deleted or * for all), can be repeated."`
+			Secret string   `help:"Key used to sign payloads, generated if not given."`
+		} `cmd:"" help:"Add a webhook"`
+		Ls struct{} `cmd:"" help:"List webhooks"`
+		Rm struct {
+			Id int `arg:"" required:"" help:"Webhook ID."`
+		} `cmd:"" help:"Delete a webhook"`
+		Log struct {
+			Id    int `arg:"" required:"" help:"Webhook ID."`
+			Limit int `short:"n" default:"20" help:"Number of deliveries to show."`
+		} `cmd:"" help:"Show the recent deliveries of a webhook"`
+		Redeliver struct {
+			DeliveryId int `arg:"" required:"" help:"Delivery ID."`
+		} `cmd:"" help:"Send a delivery again"`
+	} `cmd:"" help:"Webhook commands."`
+
+	User struct {
+		Add struct {
+			Name string `arg:"" required:""`
+		} `cmd:"" help:"Add a user"`
+		Ls struct{} `cmd:"" help:"List users"`
+	} `cmd:"" help:"User commands."`
+
+	Serve struct {
+		Listen string   `help:"Address to listen on. Defaults to the config file's listen, then 127.0.0.1:8080."`
+		Auth   *bool    `help:"Require an API key (see the apikey commands). Mandatory when listening on a non-loopback address." negatable:""`
+		Root   []string `help:"Directory files can be added, served and opened from, can be repeated. Defaults to the config file's roots, then every directory." type:"existingdir"`
+	} `cmd:"" help:"Run the REST server until interrupted"`
+
+	Thumbs struct {
+		Rebuild struct {
+			Size []int `default:"256" help:"Size to render, can be repeated (64, 128, 256, 512 or 1024)."`
+		} `cmd:"" help:"Delete the cached thumbnails and render those of every file"`
+	} `cmd:"" help:"Thumbnail commands."`
+
+	Metadata struct {
+		Show struct {
+			Path string `arg:"" required:"" type:"existingfile"`
+		} `cmd:"" help:"Show a file's metadata"`
+		Keys    struct{} `cmd:"" help:"List metadata keys and how many files have them"`
+		Rebuild struct{} `cmd:"" help:"Read the metadata of every file again"`
+	} `cmd:"" help:"Metadata commands."`
+
+	Undo    struct{} `cmd:"" help:"Undo the last change"`
+	Redo    struct{} `cmd:"" help:"Redo the last undone change"`
+	History struct {
+		Limit int `short:"n" default:"20" help:"Number of operations to show."`
+	} `cmd:"" help:"List recent changes"`
+	Log struct {
+		File   *string   `help:"Only show changes to this file."`
+		Tag    *int      `help:"Only show changes to this tag or its assignments."`
+		Since  time.Time `help:"Only show changes from this day (YYYY-MM-DD, UTC)." format:"2006-01-02"`
+		Until  time.Time `help:"Only show changes up to this day (YYYY-MM-DD, UTC)." format:"2006-01-02"`
+		Origin *string   `help:"Only show changes made from this client (cli, gui, rest)."`
+		Limit  int       `short:"n" default:"50" help:"Number of entries to show."`
+		Offset int       `help:"Number of entries to skip."`
+	} `cmd:"" help:"Show the audit log"`
+}
+
+// openDB opens the PostgreSQL database of the config file, or the SQLite file of --db or the config file, and returns
+// it with its name for logs, which leaves out the password of a DSN
+func openDB(cfg config.Config) (db.DB, string) {
+	if cfg.Postgres != "" && CLI.Db == "" {
+		return db.OpenPostgres(cfg.Postgres), "the PostgreSQL database of the config file"
+	}
+
+	dbPath, err := cfg.DbPath(CLI.Db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db.Init(dbPath), dbPath
 }
 
 func main() {
 
 	ctx := kong.Parse(&CLI)
 
-	DB := db.Init(CLI.Db)
+	cfg, err := config.Load(CLI.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := checkDryRun(ctx.Command(), CLI.DryRun); err != nil {
+		ctx.Fatalf("%v", err)
+	}
+	DB, name := openDB(cfg)
+
+	if ctx.Command() == "serve" {
+		Serve(DB, name, cfg, CLI.Serve.Listen, CLI.Serve.Auth, CLI.Serve.Root)
+		return
+	}
+
+	if CLI.AsUser != "" {
+		DB = DB.WithUser(action.UserId(DB, CLI.AsUser))
+	}
+	if CLI.DryRun {
+		DB = DB.BeginDryRun()
+		defer DB.Rollback()
+	}
 
 	switch ctx.Command() {
 	case "tag add <name> <color>":
-		AddTag(DB, CLI.Tag.Add.Name, CLI.Tag.Add.Color, CLI.Tag.Add.ParentId)
+		AddTag(DB, CLI.Tag.Add.Name, CLI.Tag.Add.Color, CLI.Tag.Add.Private, CLI.Tag.Add.ParentId)
 	case "tag edit <tag-id>":
-		EditTag(DB, CLI.Tag.Edit.TagId, CLI.Tag.Edit.Name, CLI.Tag.Edit.Color, (*sql.NullInt64)(CLI.Tag.Edit.ParentId))
+		EditTag(DB, CLI.Tag.Edit.TagId, CLI.Tag.Edit.Revision, CLI.Tag.Edit.Name, CLI.Tag.Edit.Color, CLI.Tag.Edit.Private, (*sql.NullInt64)(CLI.Tag.Edit.ParentId))
 	case "tag ls":
 		ListTags(DB)
 	case "tag rm <tag-id>":
@@ -90,12 +245,67 @@ func main() {
 	case "file add <path> <tags>":
 		AddFile(DB, CLI.File.Add.Path, CLI.File.Add.Tags)
 	case "file edit <path> <tags>":
-		EditFile(DB, CLI.File.Edit.Path, CLI.File.Edit.Tags)
+		EditFile(DB, CLI.File.Edit.Path, CLI.File.Edit.Revision, CLI.File.Edit.Tags)
 	case "file ls":
-		ListFiles(DB, CLI.File.Ls.Name, CLI.File.Ls.Tags)
+		ListFiles(DB, db.FileSearch{Name: CLI.File.Ls.Name, TagIds: CLI.File.Ls.Tags, Metadata: CLI.File.Ls.Meta, Kinds: CLI.File.Ls.Kind, Text: CLI.File.Ls.Text, Fuzzy: CLI.File.Ls.Fuzzy})
 	case "file rm <path>":
 		RmFile(DB, CLI.File.Rm.Path)
+
+	case "trash ls":
+		ListTrash(DB)
+	case "trash restore <kind> <id>":
+		RestoreTrash(DB, CLI.Trash.Restore.Kind, CLI.Trash.Restore.Id)
+	case "trash purge":
+		PurgeTrash(DB, CLI.Trash.Purge.Tag, CLI.Trash.Purge.File, CLI.Trash.Purge.OlderThan)
+	case "trash retention", "trash retention <days>":
+		TrashRetention(DB, CLI.Trash.Retention.Days)
+
+	case "apikey create <name>":
+		CreateApiKey(DB, CLI.Apikey.Create.Name, CLI.Apikey.Create.Scope, CLI.Apikey.Create.User)
+	case "apikey ls":
+		ListApiKeys(DB)
+	case "apikey revoke <id>":
+		RevokeApiKey(DB, CLI.Apikey.Revoke.Id)
+
+	case "webhook add <url>":
+		AddWebhook(DB, CLI.Webhook.Add.Url, CLI.Webhook.Add.Events, CLI.Webhook.Add.Secret)
+	case "webhook ls":
+		ListWebhooks(DB)
+	case "webhook rm <id>":
+		RmWebhook(DB, CLI.Webhook.Rm.Id)
+	case "webhook log <id>":
+		WebhookLog(DB, CLI.Webhook.Log.Id, CLI.Webhook.Log.Limit)
+	case "webhook redeliver <delivery-id>":
+		RedeliverWebhook(DB, CLI.Webhook.Redeliver.DeliveryId)
+
+	case "user add <name>":
+		AddUser(DB, CLI.User.Add.Name)
+	case "user ls":
+		ListUsers(DB)
+
+	case "thumbs rebuild":
+		RebuildThumbnails(DB, cfg.ThumbnailDir(), CLI.Thumbs.Rebuild.Size)
+
+	case "metadata show <path>":
+		ShowMetadata(DB, CLI.Metadata.Show.Path)
+	case "metadata keys":
+		MetadataKeys(DB)
+	case "metadata rebuild":
+		RebuildMetadata(DB)
+
+	case "undo":
+		Undo(DB)
+	case "redo":
+		Redo(DB)
+	case "history":
+		History(DB, CLI.History.Limit)
+	case "log":
+		Log(DB, CLI.Log.File, CLI.Log.Tag, CLI.Log.Since, CLI.Log.Until, CLI.Log.Origin, CLI.Log.Limit, CLI.Log.Offset)
 	default:
 		panic(fmt.Sprintf("Unknown command: '%v'", ctx.Command()))
 	}
+
+	if CLI.DryRun {
+		PrintDryRun(DB)
+	}
 }
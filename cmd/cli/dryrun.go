@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"tagged-fs/db"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func PrintDryRun(db db.DB) {
+	summary := db.DryRunSummary()
+
+	fmt.Println("Dry run, nothing was changed. The command would have made these changes:")
+
+	tables := make([]string, 0, len(summary.Tables))
+	for table := range summary.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Table", "Inserted", "Updated", "Deleted"})
+	for _, name := range tables {
+		counts := summary.Tables[name]
+		table.Append([]string{name, fmt.Sprintf("%v", counts.Inserted), fmt.Sprintf("%v", counts.Updated), fmt.Sprintf("%v", counts.Deleted)})
+	}
+	table.Render()
+
+	rows := tablewriter.NewWriter(os.Stdout)
+	rows.SetHeader([]string{"Table", "Operation", "Before", "After"})
+	rows.SetAutoWrapText(false)
+	for _, change := range summary.Rows {
+		rows.Append([]string{change.Table, change.Operation, string(change.Before), string(change.After)})
+	}
+	rows.Render()
+}
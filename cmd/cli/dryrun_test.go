@@ -0,0 +1,59 @@
+package main
+
+import (
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+// The commands a rollback cannot undo refuse --dry-run, the others accept it
+func TestCheckDryRun(t *testing.T) {
+	parser, err := kong.New(&CLI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args    []string
+		refused bool
+	}{
+		{[]string{"serve"}, true},
+		{[]string{"thumbs", "rebuild"}, true},
+		{[]string{"apikey", "create", "phone"}, true},
+		{[]string{"webhook", "redeliver", "1"}, true},
+		{[]string{"tag", "add", "tag", "#000000"}, false},
+		{[]string{"trash", "purge"}, false},
+	} {
+		CLI.DryRun = false
+		ctx, err := parser.Parse(append(test.args, "--dry-run"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, ctx.Command()+" refused", test.refused, checkDryRun(ctx.Command(), CLI.DryRun) != nil)
+
+		CLI.DryRun = false
+		ctx, err = parser.Parse(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, ctx.Command()+" refused without --dry-run", false, checkDryRun(ctx.Command(), CLI.DryRun) != nil)
+	}
+}
+
+// A command run in a dry run changes nothing, and the summary tells what it would have changed
+func TestDryRunRollsBack(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+
+	tx := db_.BeginDryRun()
+	AddTag(tx, "tag", "#000000", false, nil)
+	summary := tx.DryRunSummary()
+	tx.Rollback()
+
+	testutil.Equal(t, "tag changes", db.TableChanges{Inserted: 1}, summary.Tables["tag"])
+	testutil.Equal(t, "rows", 1, len(summary.Rows))
+	testutil.Equal(t, "tags", 0, len(action.ListTags(db_)))
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"strings"
 	"tagged-fs/action"
@@ -9,32 +10,32 @@ import (
 	"github.com/olekukonko/tablewriter"
 )
 
-func AddFile(db db.DB, path string, tagIds []int) {
+func AddFile[S db.Store[S]](db S, path string, tagIds []int) {
 	action.AddFile(db, path, tagIds)
 }
 
-func EditFile(db db.DB, path string, tagIds []int) {
+func EditFile[S db.Store[S]](db S, path string, revision *int /* nilable */, tagIds []int) {
 	id := db.FileIdFromPath(path)
-	action.EditFile(db, id, tagIds)
+	action.EditFile(db, id, revision, tagIds)
 }
 
-func ListFiles(db db.DB, name *string /* nilable */, tagIds []int) {
-	files := action.ListFiles(db, name, tagIds)
+func ListFiles[S db.Store[S]](db_ S, search db.FileSearch) {
+	files := action.ListFiles(db_, search)
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Path", "Name", "Tags"})
+	table.SetHeader([]string{"Path", "Name", "Kind", "Tags", "Revision"})
 	for _, f := range files {
 		tagNames := make([]string, len(f.Tags))
 		for i, t := range f.Tags {
 			tagNames[i] = t.Name
 		}
 
-		table.Append([]string{f.Path, f.Name, strings.Join(tagNames, ", ")})
+		table.Append([]string{f.Path, f.Name, f.Kind, strings.Join(tagNames, ", "), fmt.Sprintf("%v", f.Revision)})
 	}
 	table.Render()
 }
 
-func RmFile(db db.DB, path string) {
+func RmFile[S db.Store[S]](db S, path string) {
 	id := db.FileIdFromPath(path)
 	action.RmFile(db, id)
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tagged-fs/action"
+	"tagged-fs/db"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func Undo(db db.DB) {
+	op := action.Undo(db)
+	fmt.Printf("Undone: %v\n", op.Description)
+}
+
+func Redo(db db.DB) {
+	op := action.Redo(db)
+	fmt.Printf("Redone: %v\n", op.Description)
+}
+
+func History(db db.DB, limit int) {
+	ops := action.History(db, limit)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Id", "Date", "Description", "Undone"})
+
+	for _, op := range ops {
+		undone := ""
+		if op.Undone {
+			undone = "yes"
+		}
+
+		table.Append([]string{fmt.Sprintf("%v", op.Id), op.CreatedAt.Local().Format("2006-01-02 15:04:05"), op.Description, undone})
+	}
+	table.Render()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"tagged-fs/action"
+	"tagged-fs/db"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func ShowMetadata(db db.DB, path string) {
+	file := action.GetFile(db, db.FileIdFromPath(path))
+
+	keys := make([]string, 0, len(file.Metadata))
+	for key := range file.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Key", "Value"})
+	for _, key := range keys {
+		table.Append([]string{key, file.Metadata[key]})
+	}
+	table.Render()
+}
+
+func MetadataKeys(db db.DB) {
+	counts := action.MetadataKeys(db)
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Key", "Files"})
+	for _, key := range keys {
+		table.Append([]string{key, fmt.Sprintf("%v", counts[key])})
+	}
+	table.Render()
+}
+
+func RebuildMetadata(db db.DB) {
+	result := action.RebuildMetadata(db)
+
+	paths := make([]string, 0, len(result.Failed))
+	for path := range result.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("%v: %v\n", path, result.Failed[path])
+	}
+
+	fmt.Printf("Read the metadata of %v files, %v failed\n", result.Updated, len(result.Failed))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"tagged-fs/action"
+	"tagged-fs/config"
+	"tagged-fs/db"
+	"tagged-fs/server"
+	"time"
+)
+
+// shutdownTimeout is how long requests in progress are given to finish on SIGINT or SIGTERM
+const shutdownTimeout = 10 * time.Second
+
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve runs the REST server until SIGINT or SIGTERM, flags taking precedence over the config file
+func Serve(db_ db.DB, name string, cfg config.Config, listen string, auth *bool /* nilable */, roots []string) {
+	if listen == "" {
+		listen = cfg.Listen
+	}
+	if listen == "" {
+		listen = config.DefaultListen
+	}
+	requireAuth := cfg.Auth
+	if auth != nil {
+		requireAuth = *auth
+	}
+	if len(roots) == 0 {
+		roots = cfg.Roots
+	}
+
+	if !requireAuth && !isLoopback(listen) {
+		log.Fatalf("Listening on '%v' exposes the database to the network, use --auth", listen)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db_ = db_.WithOrigin(db.OriginREST)
+	action.PurgeExpiredTrash(db_)
+
+	srv := &http.Server{
+		Addr: listen,
+		Handler: server.SetupGin(db_, server.Config{
+			Roots:        roots,
+			RequireAuth:  requireAuth,
+			ThumbnailDir: cfg.ThumbnailDir(),
+			Timeouts:     cfg.RouteTimeouts(),
+			// Event streams and background work end instead of holding up the shutdown, while other requests can finish
+			Context: ctx,
+		}),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("Serving %v on %v", name, listen)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Print("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+	}
+}
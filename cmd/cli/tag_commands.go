@@ -10,28 +10,28 @@ import (
 	"github.com/olekukonko/tablewriter"
 )
 
-func AddTag(db db.DB, name string, color string, parentId *int /* nilable */) {
+func AddTag[S db.Store[S]](db S, name string, color string, private bool, parentId *int /* nilable */) {
 	var parentIds []int = nil
 	if parentId != nil {
 		parentIds = []int{*parentId}
 	}
 
-	action.AddTag(db, name, color, parentIds)
+	action.AddTag(db, name, color, private, parentIds)
 }
 
-func ListTags(db db.DB) {
+func ListTags[S db.Store[S]](db S) {
 	tags := action.ListTags(db)
 
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Id", "Name", "Color", "Parent Id"})
+	table.SetHeader([]string{"Id", "Name", "Color", "Parent Id", "Owner Id", "Private", "Revision"})
 
 	for _, tag := range tags {
-		table.Append([]string{fmt.Sprintf("%v", tag.Id), tag.Name, tag.Color, fmt.Sprintf("%v", tag.ParentIds)})
+		table.Append([]string{fmt.Sprintf("%v", tag.Id), tag.Name, tag.Color, fmt.Sprintf("%v", tag.ParentIds), formatOptionalInt(tag.OwnerId), fmt.Sprintf("%v", tag.Private), fmt.Sprintf("%v", tag.Revision)})
 	}
 	table.Render()
 }
 
-func EditTag(db db.DB, tagId int, name *string /* nilable */, color *string /* nilable */, parentId *sql.NullInt64 /* nilable */) {
+func EditTag[S db.Store[S]](db S, tagId int, revision *int /* nilable */, name *string /* nilable */, color *string /* nilable */, private *bool /* nilable */, parentId *sql.NullInt64 /* nilable */) {
 	var parentIds *[]int = nil
 	if parentId != nil {
 		if parentId.Valid {
@@ -41,9 +41,9 @@ func EditTag(db db.DB, tagId int, name *string /* nilable */, color *string /* n
 		}
 	}
 
-	action.EditTag(db, tagId, name, color, parentIds)
+	action.EditTag(db, tagId, revision, name, color, private, parentIds)
 }
 
-func RmTag(db db.DB, tagId int) {
+func RmTag[S db.Store[S]](db S, tagId int) {
 	action.RmTag(db, tagId)
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"tagged-fs/thumbnail"
+)
+
+func RebuildThumbnails(db db.DB, dir string, sizes []int) {
+	if !thumbnail.HasFfmpeg() {
+		fmt.Println("ffmpeg is not installed, videos will have no thumbnail")
+	}
+
+	result := action.RebuildThumbnails(db, action.NewThumbnailCache(dir), sizes)
+
+	paths := make([]string, 0, len(result.Failed))
+	for path := range result.Failed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("%v: %v\n", path, result.Failed[path])
+	}
+
+	fmt.Printf("Generated %v thumbnails in %v, %v files are not images or videos, %v failed\n", result.Generated, dir, result.Unsupported, len(result.Failed))
+}
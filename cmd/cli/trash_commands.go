@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func ListTrash(db db.DB) {
+	tags, files := action.ListTrash(db)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Id", "Name", "Deleted At"})
+
+	for _, tag := range tags {
+		table.Append([]string{"tag", fmt.Sprintf("%v", tag.Id), tag.Name, tag.DeletedAt.Local().Format("2006-01-02 15:04:05")})
+	}
+	for _, file := range files {
+		table.Append([]string{"file", fmt.Sprintf("%v", file.Id), file.Path, file.DeletedAt.Local().Format("2006-01-02 15:04:05")})
+	}
+	table.Render()
+}
+
+func RestoreTrash(db db.DB, kind string, id int) {
+	if kind == "tag" {
+		action.RestoreTag(db, id)
+	} else {
+		action.RestoreFile(db, id)
+	}
+}
+
+func PurgeTrash(db db.DB, tagId *int /* nilable */, fileId *int /* nilable */, olderThanDays int) {
+	if tagId != nil {
+		action.PurgeTag(db, *tagId)
+	}
+	if fileId != nil {
+		action.PurgeFile(db, *fileId)
+	}
+	if tagId != nil || fileId != nil {
+		return
+	}
+
+	tags, files := action.PurgeTrash(db, time.Duration(olderThanDays)*24*time.Hour)
+	fmt.Printf("Purged %v tags and %v files\n", tags, files)
+}
+
+func TrashRetention(db db.DB, days *int /* nilable */) {
+	if days != nil {
+		action.SetTrashRetentionDays(db, *days)
+	}
+
+	days_ := action.TrashRetentionDays(db)
+	if days_ == 0 {
+		fmt.Println("Trashed items are kept forever")
+	} else {
+		fmt.Printf("Trashed items are purged after %v days\n", days_)
+	}
+}
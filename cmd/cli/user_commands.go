@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"tagged-fs/action"
+	"tagged-fs/db"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func AddUser(db db.DB, name string) {
+	id := action.AddUser(db, name)
+
+	fmt.Printf("Created user %v\n", id)
+}
+
+func ListUsers(db db.DB) {
+	users := action.ListUsers(db)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Id", "Name", "Created At"})
+
+	for _, user := range users {
+		table.Append([]string{fmt.Sprintf("%v", user.Id), user.Name, user.CreatedAt.Local().Format("2006-01-02 15:04:05")})
+	}
+	table.Render()
+}
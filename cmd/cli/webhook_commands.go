@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"tagged-fs/action"
+	"tagged-fs/db"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+func AddWebhook(db db.DB, url string, events []string, secret string) {
+	webhook := action.AddWebhook(db, url, events, secret)
+
+	fmt.Printf("Added webhook %v. Payloads are signed with this secret, store it now, it cannot be shown again:\n%v\n", webhook.Id, webhook.Secret)
+}
+
+func ListWebhooks(db db.DB) {
+	webhooks := action.ListWebhooks(db)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Id", "Url", "Events", "Created At"})
+
+	for _, webhook := range webhooks {
+		table.Append([]string{
+			fmt.Sprintf("%v", webhook.Id),
+			webhook.Url,
+			strings.Join(webhook.Events, ", "),
+			formatOptionalTime(&webhook.CreatedAt),
+		})
+	}
+	table.Render()
+}
+
+func RmWebhook(db db.DB, id int) {
+	action.RmWebhook(db, id)
+}
+
+func WebhookLog(db db.DB, id int, limit int) {
+	deliveries := action.WebhookDeliveries(db, id, limit)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Id", "Event", "Audit Id", "Attempts", "Status", "Error", "Next Attempt At", "Delivered At"})
+
+	for _, delivery := range deliveries {
+		errorMessage := ""
+		if delivery.Error != nil {
+			errorMessage = *delivery.Error
+		}
+		table.Append([]string{
+			fmt.Sprintf("%v", delivery.Id),
+			delivery.Event,
+			fmt.Sprintf("%v", delivery.AuditId),
+			fmt.Sprintf("%v", delivery.Attempts),
+			formatOptionalInt(delivery.StatusCode),
+			errorMessage,
+			formatOptionalTime(delivery.NextAttemptAt),
+			formatOptionalTime(delivery.DeliveredAt),
+		})
+	}
+	table.Render()
+}
+
+func RedeliverWebhook(db db.DB, deliveryId int) {
+	action.RedeliverWebhook(db, deliveryId)
+}
@@ -10,11 +10,14 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"tagged-fs/action"
+	"tagged-fs/config"
 	"tagged-fs/db"
 	"tagged-fs/server"
 	"tagged-fs/web/dist"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sqweek/dialog"
 	"github.com/zserge/lorca"
 )
 
@@ -24,14 +27,52 @@ func must(err error) {
 	}
 }
 
-func main() {
-	// Open "tagged-fs.sqlite3" in executable dir
-	ex, err := os.Executable()
+// openDB opens the PostgreSQL database of the config file, or its SQLite file
+func openDB(cfg config.Config) db.DB {
+	if cfg.Postgres != "" {
+		return db.OpenPostgres(cfg.Postgres)
+	}
+	return db.Init(dbPath(cfg))
+}
+
+// dbPath returns the database of the config file, or "tagged-fs.sqlite3" in the executable's directory
+// where older versions kept it, or the default data location
+func dbPath(cfg config.Config) string {
+	if cfg.Db == "" {
+		ex, err := os.Executable()
+		must(err)
+		legacy := filepath.Join(filepath.Dir(ex), "tagged-fs.sqlite3")
+		if _, err := os.Stat(legacy); err == nil {
+			return legacy
+		}
+	}
+
+	path, err := cfg.DbPath("")
 	must(err)
-	db := db.Init(filepath.Join(filepath.Dir(ex), "/tagged-fs.sqlite3"))
+	return path
+}
+
+func main() {
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	db_ := openDB(cfg).WithOrigin(db.OriginGUI)
+	action.PurgeExpiredTrash(db_)
 
 	gin.SetMode(gin.ReleaseMode)
-	router := server.SetupGin(db)
+	router := server.SetupGin(db_, server.Config{
+		Roots:        cfg.Roots,
+		ThumbnailDir: cfg.ThumbnailDir(),
+		Timeouts:     cfg.RouteTimeouts(),
+	})
+	// Native file picker, only the GUI runs on the user's machine
+	router.POST(server.ApiPrefix+"/file-picker", func(c *gin.Context) {
+		filename, err := dialog.File().Load()
+		must(err)
+
+		c.String(http.StatusOK, filename)
+	})
 	// UI route
 	router.GET("/", func(c *gin.Context) {
 		c.Header("Content-Type", "text/html")
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ScopeRead      = "read"
+	ScopeReadWrite = "read-write"
+)
+
+type ApiKey struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	UserId     *int       `json:"userId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+// InsertApiKey stores a key by the hash of its secret and returns its id.
+// Keys without a user act as administrators.
+func (db DB) InsertApiKey(name string, hash string, scope string, userId *int /* nilable */) int {
+	var id int
+	err := db.conn().QueryRow("INSERT INTO api_key (name, hash, scope, user_id, created_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP) RETURNING id", name, hash, scope, userId).Scan(&id)
+	must(err)
+	return id
+}
+
+func (db DB) GetAllApiKeys() []ApiKey {
+	rows, err := db.conn().Query("SELECT id, name, scope, user_id, created_at, last_used_at, revoked_at FROM api_key ORDER BY id")
+	must(err)
+
+	keys := make([]ApiKey, 0)
+	for rows.Next() {
+		var key ApiKey
+		err := rows.Scan(&key.Id, &key.Name, &key.Scope, &key.UserId, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+		must(err)
+
+		keys = append(keys, key)
+	}
+	must(rows.Err())
+
+	return keys
+}
+
+// ApiKeyFromHash returns nil if no active key has this hash
+func (db DB) ApiKeyFromHash(hash string) *ApiKey {
+	var key ApiKey
+	err := db.conn().QueryRow("SELECT id, name, scope, user_id, created_at, last_used_at, revoked_at FROM api_key WHERE hash = ? AND revoked_at IS NULL", hash).
+		Scan(&key.Id, &key.Name, &key.Scope, &key.UserId, &key.CreatedAt, &key.LastUsedAt, &key.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+
+	return &key
+}
+
+func (db DB) ApiKeyExists(id int) bool {
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM api_key WHERE id = ? AND revoked_at IS NULL", id)
+	must(row.Err())
+
+	var count int
+	err := row.Scan(&count)
+	must(err)
+	return count == 1
+}
+
+func (db DB) TouchApiKey(id int) {
+	_, err := db.conn().Exec("UPDATE api_key SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	must(err)
+}
+
+func (db DB) RevokeApiKey(id int) {
+	_, err := db.conn().Exec("UPDATE api_key SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	must(err)
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type AuditEntry struct {
+	Id        int             `json:"id"`
+	Operation string          `json:"operation"`
+	Entity    string          `json:"entity"`
+	EntityId  int             `json:"entityId"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	Origin    string          `json:"origin"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// AuditFilter restricts SearchAudit, nil fields match everything
+type AuditFilter struct {
+	FileId *int
+	// FilePath matches file entries where the file had this path before or after the change, which finds the entries
+	// of trashed and purged files too
+	FilePath *string
+	// TagId also matches file entries where the tag was assigned before or after the change
+	TagId  *int
+	Since  *time.Time
+	Until  *time.Time
+	Origin *string
+}
+
+// RecordAudit appends one entry per change, attributed to the DB's origin
+func (db DB) RecordAudit(operation string, changes ...Change) {
+	for _, change := range changes {
+		_, err := db.conn().Exec("INSERT INTO audit (operation, entity, entity_id, before, after, origin, created_at) VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+			operation, change.Entity, change.Id, string(change.Before), string(change.After), db.origin)
+		must(err)
+	}
+}
+
+// stateHasTag returns the condition that the file state in the JSON column has the tag given as parameter
+func (db DB) stateHasTag(column string) string {
+	if db.postgres {
+		return "CAST(" + column + " AS JSONB) -> 'tagIds' @> CAST(? AS JSONB)"
+	}
+	return "EXISTS (SELECT 1 FROM json_each(" + column + ", '$.tagIds') WHERE value = ?)"
+}
+
+// statePath returns the expression of the path of the file state in the JSON column
+func (db DB) statePath(column string) string {
+	if db.postgres {
+		return "CAST(" + column + " AS JSONB) ->> 'path'"
+	}
+	return "json_extract(" + column + ", '$.path')"
+}
+
+// SearchAudit returns the most recent matching entries first, and the total number of matching entries
+func (db DB) SearchAudit(filter AuditFilter, limit int, offset int) ([]AuditEntry, int) {
+	wheres := []string{"1 = 1"}
+	params := make([]any, 0)
+
+	if filter.FileId != nil {
+		wheres = append(wheres, "(a.entity = ? AND a.entity_id = ?)")
+		params = append(params, EntityFile, *filter.FileId)
+	}
+	if filter.FilePath != nil {
+		wheres = append(wheres, "(a.entity = ? AND ("+db.statePath("a.before")+" = ? OR "+db.statePath("a.after")+" = ?))")
+		params = append(params, EntityFile, *filter.FilePath, *filter.FilePath)
+	}
+	if filter.TagId != nil {
+		wheres = append(wheres, "((a.entity = ? AND a.entity_id = ?) OR (a.entity = ? AND ("+db.stateHasTag("a.before")+" OR "+db.stateHasTag("a.after")+")))")
+		params = append(params, EntityTag, *filter.TagId, EntityFile, *filter.TagId, *filter.TagId)
+	}
+	if filter.Since != nil {
+		wheres = append(wheres, "a.created_at >= ?")
+		params = append(params, db.timeParam(*filter.Since))
+	}
+	if filter.Until != nil {
+		wheres = append(wheres, "a.created_at <= ?")
+		params = append(params, db.timeParam(*filter.Until))
+	}
+	if filter.Origin != nil {
+		// "rest" also matches "rest:<api key name>"
+		wheres = append(wheres, "(a.origin = ? OR a.origin LIKE ?)")
+		params = append(params, *filter.Origin, *filter.Origin+":%")
+	}
+
+	where := " WHERE " + strings.Join(wheres, " AND ")
+
+	var total int
+	err := db.conn().QueryRow("SELECT COUNT(*) FROM audit a"+where, params...).Scan(&total)
+	must(err)
+
+	rows, err := db.conn().Query("SELECT a.id, a.operation, a.entity, a.entity_id, a.before, a.after, a.origin, a.created_at FROM audit a"+where+" ORDER BY a.id DESC LIMIT ? OFFSET ?",
+		append(params, limit, offset)...)
+	must(err)
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after string
+		err := rows.Scan(&entry.Id, &entry.Operation, &entry.Entity, &entry.EntityId, &before, &after, &entry.Origin, &entry.CreatedAt)
+		must(err)
+
+		entry.Before = json.RawMessage(before)
+		entry.After = json.RawMessage(after)
+		entries = append(entries, entry)
+	}
+	must(rows.Err())
+
+	return entries, total
+}
+
+// GetAuditAfter returns up to limit entries with an id greater than afterId, oldest first
+func (db DB) GetAuditAfter(afterId int, limit int) []AuditEntry {
+	rows, err := db.conn().Query("SELECT id, operation, entity, entity_id, before, after, origin, created_at FROM audit WHERE id > ? ORDER BY id LIMIT ?", afterId, limit)
+	must(err)
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after string
+		err := rows.Scan(&entry.Id, &entry.Operation, &entry.Entity, &entry.EntityId, &before, &after, &entry.Origin, &entry.CreatedAt)
+		must(err)
+
+		entry.Before = json.RawMessage(before)
+		entry.After = json.RawMessage(after)
+		entries = append(entries, entry)
+	}
+	must(rows.Err())
+
+	return entries
+}
+
+func (db DB) LastAuditId() int {
+	var id int
+	err := db.conn().QueryRow("SELECT COALESCE(MAX(id), 0) FROM audit").Scan(&id)
+	must(err)
+	return id
+}
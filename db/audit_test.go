@@ -0,0 +1,69 @@
+package db_test
+
+import (
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+	"time"
+)
+
+func auditOperations(entries []db.AuditEntry) []string {
+	operations := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		operations = append(operations, entry.Operation)
+	}
+	return operations
+}
+
+// The audit log is searched by file, path, tag, origin and date, the file being found even once purged
+func TestAuditFilter(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	cli := db_.WithOrigin("cli")
+	rest := db_.WithOrigin("rest:phone")
+
+	tag := action.AddTag(cli, "tag", "#000000", false, nil)
+	path := testutil.WriteFile(t, dir, "a.txt", "a")
+	fileId := action.AddFile(cli, path, nil)
+	action.EditFile(rest, fileId, nil, []int{tag})
+	action.RmFile(cli, fileId)
+	action.PurgeFile(cli, fileId)
+	other := action.AddFile(cli, testutil.WriteFile(t, dir, "b.txt", "b"), nil)
+	if other != fileId {
+		t.Fatalf("expected the id %v of the purged file to be reused, got %v", fileId, other)
+	}
+
+	search := func(filter db.AuditFilter) []string {
+		t.Helper()
+		entries, total := action.SearchAudit(db_, filter, 100, 0)
+		testutil.Equal(t, "total", len(entries), total)
+		return auditOperations(entries)
+	}
+	str := func(s string) *string { return &s }
+	fileOperations := []string{"file.purge", "file.rm", "file.edit", "file.add"}
+
+	testutil.Equal(t, "by purged file path", fileOperations, search(db.AuditFilter{FilePath: &path}))
+	// The id of a purged file can be given to a new file, its path tells them apart
+	testutil.Equal(t, "by reused file id", append([]string{"file.add"}, fileOperations...), search(db.AuditFilter{FileId: &other}))
+	testutil.Equal(t, "by other file path", []string{"file.add"}, search(db.AuditFilter{FilePath: str(filepath.Join(dir, "b.txt"))}))
+	testutil.Equal(t, "by unknown path", []string{}, search(db.AuditFilter{FilePath: str(filepath.Join(dir, "c.txt"))}))
+
+	// The tag matches itself and the files it was assigned to before or after
+	testutil.Equal(t, "by tag", []string{"file.purge", "file.rm", "file.edit", "tag.add"}, search(db.AuditFilter{TagId: &tag}))
+
+	testutil.Equal(t, "by origin", []string{"file.edit"}, search(db.AuditFilter{Origin: str("rest")}))
+	testutil.Equal(t, "by exact origin", []string{"file.edit"}, search(db.AuditFilter{Origin: str("rest:phone")}))
+	testutil.Equal(t, "by origin and path", []string{"file.purge", "file.rm", "file.add"}, search(db.AuditFilter{FilePath: &path, Origin: str("cli")}))
+
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	testutil.Equal(t, "since the future", []string{}, search(db.AuditFilter{Since: &future}))
+	testutil.Equal(t, "until the past", []string{}, search(db.AuditFilter{Until: &past}))
+	testutil.Equal(t, "between", 6, len(search(db.AuditFilter{Since: &past, Until: &future})))
+
+	// Pages are counted in the total
+	entries, total := action.SearchAudit(db_, db.AuditFilter{FilePath: &path}, 2, 1)
+	testutil.Equal(t, "page", []string{"file.rm", "file.edit"}, auditOperations(entries))
+	testutil.Equal(t, "total", 4, total)
+}
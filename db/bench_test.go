@@ -0,0 +1,233 @@
+package db_test
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"tagged-fs/db"
+	"tagged-fs/metadata"
+	"testing"
+	"time"
+)
+
+const (
+	benchFiles = 500_000
+	benchTags  = 5_000
+	// tagsPerTree is the size of the generated tag trees, whose roots are the first tags
+	tagsPerTree = 100
+)
+
+var words = []string{
+	"holiday", "beach", "mountain", "family", "birthday", "wedding", "concert", "invoice", "report", "budget",
+	"draft", "final", "scan", "receipt", "contract", "lecture", "recipe", "garden", "kitchen", "forest",
+	"river", "sunset", "winter", "summer", "autumn", "spring", "portrait", "landscape", "album", "podcast",
+	"interview", "meeting", "project", "design", "sketch", "notes", "letter", "manual", "tutorial", "trailer",
+	"episode", "mixtape", "demo", "backup", "archive", "export", "screenshot", "diagram", "poster", "flyer",
+}
+
+// extensions are the extensions of the generated files and their MIME type, photos being the most common
+var extensions = [][2]string{
+	{"jpg", "image/jpeg"}, {"jpg", "image/jpeg"}, {"jpg", "image/jpeg"}, {"png", "image/png"}, {"mp4", "video/mp4"},
+	{"mkv", "video/x-matroska"}, {"mp3", "audio/mpeg"}, {"flac", "audio/flac"}, {"pdf", "application/pdf"},
+	{"docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"}, {"txt", "text/plain; charset=utf-8"},
+	{"zip", "application/zip"},
+}
+
+// generate fills a new database with files named after words, in folders by year and word, each having one to four tags
+func generate(b *testing.B, path string, files int, tags int) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			b.Fatal(err)
+		}
+	}
+	// Creates the tables
+	db.Init(path)
+
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_fk=true&_busy_timeout=10000")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	tx, err := conn.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	exec := func(stmt *sql.Stmt, args ...any) {
+		if _, err := stmt.Exec(args...); err != nil {
+			b.Fatal(err)
+		}
+	}
+	prepare := func(query string) *sql.Stmt {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return stmt
+	}
+
+	random := rand.New(rand.NewSource(1))
+
+	insertTag := prepare("INSERT INTO tag (id, name, color, \"order\", updated_at) VALUES (?, ?, '#808080', ?, CURRENT_TIMESTAMP)")
+	insertParent := prepare("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) VALUES (?, ?)")
+	roots := (tags + tagsPerTree - 1) / tagsPerTree
+	for id := 1; id <= tags; id++ {
+		exec(insertTag, id, fmt.Sprintf("tag %v", id), id)
+		if id > roots {
+			// A random earlier tag of the same tree
+			tree := (id - 1) % roots
+			parents := (id - 1 - tree) / roots
+			exec(insertParent, id, tree+1+random.Intn(parents)*roots)
+		}
+	}
+
+	insertFile := prepare("INSERT INTO file (id, name, path, mime_type, kind, updated_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)")
+	insertFileTag := prepare("INSERT OR IGNORE INTO file_tag (file_id, tag_id) VALUES (?, ?)")
+	for id := 1; id <= files; id++ {
+		first, second := words[random.Intn(len(words))], words[random.Intn(len(words))]
+		extension := extensions[random.Intn(len(extensions))]
+		name := fmt.Sprintf("%v_%v_%06d", first, second, id)
+		path := fmt.Sprintf("/library/%v/%v/%v.%v", 2000+random.Intn(25), first, name, extension[0])
+		exec(insertFile, id, name, path, extension[1], metadata.Kind(extension[1]))
+
+		for n := 1 + random.Intn(4); n > 0; n-- {
+			exec(insertFileTag, id, 1+random.Intn(tags))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := conn.Exec("ANALYZE"); err != nil {
+		b.Fatal(err)
+	}
+}
+
+var (
+	libraryOnce sync.Once
+	library     db.DB
+)
+
+// openLibrary returns the generated library, kept in the temporary directory for the next runs: generating it takes a
+// minute. It is generated again when a run was interrupted before it was complete.
+func openLibrary(b *testing.B) db.DB {
+	libraryOnce.Do(func() {
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("tagged-fs-bench-%v-%v.sqlite3", benchFiles, benchTags))
+		complete := path + ".complete"
+		if _, err := os.Stat(complete); os.IsNotExist(err) {
+			start := time.Now()
+			generate(b, path, benchFiles, benchTags)
+			if err := os.WriteFile(complete, nil, 0o644); err != nil {
+				b.Fatal(err)
+			}
+			b.Logf("generated %v files and %v tags in %v in %v", benchFiles, benchTags, path, time.Since(start).Round(time.Second))
+		}
+		// Opening indexes the names of the generated files the first time
+		library = db.Init(path)
+	})
+	return library
+}
+
+// benchmark times run on the library and fails when its 95th percentile is over target.
+// run returns how many results it got.
+func benchmark(b *testing.B, target time.Duration, run func(db_ db.DB) int) {
+	db_ := openLibrary(b)
+	results := run(db_) // warms the cache
+	durations := make([]time.Duration, b.N)
+	// The garbage of the previous benchmarks is not counted in this one
+	runtime.GC()
+	b.ResetTimer()
+	for i := range durations {
+		start := time.Now()
+		run(db_)
+		durations[i] = time.Since(start)
+	}
+	b.StopTimer()
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	p95 := durations[(len(durations)-1)*95/100]
+	b.ReportMetric(float64(results), "results")
+	b.ReportMetric(float64(p95.Microseconds())/1000, "p95-ms")
+	if p95 > target {
+		b.Errorf("the 95th percentile %v is over the target %v", p95, target)
+	}
+}
+
+func benchmarkSearch(b *testing.B, target time.Duration, search db.FileSearch) {
+	benchmark(b, target, func(db_ db.DB) int { return len(db_.SearchFiles(search)) })
+}
+
+func BenchmarkGetFile(b *testing.B) {
+	benchmark(b, time.Millisecond, func(db_ db.DB) int {
+		if db_.GetFile(benchFiles/2) == nil {
+			return 0
+		}
+		return 1
+	})
+}
+
+func BenchmarkFileIdFromPath(b *testing.B) {
+	path := openLibrary(b).GetFile(benchFiles / 3).Path
+	benchmark(b, time.Millisecond, func(db_ db.DB) int {
+		db_.FileIdFromPath(path)
+		return 1
+	})
+}
+
+func BenchmarkListTags(b *testing.B) {
+	benchmark(b, 50*time.Millisecond, func(db_ db.DB) int { return len(db_.GetAllTags()) })
+}
+
+func BenchmarkSearchUniqueWord(b *testing.B) {
+	name := fmt.Sprintf("%06d", benchFiles/2)
+	benchmarkSearch(b, 10*time.Millisecond, db.FileSearch{Name: &name})
+}
+
+func BenchmarkSearchTwoWords(b *testing.B) {
+	name := "holiday beach"
+	benchmarkSearch(b, 250*time.Millisecond, db.FileSearch{Name: &name})
+}
+
+func BenchmarkSearchFuzzy(b *testing.B) {
+	if !openLibrary(b).HasSearchIndex() {
+		b.Skip("fuzzy search needs the sqlite_fts5 tag")
+	}
+	name := "holdiay bech"
+	benchmarkSearch(b, 50*time.Millisecond, db.FileSearch{Name: &name, Fuzzy: true})
+}
+
+func BenchmarkSearchLeafTag(b *testing.B) {
+	benchmarkSearch(b, 20*time.Millisecond, db.FileSearch{TagIds: []int{benchTags}})
+}
+
+func BenchmarkSearchRootTag(b *testing.B) {
+	benchmarkSearch(b, 500*time.Millisecond, db.FileSearch{TagIds: []int{1}})
+}
+
+func BenchmarkSearchTwoRootTags(b *testing.B) {
+	benchmarkSearch(b, 150*time.Millisecond, db.FileSearch{TagIds: []int{1, 2}})
+}
+
+func BenchmarkSearchRootTagAndKind(b *testing.B) {
+	roots := (benchTags + tagsPerTree - 1) / tagsPerTree
+	benchmarkSearch(b, 250*time.Millisecond, db.FileSearch{TagIds: []int{roots}, Kinds: []string{metadata.KindVideo}})
+}
+
+func BenchmarkSearchTagAndName(b *testing.B) {
+	name := "sunset"
+	benchmarkSearch(b, 100*time.Millisecond, db.FileSearch{TagIds: []int{1}, Name: &name})
+}
+
+func BenchmarkAddFile(b *testing.B) {
+	benchmark(b, 10*time.Millisecond, func(db_ db.DB) int {
+		// The file is not kept, so every run adds the same one
+		tx := db_.Begin()
+		defer tx.Rollback()
+		tx.AddFile("/library/bench/added.jpg", []int{1, benchTags})
+		return 1
+	})
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// GetContentHash returns the hash recorded for path, nil if there is none or the file changed since.
+// modifiedAt is the modification time in nanoseconds.
+func (db DB) GetContentHash(path string, size int64, modifiedAt int64) *string {
+	var hash string
+	err := db.conn().QueryRow("SELECT hash FROM content_hash WHERE path = ? AND size = ? AND modified_at = ?", path, size, modifiedAt).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+	return &hash
+}
+
+func (db DB) SetContentHash(path string, size int64, modifiedAt int64, hash string) {
+	_, err := db.conn().Exec(`INSERT INTO content_hash (path, size, modified_at, hash) VALUES (?, ?, ?, ?)
+		ON CONFLICT (path) DO UPDATE SET size = excluded.size, modified_at = excluded.modified_at, hash = excluded.hash`, path, size, modifiedAt, hash)
+	must(err)
+}
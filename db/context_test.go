@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// slowQuery counts forever, until it is interrupted
+const slowQuery = "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n) SELECT COUNT(*) FROM n"
+
+// expectContextPanic fails the test unless f panics with an error that is expected
+func expectContextPanic(t *testing.T, expected error, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, expected) {
+			t.Errorf("expected %v to be panicked, got %v", expected, err)
+		}
+	}()
+	f()
+}
+
+// A cancelled or expired context stops the query running in a transaction, which is rolled back without waiting for
+// Rollback, so other writers do not wait for it
+func TestContextStopsSlowQueries(t *testing.T) {
+	db := Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+
+	for _, test := range []struct {
+		name     string
+		expected error
+		context  func() (context.Context, context.CancelFunc)
+	}{
+		{"timeout", context.DeadlineExceeded, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 100*time.Millisecond)
+		}},
+		{"cancel", context.Canceled, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return ctx, cancel
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := test.context()
+			defer cancel()
+
+			tx := db.WithContext(ctx).Begin()
+			tx.InsertTag("rolled back", "#000000", false, nil)
+
+			start := time.Now()
+			expectContextPanic(t, test.expected, func() {
+				var count int
+				must(tx.conn().QueryRow(slowQuery).Scan(&count))
+			})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the query to stop with its context, it took %v", elapsed)
+			}
+
+			// Writing does not wait for the transaction, which was not rolled back yet
+			db.InsertTag("committed "+test.name, "#000000", false, nil)
+			for _, tag := range db.GetAllTags() {
+				if tag.Name == "rolled back" {
+					t.Errorf("expected the changes of the transaction to be rolled back")
+				}
+			}
+			tx.Rollback()
+		})
+	}
+}
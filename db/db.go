@@ -1,55 +1,287 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
 	"errors"
+	"fmt"
+	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/zyedidia/generic/mapset"
 )
 
-//go:embed migration.sql
-var migration string
+//go:embed migrations/*.sql
+var migrations embed.FS
 
 func must(err error) {
 	if err != nil {
+		// Cancelled queries keep their error, so callers can tell them from failures
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			panic(err)
+		}
 		panic(err.Error())
 	}
 }
 
 type DB struct {
 	db *sql.DB
+	tx *sql.Tx
+
+	// nested is set when Begin joined an already running transaction
+	nested bool
+
+	// origin is the client recorded in the audit log for changes made through this DB
+	origin string
+
+	// user restricts the tags and files visible through this DB, see WithUser
+	user *int
+
+	// committed is signaled after each top-level commit, see WithCommitNotify
+	committed chan<- struct{}
+
+	// searchIndex is set when SQLite has FTS5, see HasSearchIndex
+	searchIndex bool
+
+	// ctx cancels the queries and transactions of this DB, see WithContext
+	ctx context.Context
+
+	// postgres is set when the database is PostgreSQL rather than SQLite, see OpenPostgres
+	postgres bool
+}
+
+const (
+	OriginCLI  = "cli"
+	OriginGUI  = "gui"
+	OriginREST = "rest"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// contextQuerier is implemented by both *sql.DB and *sql.Tx
+type contextQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// contextConn is a querier running its queries with a context
+type contextConn struct {
+	ctx  context.Context
+	conn contextQuerier
+}
+
+func (c contextConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c contextConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c contextConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
 }
 
+// busyTimeout is how long a write waits for the one of another connection or process to finish before failing
+const busyTimeout = 10 * time.Second
+
+// Init opens the database, creating and migrating it if needed. The GUI, the server and the CLI can use the same
+// database at once: WAL lets them read while one of them writes, and writes wait for each other.
 func Init(dbPath string) DB {
-	db, err := sql.Open("sqlite3", "file:"+dbPath+"?_fk=true")
+	// Transactions take the write lock when they begin: a transaction that read before writing could otherwise fail
+	// right away instead of waiting, if another wrote in between
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%v?_fk=true&_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=%d&_txlock=immediate",
+		dbPath, busyTimeout.Milliseconds()))
 	must(err)
+	// Connections are cheap to keep, and readers only wait for each other when they are all busy
+	db.SetMaxOpenConns(maxConns())
+	db.SetMaxIdleConns(maxConns())
+	db.SetConnMaxIdleTime(5 * time.Minute)
 
-	// Only migrate if needed
-	row := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table'")
-	must(row.Err())
-	var count int
-	if row.Scan(&count); count == 0 {
-		_, err = db.Exec(migration)
+	migrate(db)
+	searchIndex := initSearchIndexes(db)
+
+	return DB{db: db, origin: OriginCLI, searchIndex: searchIndex}
+}
+
+func (db DB) Origin() string {
+	return db.origin
+}
+
+// WithOrigin returns a DB whose changes are attributed to origin in the audit log
+func (db DB) WithOrigin(origin string) DB {
+	db.origin = origin
+	return db
+}
+
+// WithCommitNotify returns a DB that signals committed, without blocking, whenever one of its transactions commits
+func (db DB) WithCommitNotify(committed chan<- struct{}) DB {
+	db.committed = committed
+	return db
+}
+
+// WithContext returns a DB whose queries and transactions are cancelled with ctx, panicking its error.
+// A transaction begun before is still rolled back when its own context is cancelled.
+func (db DB) WithContext(ctx context.Context) DB {
+	db.ctx = ctx
+	return db
+}
+
+// Context returns the context of the DB's queries, context.Background() unless set by WithContext
+func (db DB) Context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+func (db DB) conn() querier {
+	var conn contextQuerier = db.db
+	if db.tx != nil {
+		conn = db.tx
+	}
+	if db.postgres {
+		return postgresConn{contextConn{db.Context(), conn}}
+	}
+	if db.ctx == nil {
+		return conn.(querier)
+	}
+	return contextConn{db.ctx, conn}
+}
+
+// Begin returns a DB bound to a new transaction.
+// If db is already bound to a transaction, it is joined and Commit/Rollback are left to its owner.
+func (db DB) Begin() DB {
+	if db.tx != nil {
+		db.nested = true
+		return db
+	}
+
+	// The transaction is rolled back if the context is cancelled before it commits
+	tx, err := db.db.BeginTx(db.Context(), nil)
+	must(err)
+	db.tx = tx
+	return db
+}
+
+func (db DB) Commit() {
+	if db.nested {
+		return
+	}
+	err := db.tx.Commit()
+	must(err)
+
+	if db.committed != nil {
+		select {
+		case db.committed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Rollback is a no-op if the transaction was already committed, so it can always be deferred
+func (db DB) Rollback() {
+	if db.nested {
+		return
+	}
+	db.tx.Rollback()
+}
+
+// maxConns bounds the connections to the database, SQLite doing as many reads at once as there are cores
+func maxConns() int {
+	n := 2 * runtime.NumCPU()
+	if n < 8 {
+		n = 8
+	}
+	return n
+}
+
+// schemaVersion returns the number of migrations applied to the database
+func schemaVersion(conn querier) int {
+	var version int
+	err := conn.QueryRow("PRAGMA user_version").Scan(&version)
+	must(err)
+
+	// Databases created before versioned migrations have the initial schema but no version
+	if version == 0 {
+		var count int
+		err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table'").Scan(&count)
 		must(err)
+		if count != 0 {
+			version = 1
+		}
 	}
+	return version
+}
+
+// migrate applies every migration newer than the database's user_version
+func migrate(db *sql.DB) {
+	version := schemaVersion(db)
+
+	entries, err := migrations.ReadDir("migrations")
+	must(err)
+
+	for i, entry := range entries {
+		if i < version {
+			continue
+		}
+
+		migration, err := migrations.ReadFile(path.Join("migrations", entry.Name()))
+		must(err)
+
+		tx, err := db.Begin()
+		must(err)
 
-	return DB{db}
+		// Another process opening the database at the same time can have applied it while this one waited for the lock
+		if schemaVersion(tx) > i {
+			tx.Rollback()
+			continue
+		}
+
+		_, err = tx.Exec(string(migration))
+		must(err)
+		_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		must(err)
+
+		err = tx.Commit()
+		must(err)
+	}
 }
 
 // Tag
 type Tag struct {
-	Id        int    `json:"id"`
-	Name      string `json:"name"`
-	Color     string `json:"color"`
-	ParentIds []int  `json:"parentIds"`
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Color     string     `json:"color"`
+	ParentIds []int      `json:"parentIds"`
+	OwnerId   *int       `json:"ownerId"`
+	Private   bool       `json:"private"`
+	Revision  int        `json:"revision"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 func (db DB) GetAllTags() []Tag {
-	rows, err := db.db.Query("SELECT id, name, color, tpt.parent_tag_id FROM tag LEFT JOIN tag_parent_tag tpt ON tpt.tag_id = tag.id ORDER BY \"order\"")
+	visibleParent, params := db.visibleTag("parent")
+	visible, visibleParams := db.visibleTag("tag")
+	params = append(params, visibleParams...)
+
+	rows, err := db.conn().Query(`SELECT tag.id, tag.name, tag.color, tag.owner_id, tag.private, tag.revision, tag.updated_at, tpt.parent_tag_id FROM tag
+		LEFT JOIN (tag_parent_tag tpt JOIN tag parent ON parent.id = tpt.parent_tag_id AND parent.deleted_at IS NULL AND `+visibleParent+`)
+			ON tpt.tag_id = tag.id
+		WHERE tag.deleted_at IS NULL AND `+visible+`
+		ORDER BY tag."order"`, params...)
 	must(err)
 
 	tags := make([]Tag, 0)
@@ -61,12 +293,25 @@ func (db DB) GetAllTags() []Tag {
 		var tagId int
 		var name string
 		var color string
+		var ownerId *int
+		var private bool
+		var revision int
+		var updatedAt time.Time
 		var parentId sql.NullInt64
-		rows.Scan(&tagId, &name, &color, &parentId)
+		rows.Scan(&tagId, &name, &color, &ownerId, &private, &revision, &updatedAt, &parentId)
 
 		tag, ok := tagsById[tagId]
 		if !ok {
-			tags = append(tags, Tag{tagId, name, color, make([]int, 0)})
+			tags = append(tags, Tag{
+				Id:        tagId,
+				Name:      name,
+				Color:     color,
+				ParentIds: make([]int, 0),
+				OwnerId:   ownerId,
+				Private:   private,
+				Revision:  revision,
+				UpdatedAt: updatedAt,
+			})
 			tag = &tags[len(tags)-1]
 			tagsById[tagId] = tag
 		}
@@ -75,25 +320,52 @@ func (db DB) GetAllTags() []Tag {
 			tag.ParentIds = append(tag.ParentIds, int(parentId.Int64))
 		}
 	}
+	must(rows.Err())
 
 	return tags
 }
 
-func (db DB) InsertTag(name string, color string, parentIds []int) {
-	res, err := db.db.Exec("INSERT INTO tag (name, color, \"order\") VALUES (?, ?, ?)", name, color, db.getNextOrder())
+// GetTag returns nil if the tag does not exist or is not visible
+func (db DB) GetTag(id int) *Tag {
+	visible, params := db.visibleTag("tag")
+	tag := Tag{Id: id}
+	err := db.conn().QueryRow("SELECT name, color, owner_id, private, revision, updated_at FROM tag WHERE id = ? AND deleted_at IS NULL AND "+visible, append([]any{id}, params...)...).
+		Scan(&tag.Name, &tag.Color, &tag.OwnerId, &tag.Private, &tag.Revision, &tag.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
 	must(err)
 
-	id, err := res.LastInsertId()
+	visibleParent, params := db.visibleTag("parent")
+	tag.ParentIds = db.queryIds("SELECT tpt.parent_tag_id FROM tag_parent_tag tpt JOIN tag parent ON parent.id = tpt.parent_tag_id WHERE tpt.tag_id = ? AND parent.deleted_at IS NULL AND "+visibleParent,
+		append([]any{id}, params...)...)
+
+	return &tag
+}
+
+// InsertTag creates a tag owned by the DB's user
+func (db DB) InsertTag(name string, color string, private bool, parentIds []int) int {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	var id int
+	err := tx.conn().QueryRow("INSERT INTO tag (name, color, \"order\", owner_id, private, updated_at) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP) RETURNING id",
+		name, color, tx.getNextOrder(), db.user, private).Scan(&id)
 	must(err)
 
 	for _, v := range parentIds {
-		_, err = db.db.Exec("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) VALUES (?, ?)", id, v)
+		_, err = tx.conn().Exec("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING", id, v)
 		must(err)
 	}
+
+	tx.Commit()
+
+	return id
 }
 
 func (db DB) TagExists(id int) bool {
-	row := db.db.QueryRow("SELECT COUNT(*) FROM tag WHERE id = ?", id)
+	visible, params := db.visibleTag("tag")
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM tag WHERE id = ? AND deleted_at IS NULL AND "+visible, append([]any{id}, params...)...)
 	must(row.Err())
 
 	var count int
@@ -102,8 +374,19 @@ func (db DB) TagExists(id int) bool {
 	return count == 1
 }
 
-func (db DB) UpdateTag(id int, name *string /* nilable */, color *string /* nilable */, parentIds *[]int /* nilable */) {
-	updates := make([]string, 0, 3)
+func (db DB) TagRevision(id int) int {
+	row := db.conn().QueryRow("SELECT revision FROM tag WHERE id = ?", id)
+	must(row.Err())
+
+	var revision int
+	err := row.Scan(&revision)
+	must(err)
+	return revision
+}
+
+// UpdateTag returns the new revision of the tag, or false if expectedRevision no longer matches
+func (db DB) UpdateTag(id int, expectedRevision *int /* nilable */, name *string /* nilable */, color *string /* nilable */, private *bool /* nilable */, parentIds *[]int /* nilable */) (int, bool) {
+	updates := []string{"revision = revision + 1", "updated_at = CURRENT_TIMESTAMP"}
 	params := make([]any, 0, 3)
 
 	if name != nil {
@@ -115,50 +398,71 @@ func (db DB) UpdateTag(id int, name *string /* nilable */, color *string /* nila
 		params = append(params, *color)
 
 	}
+	if private != nil {
+		updates = append(updates, "private = ?")
+		params = append(params, *private)
+	}
 
-	sql := "UPDATE tag SET " + strings.Join(updates, ", ") + " WHERE id = ?"
+	query := "UPDATE tag SET " + strings.Join(updates, ", ") + " WHERE id = ?"
 	params = append(params, id)
+	if expectedRevision != nil {
+		query += " AND revision = ?"
+		params = append(params, *expectedRevision)
+	}
+	query += " RETURNING revision"
 
-	_, err := db.db.Exec(sql, params...)
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	var revision int
+	err := tx.conn().QueryRow(query, params...).Scan(&revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false
+	}
 	must(err)
 
 	// Update parent relation
 	if parentIds != nil {
-		_, err := db.db.Exec("DELETE FROM tag_parent_tag WHERE tag_id = ?", id)
+		_, err := tx.conn().Exec("DELETE FROM tag_parent_tag WHERE tag_id = ?", id)
 		must(err)
 
 		for _, v := range *parentIds {
-			_, err := db.db.Exec("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) VALUES (?, ?)", id, v)
+			_, err := tx.conn().Exec("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING", id, v)
 			must(err)
 		}
 	}
+
+	tx.Commit()
+
+	return revision, true
 }
 
 func (db DB) UpdateTagsOrder(ids []int) {
-	tx, err := db.db.Begin()
-	must(err)
+	tx := db.Begin()
+	defer tx.Rollback()
 
 	for i, v := range ids {
-		_, err := tx.Exec("UPDATE tag SET \"order\" = ? WHERE id = ?", i, v)
+		_, err := tx.conn().Exec("UPDATE tag SET \"order\" = ? WHERE id = ?", i, v)
 		must(err)
 	}
 
-	err = tx.Commit()
-	must(err)
+	tx.Commit()
 }
 
+// DeleteTag moves the tag to the trash, its assignments and relations are kept until it is purged
 func (db DB) DeleteTag(id int) {
-	_, err := db.db.Exec("DELETE FROM tag WHERE id = ?", id)
+	_, err := db.conn().Exec("UPDATE tag SET deleted_at = CURRENT_TIMESTAMP, revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
 	must(err)
 }
 
 func (db DB) GetAllChildTagIds(tagId int) []int {
-	rows, err := db.db.Query(`WITH RECURSIVE cte(id) AS (
-									SELECT id FROM tag WHERE id = ?
+	rows, err := db.conn().Query(`WITH RECURSIVE cte(id) AS (
+									SELECT id FROM tag WHERE id = ? AND deleted_at IS NULL
 								UNION ALL
 									SELECT t.id FROM tag t
 									JOIN tag_parent_tag tpt ON tpt.tag_id = t.id
 									JOIN cte ON cte.id = tpt.parent_tag_id
+									WHERE t.deleted_at IS NULL
 							)
 							SELECT * FROM cte;`, tagId)
 	must(err)
@@ -171,12 +475,13 @@ func (db DB) GetAllChildTagIds(tagId int) []int {
 
 		result = append(result, tagId)
 	}
+	must(rows.Err())
 
 	return result
 }
 
 func (db DB) GetAllParentTagIds(tagId int) []int {
-	rows, err := db.db.Query(`WITH RECURSIVE cte(id, parent_tag_id) AS (
+	rows, err := db.conn().Query(`WITH RECURSIVE cte(id, parent_tag_id) AS (
 									SELECT t.id, tpt.parent_tag_id FROM tag t 
 									LEFT JOIN tag_parent_tag tpt ON tpt.tag_id = t.id 
 									WHERE t.id IN (?)
@@ -196,12 +501,13 @@ func (db DB) GetAllParentTagIds(tagId int) []int {
 
 		result = append(result, tagId)
 	}
+	must(rows.Err())
 
 	return result
 }
 
 func (db DB) getNextOrder() int {
-	row := db.db.QueryRow("SELECT MAX(\"order\") FROM tag")
+	row := db.conn().QueryRow("SELECT MAX(\"order\") FROM tag")
 	if errors.Is(row.Err(), sql.ErrNoRows) {
 		return 0
 	}
@@ -213,35 +519,70 @@ func (db DB) getNextOrder() int {
 }
 
 type File struct {
-	Id   int    `json:"id"`
-	Path string `json:"path"`
-	Name string `json:"name"`
-	Tags []Tag  `json:"tags"`
+	Id        int        `json:"id"`
+	Path      string     `json:"path"`
+	Name      string     `json:"name"`
+	Tags      []Tag      `json:"tags"`
+	Revision  int        `json:"revision"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// MimeType and Kind are detected from the file's content, see the metadata package
+	MimeType string `json:"mimeType"`
+	Kind     string `json:"kind"`
+	// Metadata holds the values read from the file's content, see the metadata package
+	Metadata map[string]string `json:"metadata"`
+}
+
+// FileSearch selects the files matching every condition that is set
+type FileSearch struct {
+	// Name are the starts of words of the file's name or path, or part of them without a search index
+	Name *string `json:"name"`
+	// TagIds must all be on the file, directly or through one of their descendants
+	TagIds []int `json:"tags"`
+	// Metadata maps metadata keys to part of their value
+	Metadata map[string]string `json:"metadata"`
+	// Kinds are the kinds the file can be of, any if empty
+	Kinds []string `json:"kinds"`
+	// Text are words the file's content must contain, which requires a search index
+	Text *string `json:"text"`
+	// Fuzzy makes the words of Name also match words a typo or two away, which requires a search index
+	Fuzzy bool `json:"fuzzy"`
 }
 
 // File
-func (db DB) AddFile(path string, tagIds []int) {
-	tx, err := db.db.Begin()
-	must(err)
+func (db DB) AddFile(path string, tagIds []int) int {
+	tx := db.Begin()
+	defer tx.Rollback()
 
 	// name is filename without extension
 	name := filepath.Base(path)
 	name = strings.TrimSuffix(name, filepath.Ext(name))
-	res, err := tx.Exec("INSERT INTO file (name, path) VALUES (?, ?)", name, path)
-	must(err)
-	fileId, err := res.LastInsertId()
+	var fileId int
+	err := tx.conn().QueryRow("INSERT INTO file (name, path, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP) RETURNING id", name, path).Scan(&fileId)
 	must(err)
+	tx.indexFileName(fileId, name, path)
 
 	for _, tagId := range tagIds {
-		tx.Exec("INSERT INTO file_tag (file_id, tag_id) VALUES (?, ?)", fileId, tagId)
+		// Tags that do not exist are skipped by the query, as a failed statement would abort a PostgreSQL transaction
+		_, err := tx.conn().Exec("INSERT INTO file_tag (file_id, tag_id, owner_id) SELECT CAST(? AS BIGINT), id, CAST(? AS BIGINT) FROM tag WHERE id = ? ON CONFLICT DO NOTHING",
+			fileId, db.user, tagId)
+		must(err)
 	}
 
-	err = tx.Commit()
+	tx.Commit()
+
+	return fileId
+}
+
+// SetFileType sets the detected MIME type and kind of a file
+func (db DB) SetFileType(id int, mimeType string, kind string) {
+	_, err := db.conn().Exec("UPDATE file SET mime_type = ?, kind = ? WHERE id = ? AND (mime_type != ? OR kind != ?)", mimeType, kind, id, mimeType, kind)
 	must(err)
 }
 
 func (db DB) FileExists(id int) bool {
-	row := db.db.QueryRow("SELECT COUNT(*) FROM file WHERE id = ?", id)
+	visible, params := db.visibleFile("file")
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM file WHERE id = ? AND deleted_at IS NULL AND "+visible, append([]any{id}, params...)...)
 	must(row.Err())
 
 	var count int
@@ -250,7 +591,7 @@ func (db DB) FileExists(id int) bool {
 	return count == 1
 }
 func (db DB) FileExistsPath(path string) bool {
-	row := db.db.QueryRow("SELECT COUNT(*) FROM file WHERE path = ?", path)
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM file WHERE path = ? AND deleted_at IS NULL", path)
 	must(row.Err())
 
 	var count int
@@ -260,7 +601,7 @@ func (db DB) FileExistsPath(path string) bool {
 }
 
 func (db DB) FileIdFromPath(path string) int {
-	row := db.db.QueryRow("SELECT id FROM file WHERE path = ?", path)
+	row := db.conn().QueryRow("SELECT id FROM file WHERE path = ? AND deleted_at IS NULL", path)
 	must(row.Err())
 
 	var id int
@@ -268,8 +609,19 @@ func (db DB) FileIdFromPath(path string) int {
 	must(err)
 	return id
 }
+func (db DB) FileRevision(id int) int {
+	row := db.conn().QueryRow("SELECT revision FROM file WHERE id = ?", id)
+	must(row.Err())
+
+	var revision int
+	err := row.Scan(&revision)
+	must(err)
+	return revision
+}
+
 func (db DB) FilePathFromId(id int) string {
-	row := db.db.QueryRow("SELECT path FROM file WHERE id = ?", id)
+	visible, params := db.visibleFile("file")
+	row := db.conn().QueryRow("SELECT path FROM file WHERE id = ? AND deleted_at IS NULL AND "+visible, append([]any{id}, params...)...)
 	must(row.Err())
 
 	var path string
@@ -278,87 +630,214 @@ func (db DB) FilePathFromId(id int) string {
 	return path
 }
 
-func (db DB) SearchFiles(name *string /* nilable */, tagIds []int) []File {
-	wheres := make([]string, 0)
-	params := make([]any, 0)
+func (db DB) SearchFiles(search FileSearch) []File {
+	query := fileQuery{}
+	if search.Name != nil {
+		query = db.nameQuery(*search.Name, search.Fuzzy)
+	}
+	wheres := query.wheres
+	params := query.params
+
+	if len(search.TagIds) != 0 {
+		// The tags and their descendants are found by a single query, hierarchy pairing each searched tag (root) with
+		// itself and its descendants (id)
+		roots := make([]int, 0, len(search.TagIds))
+		seen := make(map[int]bool)
+		for _, tagId := range search.TagIds {
+			if !seen[tagId] {
+				seen[tagId] = true
+				roots = append(roots, tagId)
+			}
+		}
+		inRoots, rootsParam := db.inIds("t.id", roots)
+		query.with = `hierarchy(root, id) AS (
+				SELECT t.id, t.id FROM tag t WHERE ` + inRoots + ` AND t.deleted_at IS NULL
+			UNION
+				SELECT h.root, t.id FROM hierarchy h
+				JOIN tag_parent_tag tpt ON tpt.parent_tag_id = h.id
+				JOIN tag t ON t.id = tpt.tag_id AND t.deleted_at IS NULL
+		)`
+		query.withParams = []any{rootsParam}
+
+		// Files have every searched tag or one of its descendants, and only those tags are returned
+		for _, root := range roots {
+			wheres = append(wheres, "f.id IN (SELECT ft.file_id FROM file_tag ft WHERE ft.tag_id IN (SELECT id FROM hierarchy WHERE root = ?))")
+			params = append(params, root)
+		}
+		wheres = append(wheres, "t.id IN (SELECT id FROM hierarchy)")
+	}
 
-	if name != nil {
-		wheres = append(wheres, "f.name LIKE ?")
-		params = append(params, "%"+*name+"%")
+	if len(search.Kinds) != 0 {
+		inSql := strings.Repeat("?,", len(search.Kinds))
+		wheres = append(wheres, "f.kind IN ("+inSql[:len(inSql)-1]+")")
+		for _, kind := range search.Kinds {
+			params = append(params, kind)
+		}
 	}
 
-	if len(tagIds) != 0 {
-		for _, tagId := range tagIds {
-			hierarchyTagIds := db.GetAllChildTagIds(tagId)
+	if search.Text != nil && strings.TrimSpace(*search.Text) != "" {
+		wheres = append(wheres, "f.id IN (SELECT rowid FROM file_text WHERE file_text MATCH ?)")
+		params = append(params, textQuery(*search.Text))
+	}
 
-			inSql := strings.Repeat("?,", len(hierarchyTagIds))
-			inSql = inSql[:len(inSql)-1] // remove extra ,
+	for key, value := range search.Metadata {
+		wheres = append(wheres, "f.id IN (SELECT file_id FROM file_metadata WHERE key = ? AND value "+db.like()+" ?)")
+		params = append(params, key, "%"+value+"%")
+	}
 
-			wheres = append(wheres, "t.id IN ("+inSql+")")
-			for _, tagId := range hierarchyTagIds {
-				params = append(params, tagId)
-			}
-		}
+	query.wheres = wheres
+	query.params = params
+	return db.queryFiles(query)
+}
 
+// GetFile returns nil if the file does not exist or is not visible
+func (db DB) GetFile(id int) *File {
+	files := db.queryFiles(fileQuery{wheres: []string{"f.id = ?"}, params: []any{id}})
+	if len(files) == 0 {
+		return nil
 	}
+	return &files[0]
+}
 
-	sql := `SELECT f.id, f.path, f.name, t.id, t.name, t.color 
-	FROM file f 
-	LEFT JOIN file_tag ft ON ft.file_id = f.id 
-	LEFT JOIN tag t ON t.id = ft.tag_id `
+// GetFiles returns the files of ids that exist and are visible, by name
+func (db DB) GetFiles(ids []int) []File {
+	inIds, param := db.inIds("f.id", ids)
+	return db.queryFiles(fileQuery{wheres: []string{inIds}, params: []any{param}})
+}
+
+// fileQuery selects and sorts the files returned by queryFiles
+type fileQuery struct {
+	// with is a common table expression the other parts can use, with withParams
+	with       string
+	withParams []any
+	// join is joined to the files f, with joinParams, before the tags are
+	join       string
+	joinParams []any
+	// wheres are conditions the files must all match, with params
+	wheres []string
+	params []any
+	// order sorts the files, by name if empty
+	order string
+}
 
-	if len(wheres) != 0 {
-		sql += "WHERE " + strings.Join(wheres, " AND ")
+// queryFiles returns the live files visible to the DB's user matching the query, in its order
+func (db DB) queryFiles(query fileQuery) []File {
+	visibleTag, visibleTagParams := db.visibleTag("t")
+	sql := `SELECT f.id, f.path, f.name, f.revision, f.updated_at, f.mime_type, f.kind, t.id, t.name, t.color, t.owner_id, t.private, t.revision, t.updated_at
+	FROM file f ` + query.join + `
+	LEFT JOIN file_tag ft ON ft.file_id = f.id 
+	LEFT JOIN tag t ON t.id = ft.tag_id AND t.deleted_at IS NULL AND ` + visibleTag + ` `
+	if query.with != "" {
+		sql = "WITH RECURSIVE " + query.with + "\n" + sql
 	}
+	params := append(append(append(append([]any{}, query.withParams...), query.joinParams...), visibleTagParams...), query.params...)
 
-	sql += " ORDER BY f.name"
+	visibleFile, visibleFileParams := db.visibleFile("f")
+	wheres := append(append([]string{}, query.wheres...), "f.deleted_at IS NULL", visibleFile)
+	params = append(params, visibleFileParams...)
+	sql += "WHERE " + strings.Join(wheres, " AND ")
 
-	rows, err := db.db.Query(sql, params...)
+	order := query.order
+	if order == "" {
+		order = db.byName("f.name")
+	}
+	// Rows of a file are kept together, so its tags are all read before the next file
+	sql += " ORDER BY " + order + ", f.id, t.id"
+
+	rows, err := db.conn().Query(sql, params...)
 	must(err)
 
 	fileById := make(map[int]File)
+	ids := make([]int, 0)
 	for rows.Next() {
 		must(rows.Err())
 
 		var fileId int
 		var path string
 		var name string
+		var revision int
+		var updatedAt time.Time
+		var mimeType string
+		var kind string
 		var tagId *int
 		var tagName string
 		var tagColor string
-		rows.Scan(&fileId, &path, &name, &tagId, &tagName, &tagColor)
+		var tagOwnerId *int
+		var tagPrivate bool
+		var tagRevision int
+		var tagUpdatedAt time.Time
+		rows.Scan(&fileId, &path, &name, &revision, &updatedAt, &mimeType, &kind, &tagId, &tagName, &tagColor, &tagOwnerId, &tagPrivate, &tagRevision, &tagUpdatedAt)
 
 		file, ok := fileById[fileId]
 		if !ok {
+			ids = append(ids, fileId)
 			file = File{
-				Id:   fileId,
-				Path: path,
-				Name: name,
-				Tags: make([]Tag, 0),
+				Id:        fileId,
+				Path:      path,
+				Name:      name,
+				Tags:      make([]Tag, 0),
+				Revision:  revision,
+				UpdatedAt: updatedAt,
+				MimeType:  mimeType,
+				Kind:      kind,
 			}
 		}
 
 		if tagId != nil {
 			file.Tags = append(file.Tags, Tag{
-				Id:    *tagId,
-				Name:  tagName,
-				Color: tagColor,
+				Id:        *tagId,
+				Name:      tagName,
+				Color:     tagColor,
+				OwnerId:   tagOwnerId,
+				Private:   tagPrivate,
+				Revision:  tagRevision,
+				UpdatedAt: tagUpdatedAt,
 			})
 		}
 
 		fileById[fileId] = file
 	}
+	must(rows.Err())
+
+	metadata := db.getFilesMetadata(fileById)
 
 	result := make([]File, 0, len(fileById))
-	for _, f := range fileById {
+	for _, id := range ids {
+		f := fileById[id]
+		f.Metadata = metadata[id]
+		if f.Metadata == nil {
+			f.Metadata = make(map[string]string)
+		}
 		result = append(result, f)
 	}
 	return result
 }
 
-func (db DB) UpdateFileTags(fileId int, tagIds []int) {
+// UpdateFileTags returns the new revision of the file, or false if expectedRevision no longer matches.
+// Tags that are not visible to the DB's user are left untouched, and so are trashed tags, which come back with their
+// files when restored.
+func (db DB) UpdateFileTags(fileId int, expectedRevision *int /* nilable */, tagIds []int) (int, bool) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	query := "UPDATE file SET revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?"
+	params := []any{fileId}
+	if expectedRevision != nil {
+		query += " AND revision = ?"
+		params = append(params, *expectedRevision)
+	}
+	query += " RETURNING revision"
+
+	var revision int
+	err := tx.conn().QueryRow(query, params...).Scan(&revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false
+	}
+	must(err)
+
 	existingTagIds := func() mapset.Set[int] {
-		rows, err := db.db.Query("SELECT ft.tag_id FROM file_tag ft JOIN file f ON ft.file_id = f.id WHERE f.id = ?", fileId)
+		visible, params := tx.visibleTag("t")
+		rows, err := tx.conn().Query("SELECT ft.tag_id FROM file_tag ft JOIN tag t ON t.id = ft.tag_id WHERE ft.file_id = ? AND t.deleted_at IS NULL AND "+visible, append([]any{fileId}, params...)...)
 		must(err)
 
 		tagIds := mapset.New[int]()
@@ -369,6 +848,7 @@ func (db DB) UpdateFileTags(fileId int, tagIds []int) {
 
 			tagIds.Put(tagId)
 		}
+		must(rows.Err())
 
 		return tagIds
 	}()
@@ -378,13 +858,10 @@ func (db DB) UpdateFileTags(fileId int, tagIds []int) {
 		wantedTagIds.Put(tagId)
 	}
 
-	tx, err := db.db.Begin()
-	must(err)
-
 	// Delete tags that are no longer wanted
 	existingTagIds.Each(func(tagId int) {
 		if !wantedTagIds.Has(tagId) {
-			_, err := tx.Exec("DELETE FROM file_tag WHERE file_id = ? AND tag_id = ?", fileId, tagId)
+			_, err := tx.conn().Exec("DELETE FROM file_tag WHERE file_id = ? AND tag_id = ?", fileId, tagId)
 			must(err)
 		}
 	})
@@ -392,16 +869,18 @@ func (db DB) UpdateFileTags(fileId int, tagIds []int) {
 	// Insert new tags
 	wantedTagIds.Each(func(tagId int) {
 		if !existingTagIds.Has(tagId) {
-			_, err := tx.Exec("INSERT INTO file_tag (file_id, tag_id) VALUES (?, ?)", fileId, tagId)
+			_, err := tx.conn().Exec("INSERT INTO file_tag (file_id, tag_id, owner_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", fileId, tagId, db.user)
 			must(err)
 		}
 	})
 
-	err = tx.Commit()
-	must(err)
+	tx.Commit()
+
+	return revision, true
 }
 
+// DeleteFile moves the file to the trash, its tags are kept until it is purged
 func (db DB) DeleteFile(id int) {
-	_, err := db.db.Exec("DELETE FROM file WHERE id = ?", id)
+	_, err := db.conn().Exec("UPDATE file SET deleted_at = CURRENT_TIMESTAMP, revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
 	must(err)
 }
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Bookkeeping, cache and index tables, left out of dry-run summaries
+var dryRunIgnoredTables = append([]string{"operation", "audit", "content_hash", "schema_version"}, searchIndexTables...)
+
+type RowChange struct {
+	Table     string          `json:"table"`
+	Operation string          `json:"operation"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+type TableChanges struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Deleted  int `json:"deleted"`
+}
+
+type DryRunSummary struct {
+	Tables map[string]TableChanges `json:"tables"`
+	Rows   []RowChange             `json:"rows"`
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// BeginDryRun returns a DB bound to a new transaction that records every row it changes.
+// It must be rolled back, DryRunSummary describes what committing would have done.
+func (db DB) BeginDryRun() DB {
+	if db.tx != nil {
+		panic("Dry run cannot be started inside a transaction")
+	}
+
+	tx := db.Begin()
+	if db.postgres {
+		tx.recordPostgresChanges()
+	} else {
+		tx.recordSqliteChanges()
+	}
+	return tx
+}
+
+// dryRunTables returns the tables whose changes dry runs record, query selecting the column of table names
+func (db DB) dryRunTables(query string, column string) []string {
+	ignored := "'" + strings.Join(dryRunIgnoredTables, "', '") + "'"
+	tables := make([]string, 0)
+	rows, err := db.conn().Query(query + " AND " + column + " NOT IN (" + ignored + ")")
+	must(err)
+	for rows.Next() {
+		var table string
+		err := rows.Scan(&table)
+		must(err)
+		tables = append(tables, table)
+	}
+	must(rows.Err())
+	return tables
+}
+
+// recordSqliteChanges records the changes of the transaction with temporary triggers
+func (db DB) recordSqliteChanges() {
+	_, err := db.conn().Exec("CREATE TEMP TABLE dry_run_change (id INTEGER PRIMARY KEY, tbl TEXT NOT NULL, op TEXT NOT NULL, before TEXT, after TEXT)")
+	must(err)
+
+	for _, table := range db.dryRunTables("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'", "name") {
+		columns := make([]string, 0)
+		rows, err := db.conn().Query("SELECT name FROM pragma_table_info(?)", table)
+		must(err)
+		for rows.Next() {
+			var column string
+			err := rows.Scan(&column)
+			must(err)
+			columns = append(columns, column)
+		}
+		must(rows.Err())
+
+		// json_object('col', NEW."col", ...)
+		jsonRow := func(prefix string) string {
+			args := make([]string, 0, len(columns))
+			for _, column := range columns {
+				args = append(args, fmt.Sprintf("'%s', %s.%s", strings.ReplaceAll(column, "'", "''"), prefix, quoteIdentifier(column)))
+			}
+			return "json_object(" + strings.Join(args, ", ") + ")"
+		}
+
+		triggers := map[string][2]string{
+			"insert": {"NULL", jsonRow("NEW")},
+			"update": {jsonRow("OLD"), jsonRow("NEW")},
+			"delete": {jsonRow("OLD"), "NULL"},
+		}
+		for op, values := range triggers {
+			_, err := db.conn().Exec(fmt.Sprintf(`CREATE TEMP TRIGGER %s AFTER %s ON main.%s
+				BEGIN
+					INSERT INTO dry_run_change (tbl, op, before, after) VALUES ('%s', '%s', %s, %s);
+				END`,
+				quoteIdentifier("dry_run_"+table+"_"+op), strings.ToUpper(op), quoteIdentifier(table),
+				strings.ReplaceAll(table, "'", "''"), op, values[0], values[1]))
+			must(err)
+		}
+	}
+}
+
+// recordPostgresChanges records the changes of the transaction with triggers. PostgreSQL has no temporary triggers, so
+// they are created by the transaction and disappear when it is rolled back. Until then, other writers wait for it.
+func (db DB) recordPostgresChanges() {
+	_, err := db.conn().Exec("CREATE TEMP TABLE dry_run_change (id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY, tbl TEXT NOT NULL, op TEXT NOT NULL, before TEXT, after TEXT)")
+	must(err)
+	_, err = db.conn().Exec(`CREATE FUNCTION dry_run_record() RETURNS trigger AS $$
+		BEGIN
+			INSERT INTO dry_run_change (tbl, op, before, after) VALUES (TG_TABLE_NAME, lower(TG_OP),
+				CASE WHEN TG_OP <> 'INSERT' THEN row_to_json(OLD)::text END, CASE WHEN TG_OP <> 'DELETE' THEN row_to_json(NEW)::text END);
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`)
+	must(err)
+
+	for _, table := range db.dryRunTables("SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'", "table_name") {
+		_, err := db.conn().Exec("CREATE TRIGGER dry_run AFTER INSERT OR UPDATE OR DELETE ON " + quoteIdentifier(table) + " FOR EACH ROW EXECUTE FUNCTION dry_run_record()")
+		must(err)
+	}
+}
+
+func (db DB) DryRunSummary() DryRunSummary {
+	rows, err := db.conn().Query("SELECT tbl, op, before, after FROM dry_run_change ORDER BY id")
+	must(err)
+
+	summary := DryRunSummary{Tables: make(map[string]TableChanges), Rows: make([]RowChange, 0)}
+	for rows.Next() {
+		var change RowChange
+		var before, after *string
+		err := rows.Scan(&change.Table, &change.Operation, &before, &after)
+		must(err)
+
+		change.Before = json.RawMessage("null")
+		if before != nil {
+			change.Before = json.RawMessage(*before)
+		}
+		change.After = json.RawMessage("null")
+		if after != nil {
+			change.After = json.RawMessage(*after)
+		}
+		summary.Rows = append(summary.Rows, change)
+
+		counts := summary.Tables[change.Table]
+		switch change.Operation {
+		case "insert":
+			counts.Inserted++
+		case "update":
+			counts.Updated++
+		case "delete":
+			counts.Deleted++
+		}
+		summary.Tables[change.Table] = counts
+	}
+	must(rows.Err())
+
+	return summary
+}
@@ -0,0 +1,272 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// TagState is everything needed to recreate a tag, including the rows referencing it
+type TagState struct {
+	Name      string `json:"name"`
+	Color     string `json:"color"`
+	Order     int    `json:"order"`
+	OwnerId   *int   `json:"ownerId"`
+	Private   bool   `json:"private"`
+	ParentIds []int  `json:"parentIds"`
+	ChildIds  []int  `json:"childIds"`
+	FileIds   []int  `json:"fileIds"`
+
+	DeletedAt *time.Time `json:"deletedAt"`
+}
+
+// FileState is everything needed to recreate a file
+type FileState struct {
+	Path   string `json:"path"`
+	Name   string `json:"name"`
+	TagIds []int  `json:"tagIds"`
+
+	DeletedAt *time.Time `json:"deletedAt"`
+}
+
+const (
+	EntityTag      = "tag"
+	EntityFile     = "file"
+	EntityTagOrder = "tagOrder"
+)
+
+// Change is the JSON state of one entity before and after an operation, null meaning it did not exist
+type Change struct {
+	Entity string          `json:"entity"`
+	Id     int             `json:"id"`
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+type Operation struct {
+	Id          int       `json:"id"`
+	Description string    `json:"description"`
+	Changes     []Change  `json:"changes"`
+	Undone      bool      `json:"undone"`
+	UserId      *int      `json:"userId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func (db DB) queryIds(query string, args ...any) []int {
+	rows, err := db.conn().Query(query, args...)
+	must(err)
+
+	result := make([]int, 0)
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		must(err)
+
+		result = append(result, id)
+	}
+	must(rows.Err())
+
+	return result
+}
+
+// GetTagState returns nil if the tag does not exist
+func (db DB) GetTagState(id int) *TagState {
+	var state TagState
+	err := db.conn().QueryRow("SELECT name, color, \"order\", owner_id, private, deleted_at FROM tag WHERE id = ?", id).
+		Scan(&state.Name, &state.Color, &state.Order, &state.OwnerId, &state.Private, &state.DeletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+
+	state.ParentIds = db.queryIds("SELECT parent_tag_id FROM tag_parent_tag WHERE tag_id = ?", id)
+	state.ChildIds = db.queryIds("SELECT tag_id FROM tag_parent_tag WHERE parent_tag_id = ?", id)
+	state.FileIds = db.queryIds("SELECT file_id FROM file_tag WHERE tag_id = ?", id)
+
+	return &state
+}
+
+// RestoreTagState recreates or overwrites the tag to match state, or deletes it if state is nil.
+// References to tags or files that no longer exist are skipped, links and assignments that still exist are kept as they
+// are and new assignments belong to the DB's user.
+func (db DB) RestoreTagState(id int, state *TagState /* nilable */) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if state == nil {
+		_, err := tx.conn().Exec("DELETE FROM tag WHERE id = ?", id)
+		must(err)
+
+		tx.Commit()
+		return
+	}
+
+	_, err := tx.conn().Exec(`INSERT INTO tag (id, name, color, "order", owner_id, private, deleted_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, color = excluded.color, "order" = excluded."order", owner_id = excluded.owner_id,
+			private = excluded.private, deleted_at = excluded.deleted_at, revision = tag.revision + 1, updated_at = CURRENT_TIMESTAMP`,
+		id, state.Name, state.Color, state.Order, state.OwnerId, state.Private, state.DeletedAt)
+	must(err)
+
+	inParents, parentIds := tx.inIds("parent_tag_id", state.ParentIds)
+	_, err = tx.conn().Exec("DELETE FROM tag_parent_tag WHERE tag_id = ? AND NOT "+inParents, id, parentIds)
+	must(err)
+	inChildren, childIds := tx.inIds("tag_id", state.ChildIds)
+	_, err = tx.conn().Exec("DELETE FROM tag_parent_tag WHERE parent_tag_id = ? AND NOT "+inChildren, id, childIds)
+	must(err)
+	for _, parentId := range state.ParentIds {
+		_, err := tx.conn().Exec("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) SELECT CAST(? AS BIGINT), id FROM tag WHERE id = ? ON CONFLICT DO NOTHING", id, parentId)
+		must(err)
+	}
+	for _, childId := range state.ChildIds {
+		_, err := tx.conn().Exec("INSERT INTO tag_parent_tag (tag_id, parent_tag_id) SELECT id, CAST(? AS BIGINT) FROM tag WHERE id = ? ON CONFLICT DO NOTHING", id, childId)
+		must(err)
+	}
+
+	inFiles, fileIds := tx.inIds("file_id", state.FileIds)
+	_, err = tx.conn().Exec("DELETE FROM file_tag WHERE tag_id = ? AND NOT "+inFiles, id, fileIds)
+	must(err)
+	for _, fileId := range state.FileIds {
+		_, err := tx.conn().Exec("INSERT INTO file_tag (file_id, tag_id, owner_id) SELECT id, CAST(? AS BIGINT), CAST(? AS BIGINT) FROM file WHERE id = ? ON CONFLICT DO NOTHING",
+			id, db.user, fileId)
+		must(err)
+	}
+
+	tx.Commit()
+}
+
+// GetFileState returns nil if the file does not exist
+func (db DB) GetFileState(id int) *FileState {
+	var state FileState
+	err := db.conn().QueryRow("SELECT path, name, deleted_at FROM file WHERE id = ?", id).Scan(&state.Path, &state.Name, &state.DeletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+
+	state.TagIds = db.queryIds("SELECT tag_id FROM file_tag WHERE file_id = ?", id)
+
+	return &state
+}
+
+// RestoreFileState recreates or overwrites the file to match state, or deletes it if state is nil.
+// Tags that no longer exist are skipped, assignments that still exist keep their owner and new ones belong to the DB's user.
+func (db DB) RestoreFileState(id int, state *FileState /* nilable */) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	if state == nil {
+		_, err := tx.conn().Exec("DELETE FROM file WHERE id = ?", id)
+		must(err)
+		tx.unindexFile(id)
+
+		tx.Commit()
+		return
+	}
+
+	_, err := tx.conn().Exec(`INSERT INTO file (id, path, name, deleted_at, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET path = excluded.path, name = excluded.name, deleted_at = excluded.deleted_at,
+			revision = file.revision + 1, updated_at = CURRENT_TIMESTAMP`,
+		id, state.Path, state.Name, state.DeletedAt)
+	must(err)
+	tx.indexFileName(id, state.Name, state.Path)
+
+	inTags, tagIds := tx.inIds("tag_id", state.TagIds)
+	_, err = tx.conn().Exec("DELETE FROM file_tag WHERE file_id = ? AND NOT "+inTags, id, tagIds)
+	must(err)
+	for _, tagId := range state.TagIds {
+		_, err := tx.conn().Exec("INSERT INTO file_tag (file_id, tag_id, owner_id) SELECT CAST(? AS BIGINT), id, CAST(? AS BIGINT) FROM tag WHERE id = ? ON CONFLICT DO NOTHING",
+			id, db.user, tagId)
+		must(err)
+	}
+
+	tx.Commit()
+}
+
+func (db DB) GetTagsOrder() []int {
+	return db.queryIds("SELECT id FROM tag ORDER BY \"order\"")
+}
+
+// RecordOperation journals an operation and appends it to the audit log.
+// The operations the DB's user undid before it are discarded.
+func (db DB) RecordOperation(operation string, description string, changes ...Change) {
+	changesJson, err := json.Marshal(changes)
+	must(err)
+
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	own, params := db.ownOperations()
+	_, err = tx.conn().Exec("DELETE FROM operation WHERE undone AND "+own, params...)
+	must(err)
+
+	_, err = tx.conn().Exec("INSERT INTO operation (description, changes, user_id, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", description, string(changesJson), db.user)
+	must(err)
+
+	tx.RecordAudit(operation, changes...)
+
+	tx.Commit()
+}
+
+func (db DB) queryOperations(query string, args ...any) []Operation {
+	rows, err := db.conn().Query(query, args...)
+	must(err)
+
+	operations := make([]Operation, 0)
+	for rows.Next() {
+		var op Operation
+		var changesJson string
+		err := rows.Scan(&op.Id, &op.Description, &changesJson, &op.Undone, &op.UserId, &op.CreatedAt)
+		must(err)
+
+		err = json.Unmarshal([]byte(changesJson), &op.Changes)
+		must(err)
+
+		operations = append(operations, op)
+	}
+	must(rows.Err())
+
+	return operations
+}
+
+// GetOperations returns the most recent operations first, only those of the DB's user if it has one
+func (db DB) GetOperations(limit int) []Operation {
+	if db.user != nil {
+		return db.queryOperations("SELECT id, description, changes, undone, user_id, created_at FROM operation WHERE user_id = ? ORDER BY id DESC LIMIT ?", *db.user, limit)
+	}
+	return db.queryOperations("SELECT id, description, changes, undone, user_id, created_at FROM operation ORDER BY id DESC LIMIT ?", limit)
+}
+
+// ownOperations returns the condition that an operation was made by the DB's user, with its parameters. Operations
+// made without a user are their own journal.
+func (db DB) ownOperations() (string, []any) {
+	if db.user == nil {
+		return "user_id IS NULL", nil
+	}
+	return "user_id = ?", []any{*db.user}
+}
+
+// LastDoneOperation returns the operation undo would revert for the DB's user, or nil
+func (db DB) LastDoneOperation() *Operation {
+	own, params := db.ownOperations()
+	ops := db.queryOperations("SELECT id, description, changes, undone, user_id, created_at FROM operation WHERE NOT undone AND "+own+" ORDER BY id DESC LIMIT 1", params...)
+	if len(ops) == 0 {
+		return nil
+	}
+	return &ops[0]
+}
+
+// FirstUndoneOperation returns the operation redo would reapply for the DB's user, or nil
+func (db DB) FirstUndoneOperation() *Operation {
+	own, params := db.ownOperations()
+	ops := db.queryOperations("SELECT id, description, changes, undone, user_id, created_at FROM operation WHERE undone AND "+own+" ORDER BY id LIMIT 1", params...)
+	if len(ops) == 0 {
+		return nil
+	}
+	return &ops[0]
+}
+
+func (db DB) SetOperationUndone(id int, undone bool) {
+	_, err := db.conn().Exec("UPDATE operation SET undone = ? WHERE id = ?", undone, id)
+	must(err)
+}
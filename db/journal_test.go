@@ -0,0 +1,155 @@
+package db_test
+
+import (
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Each user undoes and redoes their own operations, whatever the others did since
+func TestUndoIsPerUser(t *testing.T) {
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	alice := db_.WithUser(action.AddUser(db_, "alice"))
+	bob := db_.WithUser(action.AddUser(db_, "bob"))
+
+	action.AddTag(alice, "first", "#000000", false, nil)
+	second := action.AddTag(bob, "second", "#000000", false, nil)
+
+	testutil.Equal(t, "undone by alice", "Add tag 'first'", action.Undo(alice).Description)
+	testutil.Equal(t, "tags", []int{second}, tagIds(action.ListTags(db_)))
+
+	testutil.Equal(t, "undone by bob", "Add tag 'second'", action.Undo(bob).Description)
+	testutil.ExpectPanic[action.ConflictError](t, func() { action.Undo(bob) })
+
+	// A new operation of alice discards her undone operations, not bob's
+	third := action.AddTag(alice, "third", "#000000", false, nil)
+	testutil.ExpectPanic[action.ConflictError](t, func() { action.Redo(alice) })
+	testutil.Equal(t, "redone by bob", "Add tag 'second'", action.Redo(bob).Description)
+
+	testutil.Equal(t, "tags", sorted([]int{second, third}), sorted(tagIds(action.ListTags(db_))))
+}
+
+// Undoing reverts only what the operation changed, and refuses when that was changed since
+func TestUndoKeepsLaterEdits(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	alice := db_.WithUser(action.AddUser(db_, "alice"))
+	bob := db_.WithUser(action.AddUser(db_, "bob"))
+	x := action.AddTag(db_, "x", "#000000", false, nil)
+	y := action.AddTag(db_, "y", "#000000", false, nil)
+	fileId := action.AddFile(db_, testutil.WriteFile(t, dir, "a.txt", "a"), nil)
+	tagsOf := func(fileId int) []int {
+		return sorted(tagIds(action.GetFile(db_, fileId).Tags))
+	}
+
+	t.Run("another user's later edit", func(t *testing.T) {
+		// Bob's tag is kept when alice undoes hers
+		action.EditFile(alice, fileId, nil, []int{x})
+		action.EditFile(bob, fileId, nil, []int{x, y})
+		action.Undo(alice)
+		testutil.Equal(t, "tags", []int{y}, tagsOf(fileId))
+		action.Undo(bob)
+		testutil.Equal(t, "tags", []int{}, tagsOf(fileId))
+
+		// Alice's rename was renamed again by bob
+		action.EditTag(alice, x, nil, &[]string{"renamed by alice"}[0], nil, nil, nil)
+		action.EditTag(bob, x, nil, &[]string{"renamed by bob"}[0], nil, nil, nil)
+		testutil.ExpectPanic[action.ConflictError](t, func() { action.Undo(alice) })
+		testutil.Equal(t, "name", "renamed by bob", action.GetTag(db_, x).Name)
+	})
+
+	t.Run("later edit by the same user", func(t *testing.T) {
+		action.EditTag(alice, y, nil, &[]string{"first"}[0], nil, nil, nil)
+		action.EditTag(alice, y, nil, &[]string{"second"}[0], nil, nil, nil)
+		action.Undo(alice)
+		testutil.Equal(t, "name", "first", action.GetTag(db_, y).Name)
+		action.Undo(alice)
+		testutil.Equal(t, "name", "y", action.GetTag(db_, y).Name)
+
+		// Redoing refuses too once the tag was changed after the undo
+		action.EditTag(bob, y, nil, &[]string{"third"}[0], nil, nil, nil)
+		testutil.ExpectPanic[action.ConflictError](t, func() { action.Redo(alice) })
+		testutil.Equal(t, "name", "third", action.GetTag(db_, y).Name)
+	})
+
+	t.Run("child tag created after the operation", func(t *testing.T) {
+		parent := action.AddTag(alice, "parent", "#000000", false, nil)
+		action.EditTag(alice, parent, nil, nil, &[]string{"#FFFFFF"}[0], nil, nil)
+		child := action.AddTag(bob, "child", "#000000", false, []int{parent})
+
+		action.Undo(alice)
+		testutil.Equal(t, "color", "#000000", action.GetTag(db_, parent).Color)
+		testutil.Equal(t, "parents of the child", []int{parent}, action.GetTag(db_, child).ParentIds)
+
+		// The parent cannot be removed from under the child
+		testutil.ExpectPanic[action.ConflictError](t, func() { action.Undo(alice) })
+		testutil.Equal(t, "parents of the child", []int{parent}, action.GetTag(db_, child).ParentIds)
+	})
+
+	t.Run("assignment restored by undo", func(t *testing.T) {
+		action.EditFile(alice, fileId, nil, []int{x})
+		action.EditFile(alice, fileId, nil, []int{})
+		action.Undo(alice)
+		testutil.Equal(t, "tags", []int{x}, tagsOf(fileId))
+		testutil.Equal(t, "owner", action.UserId(db_, "alice"), *db_.FileTagOwner(fileId, x))
+	})
+}
+
+// Undoing and redoing cannot touch what the tag and file actions would not let the user touch
+func TestUndoChecksPermissions(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	alice := db_.WithUser(action.AddUser(db_, "alice"))
+	bob := db_.WithUser(action.AddUser(db_, "bob"))
+	carol := db_.WithUser(action.AddUser(db_, "carol"))
+	private := func(tagId int) {
+		action.EditTag(carol, tagId, nil, nil, nil, &[]bool{true}[0], nil)
+	}
+
+	shared := action.AddTag(db_, "shared", "#000000", false, nil)
+	fileId := action.AddFile(db_, testutil.WriteFile(t, dir, "a.txt", "a"), nil)
+
+	t.Run("assignment of a tag made private since", func(t *testing.T) {
+		tagId := action.AddTag(carol, "carol's", "#000000", false, nil)
+		action.EditFile(alice, fileId, nil, []int{tagId})
+		private(tagId)
+
+		testutil.ExpectPanic[action.PermissionError](t, func() { action.Undo(alice) })
+		testutil.Equal(t, "tags", []int{tagId}, sorted(db_.GetFileState(fileId).TagIds))
+		action.EditFile(db_, fileId, nil, []int{})
+	})
+
+	t.Run("parent made private since", func(t *testing.T) {
+		parent := action.AddTag(carol, "carol's parent", "#000000", false, nil)
+		child := action.AddTag(alice, "alice's child", "#000000", false, nil)
+		action.EditTag(alice, child, nil, nil, nil, nil, &[]int{parent})
+		private(parent)
+
+		testutil.ExpectPanic[action.PermissionError](t, func() { action.Undo(alice) })
+		testutil.Equal(t, "parents", []int{parent}, db_.GetTagState(child).ParentIds)
+		action.EditTag(db_, child, nil, nil, nil, nil, &[]int{})
+	})
+
+	t.Run("each user undoes their own changes", func(t *testing.T) {
+		action.EditFile(alice, fileId, nil, []int{shared})
+		bobs := action.AddTag(bob, "bob's", "#000000", false, nil)
+		action.EditFile(bob, fileId, nil, []int{shared, bobs})
+
+		// Bob cannot remove alice's assignment by undoing his operations
+		action.Undo(bob)
+		testutil.Equal(t, "tags after bob's undo", []int{shared}, sorted(tagIds(action.GetFile(db_, fileId).Tags)))
+		action.Undo(bob)
+		testutil.Equal(t, "bob's tag", false, db_.TagExists(bobs))
+
+		action.Redo(bob)
+		action.Redo(bob)
+		testutil.Equal(t, "tags after bob's redo", sorted([]int{shared, bobs}), sorted(tagIds(action.GetFile(db_, fileId).Tags)))
+
+		// Alice's undo removes her assignment and leaves bob's
+		action.Undo(alice)
+		testutil.Equal(t, "tags after alice's undo", []int{bobs}, sorted(tagIds(action.GetFile(db_, fileId).Tags)))
+		testutil.Equal(t, "owner of bob's assignment", action.UserId(db_, "bob"), *db_.FileTagOwner(fileId, bobs))
+	})
+}
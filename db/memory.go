@@ -0,0 +1,846 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps tags and files in memory, behaving like DB does for the actions of the Store interface.
+// It is meant for tests: nothing is persisted, and names and content are searched without a search index.
+// Copies share the same data, and a transaction holds it locked until it commits or rolls back.
+type MemoryStore struct {
+	state *memoryState
+
+	// tx is set on stores bound to a transaction
+	tx *memoryTx
+
+	// nested is set when Begin joined an already running transaction
+	nested bool
+
+	// user restricts the tags and files visible through this store, see WithUser
+	user *int
+}
+
+type memoryState struct {
+	lock sync.Mutex
+	data memoryData
+}
+
+// memoryTx is a transaction of a MemoryStore, restoring snapshot if it is rolled back
+type memoryTx struct {
+	snapshot memoryData
+	done     bool
+}
+
+type memoryData struct {
+	tags       map[int]*memoryTag
+	files      map[int]*memoryFile
+	operations []Operation
+	lastTagId  int
+	lastFileId int
+	lastOpId   int
+}
+
+type memoryTag struct {
+	Tag
+	order int
+}
+
+type memoryFile struct {
+	File
+	// tagOwners maps the ids of the file's tags to who assigned them
+	tagOwners map[int]*int
+	text      string
+}
+
+func NewMemoryStore() MemoryStore {
+	return MemoryStore{state: &memoryState{data: memoryData{
+		tags:  make(map[int]*memoryTag),
+		files: make(map[int]*memoryFile),
+	}}}
+}
+
+// clone copies the data deeply enough for changes to the copy to leave it untouched
+func (d memoryData) clone() memoryData {
+	c := d
+	c.tags = make(map[int]*memoryTag, len(d.tags))
+	for id, tag := range d.tags {
+		t := *tag
+		t.ParentIds = append([]int{}, tag.ParentIds...)
+		c.tags[id] = &t
+	}
+	c.files = make(map[int]*memoryFile, len(d.files))
+	for id, file := range d.files {
+		f := *file
+		f.Metadata = copyMetadata(file.Metadata)
+		f.tagOwners = make(map[int]*int, len(file.tagOwners))
+		for tagId, owner := range file.tagOwners {
+			f.tagOwners[tagId] = owner
+		}
+		c.files[id] = &f
+	}
+	c.operations = append([]Operation{}, d.operations...)
+	return c
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	c := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		c[key] = value
+	}
+	return c
+}
+
+// data locks the store's data, unless it is already locked by the store's transaction, and returns it with the function
+// unlocking it
+func (s MemoryStore) data() (*memoryData, func()) {
+	if s.tx != nil {
+		return &s.state.data, func() {}
+	}
+	s.state.lock.Lock()
+	return &s.state.data, s.state.lock.Unlock
+}
+
+// Begin returns a store bound to a new transaction, which waits for the one running to finish.
+// If s is already bound to a transaction, it is joined and Commit/Rollback are left to its owner.
+func (s MemoryStore) Begin() MemoryStore {
+	if s.tx != nil {
+		s.nested = true
+		return s
+	}
+
+	s.state.lock.Lock()
+	s.tx = &memoryTx{snapshot: s.state.data.clone()}
+	return s
+}
+
+func (s MemoryStore) Commit() {
+	if s.nested || s.tx.done {
+		return
+	}
+	s.tx.done = true
+	s.state.lock.Unlock()
+}
+
+// Rollback is a no-op if the transaction was already committed, so it can always be deferred
+func (s MemoryStore) Rollback() {
+	if s.nested || s.tx.done {
+		return
+	}
+	s.state.data = s.tx.snapshot
+	s.tx.done = true
+	s.state.lock.Unlock()
+}
+
+// User returns the user whose tags and assignments are visible through this store, nil when everything is visible
+func (s MemoryStore) User() *int {
+	return s.user
+}
+
+// WithUser returns a store that only sees shared tags and the private tags of user, and attributes new tags and
+// assignments to them
+func (s MemoryStore) WithUser(user int) MemoryStore {
+	s.user = &user
+	return s
+}
+
+// HasSearchIndex is false, names being searched like DB does without search indexes
+func (s MemoryStore) HasSearchIndex() bool {
+	return false
+}
+
+// Operations returns the recorded operations, the oldest first
+func (s MemoryStore) Operations() []Operation {
+	data, unlock := s.data()
+	defer unlock()
+	return append([]Operation{}, data.operations...)
+}
+
+func (s MemoryStore) visibleTag(tag *memoryTag) bool {
+	return s.user == nil || !tag.Private || (tag.OwnerId != nil && *tag.OwnerId == *s.user)
+}
+
+func (s MemoryStore) liveTag(data *memoryData, id int) *memoryTag /* nilable */ {
+	tag, ok := data.tags[id]
+	if !ok || tag.DeletedAt != nil || !s.visibleTag(tag) {
+		return nil
+	}
+	return tag
+}
+
+// visibleFile returns whether the file is visible, which it is not when every tag it has is private to someone else
+func (s MemoryStore) visibleFile(data *memoryData, file *memoryFile) bool {
+	if s.user == nil {
+		return true
+	}
+	hasLiveTag := false
+	for tagId := range file.tagOwners {
+		tag := data.tags[tagId]
+		if tag == nil || tag.DeletedAt != nil {
+			continue
+		}
+		if s.visibleTag(tag) {
+			return true
+		}
+		hasLiveTag = true
+	}
+	return !hasLiveTag
+}
+
+func (s MemoryStore) liveFile(data *memoryData, id int) *memoryFile /* nilable */ {
+	file, ok := data.files[id]
+	if !ok || file.DeletedAt != nil || !s.visibleFile(data, file) {
+		return nil
+	}
+	return file
+}
+
+func (s MemoryStore) fileWithPath(data *memoryData, path string, deleted bool) *memoryFile /* nilable */ {
+	for _, file := range data.files {
+		if file.Path == path && (file.DeletedAt != nil) == deleted {
+			return file
+		}
+	}
+	return nil
+}
+
+// tag returns a copy of a tag with its live and visible parents
+func (s MemoryStore) tag(data *memoryData, tag *memoryTag) Tag {
+	t := tag.Tag
+	t.ParentIds = make([]int, 0, len(tag.ParentIds))
+	for _, parentId := range tag.ParentIds {
+		if s.liveTag(data, parentId) != nil {
+			t.ParentIds = append(t.ParentIds, parentId)
+		}
+	}
+	return t
+}
+
+// sortedTags returns every tag, by display order
+func (data *memoryData) sortedTags() []*memoryTag {
+	tags := make([]*memoryTag, 0, len(data.tags))
+	for _, tag := range data.tags {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].order != tags[j].order {
+			return tags[i].order < tags[j].order
+		}
+		return tags[i].Id < tags[j].Id
+	})
+	return tags
+}
+
+func (s MemoryStore) GetAllTags() []Tag {
+	data, unlock := s.data()
+	defer unlock()
+
+	tags := make([]Tag, 0)
+	for _, tag := range data.sortedTags() {
+		if tag.DeletedAt == nil && s.visibleTag(tag) {
+			tags = append(tags, s.tag(data, tag))
+		}
+	}
+	return tags
+}
+
+// GetTag returns nil if the tag does not exist or is not visible
+func (s MemoryStore) GetTag(id int) *Tag {
+	data, unlock := s.data()
+	defer unlock()
+
+	tag := s.liveTag(data, id)
+	if tag == nil {
+		return nil
+	}
+	t := s.tag(data, tag)
+	return &t
+}
+
+func (s MemoryStore) TagExists(id int) bool {
+	data, unlock := s.data()
+	defer unlock()
+	return s.liveTag(data, id) != nil
+}
+
+func (s MemoryStore) TagInTrash(id int) bool {
+	data, unlock := s.data()
+	defer unlock()
+
+	tag, ok := data.tags[id]
+	return ok && tag.DeletedAt != nil && s.visibleTag(tag)
+}
+
+// existingTag returns a tag even if it is deleted or not visible, and panics if it does not exist
+func (data *memoryData) existingTag(id int) *memoryTag {
+	tag, ok := data.tags[id]
+	if !ok {
+		panic(fmt.Sprintf("Tag id '%v' does not exist", id))
+	}
+	return tag
+}
+
+// TagOwner returns nil if the tag has no owner
+func (s MemoryStore) TagOwner(id int) *int {
+	data, unlock := s.data()
+	defer unlock()
+	return data.existingTag(id).OwnerId
+}
+
+func (s MemoryStore) TagRevision(id int) int {
+	data, unlock := s.data()
+	defer unlock()
+	return data.existingTag(id).Revision
+}
+
+func uniqueIds(ids []int) []int {
+	unique := make([]int, 0, len(ids))
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// InsertTag creates a tag owned by the store's user
+func (s MemoryStore) InsertTag(name string, color string, private bool, parentIds []int) int {
+	data, unlock := s.data()
+	defer unlock()
+
+	order := 0
+	for _, tag := range data.tags {
+		if tag.order > order {
+			order = tag.order
+		}
+	}
+
+	data.lastTagId++
+	data.tags[data.lastTagId] = &memoryTag{
+		Tag: Tag{
+			Id:        data.lastTagId,
+			Name:      name,
+			Color:     color,
+			ParentIds: uniqueIds(parentIds),
+			OwnerId:   s.user,
+			Private:   private,
+			Revision:  1,
+			UpdatedAt: time.Now().UTC(),
+		},
+		order: order + 1,
+	}
+	return data.lastTagId
+}
+
+// UpdateTag returns the new revision of the tag, or false if expectedRevision no longer matches
+func (s MemoryStore) UpdateTag(id int, expectedRevision *int /* nilable */, name *string /* nilable */, color *string /* nilable */, private *bool /* nilable */, parentIds *[]int /* nilable */) (int, bool) {
+	data, unlock := s.data()
+	defer unlock()
+
+	tag, ok := data.tags[id]
+	if !ok || (expectedRevision != nil && tag.Revision != *expectedRevision) {
+		return 0, false
+	}
+
+	tag.Revision++
+	tag.UpdatedAt = time.Now().UTC()
+	if name != nil {
+		tag.Name = *name
+	}
+	if color != nil {
+		tag.Color = *color
+	}
+	if private != nil {
+		tag.Private = *private
+	}
+	if parentIds != nil {
+		tag.ParentIds = uniqueIds(*parentIds)
+	}
+	return tag.Revision, true
+}
+
+// DeleteTag moves the tag to the trash, its assignments and relations are kept
+func (s MemoryStore) DeleteTag(id int) {
+	data, unlock := s.data()
+	defer unlock()
+
+	tag, ok := data.tags[id]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	tag.DeletedAt = &now
+	tag.UpdatedAt = now
+	tag.Revision++
+}
+
+// GetAllParentTagIds returns the tag and its ancestors
+func (s MemoryStore) GetAllParentTagIds(tagId int) []int {
+	data, unlock := s.data()
+	defer unlock()
+
+	result := make([]int, 0)
+	if _, ok := data.tags[tagId]; !ok {
+		return result
+	}
+	seen := map[int]bool{tagId: true}
+	for queue := []int{tagId}; len(queue) != 0; queue = queue[1:] {
+		result = append(result, queue[0])
+		tag, ok := data.tags[queue[0]]
+		if !ok {
+			continue
+		}
+		for _, parentId := range tag.ParentIds {
+			if !seen[parentId] {
+				seen[parentId] = true
+				queue = append(queue, parentId)
+			}
+		}
+	}
+	return result
+}
+
+func (s MemoryStore) GetTagsOrder() []int {
+	data, unlock := s.data()
+	defer unlock()
+
+	ids := make([]int, 0, len(data.tags))
+	for _, tag := range data.sortedTags() {
+		ids = append(ids, tag.Id)
+	}
+	return ids
+}
+
+func (s MemoryStore) UpdateTagsOrder(ids []int) {
+	data, unlock := s.data()
+	defer unlock()
+
+	for i, id := range ids {
+		if tag, ok := data.tags[id]; ok {
+			tag.order = i
+		}
+	}
+}
+
+// GetTagState returns nil if the tag does not exist
+func (s MemoryStore) GetTagState(id int) *TagState {
+	data, unlock := s.data()
+	defer unlock()
+
+	tag, ok := data.tags[id]
+	if !ok {
+		return nil
+	}
+
+	state := TagState{
+		Name:      tag.Name,
+		Color:     tag.Color,
+		Order:     tag.order,
+		OwnerId:   tag.OwnerId,
+		Private:   tag.Private,
+		ParentIds: append([]int{}, tag.ParentIds...),
+		ChildIds:  make([]int, 0),
+		FileIds:   make([]int, 0),
+		DeletedAt: tag.DeletedAt,
+	}
+	sort.Ints(state.ParentIds)
+	for _, child := range data.tags {
+		for _, parentId := range child.ParentIds {
+			if parentId == id {
+				state.ChildIds = append(state.ChildIds, child.Id)
+			}
+		}
+	}
+	sort.Ints(state.ChildIds)
+	for _, file := range data.files {
+		if _, ok := file.tagOwners[id]; ok {
+			state.FileIds = append(state.FileIds, file.Id)
+		}
+	}
+	sort.Ints(state.FileIds)
+	return &state
+}
+
+func (s MemoryStore) FileExists(id int) bool {
+	data, unlock := s.data()
+	defer unlock()
+	return s.liveFile(data, id) != nil
+}
+
+func (s MemoryStore) FileExistsPath(path string) bool {
+	data, unlock := s.data()
+	defer unlock()
+	return s.fileWithPath(data, path, false) != nil
+}
+
+func (s MemoryStore) FileInTrashPath(path string) bool {
+	data, unlock := s.data()
+	defer unlock()
+	return s.fileWithPath(data, path, true) != nil
+}
+
+func (s MemoryStore) FilePathFromId(id int) string {
+	data, unlock := s.data()
+	defer unlock()
+
+	file := s.liveFile(data, id)
+	if file == nil {
+		panic(fmt.Sprintf("File '%v' does not exist", id))
+	}
+	return file.Path
+}
+
+func (s MemoryStore) FileIdFromPath(path string) int {
+	data, unlock := s.data()
+	defer unlock()
+
+	file := s.fileWithPath(data, path, false)
+	if file == nil {
+		panic(fmt.Sprintf("File '%v' does not exist", path))
+	}
+	return file.Id
+}
+
+// AddFile tracks a file with the given tags, those that do not exist being skipped
+func (s MemoryStore) AddFile(path string, tagIds []int) int {
+	data, unlock := s.data()
+	defer unlock()
+
+	// name is filename without extension
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	data.lastFileId++
+	file := &memoryFile{
+		File: File{
+			Id:        data.lastFileId,
+			Path:      path,
+			Name:      name,
+			Revision:  1,
+			UpdatedAt: time.Now().UTC(),
+			MimeType:  "application/octet-stream",
+			Kind:      "other",
+			Metadata:  make(map[string]string),
+		},
+		tagOwners: make(map[int]*int),
+	}
+	for _, tagId := range tagIds {
+		if _, ok := data.tags[tagId]; ok {
+			file.tagOwners[tagId] = s.user
+		}
+	}
+	data.files[file.Id] = file
+	return file.Id
+}
+
+// file returns a copy of a file with its live and visible tags, those not in only being left out unless it is nil
+func (s MemoryStore) file(data *memoryData, file *memoryFile, only map[int]bool /* nilable */) File {
+	f := file.File
+	f.Metadata = copyMetadata(file.Metadata)
+	f.Tags = make([]Tag, 0)
+	for tagId := range file.tagOwners {
+		tag := s.liveTag(data, tagId)
+		if tag != nil && (only == nil || only[tagId]) {
+			t := tag.Tag
+			// Like DB, the tags of files are returned without their parents
+			t.ParentIds = nil
+			f.Tags = append(f.Tags, t)
+		}
+	}
+	sort.Slice(f.Tags, func(i, j int) bool { return f.Tags[i].Id < f.Tags[j].Id })
+	return f
+}
+
+// GetFile returns nil if the file does not exist or is not visible
+func (s MemoryStore) GetFile(id int) *File {
+	data, unlock := s.data()
+	defer unlock()
+
+	file := s.liveFile(data, id)
+	if file == nil {
+		return nil
+	}
+	f := s.file(data, file, nil)
+	return &f
+}
+
+// GetFiles returns the live and visible files of ids, by name
+func (s MemoryStore) GetFiles(ids []int) []File {
+	data, unlock := s.data()
+	defer unlock()
+
+	files := make([]File, 0, len(ids))
+	for _, id := range ids {
+		if file := s.liveFile(data, id); file != nil {
+			files = append(files, s.file(data, file, nil))
+		}
+	}
+	sortByName(files)
+	return files
+}
+
+// sortByName sorts files like DB does, by name then id
+func sortByName(files []File) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Name != files[j].Name {
+			return files[i].Name < files[j].Name
+		}
+		return files[i].Id < files[j].Id
+	})
+}
+
+// hierarchy returns a live tag and its live descendants, or nothing if the tag is deleted
+func (data *memoryData) hierarchy(root int) map[int]bool {
+	ids := make(map[int]bool)
+	if tag, ok := data.tags[root]; !ok || tag.DeletedAt != nil {
+		return ids
+	}
+	ids[root] = true
+	for queue := []int{root}; len(queue) != 0; queue = queue[1:] {
+		for _, tag := range data.tags {
+			if tag.DeletedAt != nil || ids[tag.Id] {
+				continue
+			}
+			for _, parentId := range tag.ParentIds {
+				if parentId == queue[0] {
+					ids[tag.Id] = true
+					queue = append(queue, tag.Id)
+					break
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// containsFold reports whether s contains substr, ignoring case like SQLite's LIKE
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// SearchFiles returns the files matching search by name, like DB does without search indexes
+func (s MemoryStore) SearchFiles(search FileSearch) []File {
+	data, unlock := s.data()
+	defer unlock()
+
+	// Files have every searched tag or one of its descendants, and only those tags are returned
+	var hierarchies []map[int]bool
+	var searchedTags map[int]bool
+	if len(search.TagIds) != 0 {
+		searchedTags = make(map[int]bool)
+		for _, root := range uniqueIds(search.TagIds) {
+			hierarchy := data.hierarchy(root)
+			hierarchies = append(hierarchies, hierarchy)
+			for id := range hierarchy {
+				searchedTags[id] = true
+			}
+		}
+	}
+
+	kinds := make(map[string]bool)
+	for _, kind := range search.Kinds {
+		kinds[kind] = true
+	}
+
+	var words []string
+	if search.Text != nil {
+		words = strings.Fields(*search.Text)
+	}
+
+	files := make([]File, 0)
+	for _, file := range data.files {
+		if file.DeletedAt != nil || !s.visibleFile(data, file) {
+			continue
+		}
+		if search.Name != nil && !containsFold(file.Name, *search.Name) && !containsFold(file.Path, *search.Name) {
+			continue
+		}
+		if len(kinds) != 0 && !kinds[file.Kind] {
+			continue
+		}
+
+		matches := true
+		for _, hierarchy := range hierarchies {
+			found := false
+			for tagId := range file.tagOwners {
+				if hierarchy[tagId] {
+					found = true
+					break
+				}
+			}
+			matches = matches && found
+		}
+		for _, word := range words {
+			matches = matches && containsFold(file.text, word)
+		}
+		for key, value := range search.Metadata {
+			current, ok := file.Metadata[key]
+			matches = matches && ok && containsFold(current, value)
+		}
+		if !matches {
+			continue
+		}
+
+		f := s.file(data, file, searchedTags)
+		// Files are only found through tags they can see
+		if searchedTags != nil && len(f.Tags) == 0 {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	sortByName(files)
+	return files
+}
+
+// UpdateFileTags returns the new revision of the file, or false if expectedRevision no longer matches.
+// Tags that are not visible to the store's user are left untouched, and so are trashed tags, which come back with
+// their files when restored.
+func (s MemoryStore) UpdateFileTags(fileId int, expectedRevision *int /* nilable */, tagIds []int) (int, bool) {
+	data, unlock := s.data()
+	defer unlock()
+
+	file, ok := data.files[fileId]
+	if !ok || (expectedRevision != nil && file.Revision != *expectedRevision) {
+		return 0, false
+	}
+	for _, tagId := range tagIds {
+		data.existingTag(tagId)
+	}
+
+	file.Revision++
+	file.UpdatedAt = time.Now().UTC()
+
+	wanted := make(map[int]bool)
+	for _, tagId := range tagIds {
+		wanted[tagId] = true
+	}
+	for tagId := range file.tagOwners {
+		if tag := data.tags[tagId]; !wanted[tagId] && tag.DeletedAt == nil && s.visibleTag(tag) {
+			delete(file.tagOwners, tagId)
+		}
+	}
+	for tagId := range wanted {
+		if _, ok := file.tagOwners[tagId]; !ok {
+			file.tagOwners[tagId] = s.user
+		}
+	}
+	return file.Revision, true
+}
+
+// existingFile returns a file even if it is deleted or not visible, and panics if it does not exist
+func (data *memoryData) existingFile(id int) *memoryFile {
+	file, ok := data.files[id]
+	if !ok {
+		panic(fmt.Sprintf("File '%v' does not exist", id))
+	}
+	return file
+}
+
+func (s MemoryStore) FileRevision(id int) int {
+	data, unlock := s.data()
+	defer unlock()
+	return data.existingFile(id).Revision
+}
+
+// FileTagOwner returns the user who assigned the tag to the file, nil if it has no owner
+func (s MemoryStore) FileTagOwner(fileId int, tagId int) *int {
+	data, unlock := s.data()
+	defer unlock()
+
+	owner, ok := data.existingFile(fileId).tagOwners[tagId]
+	if !ok {
+		panic(fmt.Sprintf("Tag id '%v' is not assigned to file '%v'", tagId, fileId))
+	}
+	return owner
+}
+
+// DeleteFile moves the file to the trash, its tags are kept
+func (s MemoryStore) DeleteFile(id int) {
+	data, unlock := s.data()
+	defer unlock()
+
+	file, ok := data.files[id]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	file.DeletedAt = &now
+	file.UpdatedAt = now
+	file.Revision++
+}
+
+// SetFileType sets the detected MIME type and kind of a file
+func (s MemoryStore) SetFileType(id int, mimeType string, kind string) {
+	data, unlock := s.data()
+	defer unlock()
+
+	if file, ok := data.files[id]; ok {
+		file.MimeType = mimeType
+		file.Kind = kind
+	}
+}
+
+// SetFileMetadata replaces the metadata of a file
+func (s MemoryStore) SetFileMetadata(fileId int, values map[string]string) {
+	data, unlock := s.data()
+	defer unlock()
+
+	if file, ok := data.files[fileId]; ok {
+		file.Metadata = copyMetadata(values)
+	}
+}
+
+// SetFileText replaces the text of a file, which Text searches match the words of
+func (s MemoryStore) SetFileText(fileId int, text string) {
+	data, unlock := s.data()
+	defer unlock()
+
+	if file, ok := data.files[fileId]; ok {
+		file.text = text
+	}
+}
+
+// GetFileState returns nil if the file does not exist
+func (s MemoryStore) GetFileState(id int) *FileState {
+	data, unlock := s.data()
+	defer unlock()
+
+	file, ok := data.files[id]
+	if !ok {
+		return nil
+	}
+
+	state := FileState{
+		Path:      file.Path,
+		Name:      file.Name,
+		TagIds:    make([]int, 0, len(file.tagOwners)),
+		DeletedAt: file.DeletedAt,
+	}
+	for tagId := range file.tagOwners {
+		state.TagIds = append(state.TagIds, tagId)
+	}
+	sort.Ints(state.TagIds)
+	return &state
+}
+
+// RecordOperation journals an operation, which cannot be undone as the store only keeps the operations
+func (s MemoryStore) RecordOperation(operation string, description string, changes ...Change) {
+	data, unlock := s.data()
+	defer unlock()
+
+	data.lastOpId++
+	data.operations = append(data.operations, Operation{
+		Id:          data.lastOpId,
+		Description: description,
+		Changes:     changes,
+		UserId:      s.user,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
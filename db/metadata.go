@@ -0,0 +1,76 @@
+package db
+
+// SetFileMetadata replaces the metadata of a file, only writing the values that changed
+func (db DB) SetFileMetadata(fileId int, values map[string]string) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	current := tx.getFilesMetadata(map[int]File{fileId: {}})[fileId]
+	for key := range current {
+		if _, ok := values[key]; !ok {
+			_, err := tx.conn().Exec("DELETE FROM file_metadata WHERE file_id = ? AND key = ?", fileId, key)
+			must(err)
+		}
+	}
+	for key, value := range values {
+		if old, ok := current[key]; ok && old == value {
+			continue
+		}
+		_, err := tx.conn().Exec(`INSERT INTO file_metadata (file_id, key, value) VALUES (?, ?, ?)
+			ON CONFLICT (file_id, key) DO UPDATE SET value = excluded.value`, fileId, key, value)
+		must(err)
+	}
+
+	tx.Commit()
+}
+
+// getFilesMetadata returns the metadata of the given files by file id, files without any being left out
+func (db DB) getFilesMetadata(files map[int]File) map[int]map[string]string {
+	metadata := make(map[int]map[string]string)
+	if len(files) == 0 {
+		return metadata
+	}
+
+	ids := make([]int, 0, len(files))
+	for id := range files {
+		ids = append(ids, id)
+	}
+	inFiles, idsParam := db.inIds("file_id", ids)
+
+	rows, err := db.conn().Query("SELECT file_id, key, value FROM file_metadata WHERE "+inFiles, idsParam)
+	must(err)
+	for rows.Next() {
+		var fileId int
+		var key, value string
+		err := rows.Scan(&fileId, &key, &value)
+		must(err)
+
+		if metadata[fileId] == nil {
+			metadata[fileId] = make(map[string]string)
+		}
+		metadata[fileId][key] = value
+	}
+	must(rows.Err())
+
+	return metadata
+}
+
+// GetMetadataKeys returns the metadata keys of the live files visible to the DB's user, with how many files have each
+func (db DB) GetMetadataKeys() map[string]int {
+	visible, params := db.visibleFile("f")
+	rows, err := db.conn().Query(`SELECT m.key, COUNT(*) FROM file_metadata m JOIN file f ON f.id = m.file_id
+		WHERE f.deleted_at IS NULL AND `+visible+` GROUP BY m.key`, params...)
+	must(err)
+
+	keys := make(map[string]int)
+	for rows.Next() {
+		var key string
+		var count int
+		err := rows.Scan(&key, &count)
+		must(err)
+		keys[key] = count
+	}
+	must(rows.Err())
+
+	return keys
+}
@@ -0,0 +1,8 @@
+ALTER TABLE file ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE file ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+ALTER TABLE tag ADD COLUMN revision INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE tag ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+UPDATE file SET updated_at = CURRENT_TIMESTAMP;
+UPDATE tag SET updated_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,7 @@
+CREATE TABLE operation (
+    id INTEGER PRIMARY KEY,
+    description TEXT NOT NULL,
+    changes TEXT NOT NULL,
+    undone INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,7 @@
+ALTER TABLE file ADD COLUMN deleted_at TIMESTAMP NULL;
+ALTER TABLE tag ADD COLUMN deleted_at TIMESTAMP NULL;
+
+CREATE TABLE setting (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
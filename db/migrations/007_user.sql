@@ -0,0 +1,15 @@
+CREATE TABLE user (
+    id INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+-- Keys without a user act as administrators and see everything
+ALTER TABLE api_key ADD COLUMN user_id INTEGER NULL REFERENCES user (id) ON DELETE CASCADE;
+
+-- Tags and assignments without an owner predate accounts and belong to everyone
+ALTER TABLE tag ADD COLUMN owner_id INTEGER NULL REFERENCES user (id) ON DELETE SET NULL;
+ALTER TABLE tag ADD COLUMN private INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE file_tag ADD COLUMN owner_id INTEGER NULL REFERENCES user (id) ON DELETE SET NULL;
+
+ALTER TABLE operation ADD COLUMN user_id INTEGER NULL;
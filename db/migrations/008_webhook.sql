@@ -0,0 +1,27 @@
+CREATE TABLE webhook (
+    id INTEGER PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    -- JSON array of event names, "*" matching every event
+    events TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE webhook_delivery (
+    id INTEGER PRIMARY KEY,
+    webhook_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    audit_id INTEGER NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status_code INTEGER NULL,
+    error TEXT NULL,
+    -- NULL once delivered or given up
+    next_attempt_at TIMESTAMP NULL,
+    delivered_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (webhook_id) REFERENCES webhook (id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_delivery_pending ON webhook_delivery (next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+CREATE INDEX webhook_delivery_webhook ON webhook_delivery (webhook_id, id);
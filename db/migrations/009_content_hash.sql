@@ -0,0 +1,7 @@
+-- Cache of the SHA-256 of files on disk, valid while their size and modification time are unchanged
+CREATE TABLE content_hash (
+    path TEXT PRIMARY KEY,
+    size INTEGER NOT NULL,
+    modified_at INTEGER NOT NULL,
+    hash TEXT NOT NULL
+);
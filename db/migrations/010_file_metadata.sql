@@ -0,0 +1,9 @@
+-- Values read from the content of files, like the camera of a photo or the artist of a song
+CREATE TABLE file_metadata (
+    file_id INTEGER NOT NULL REFERENCES file(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (file_id, key)
+);
+
+CREATE INDEX file_metadata_key_value ON file_metadata (key, value);
@@ -0,0 +1,5 @@
+-- Type of the content of files, detected when they are added. Files added before are detected by `metadata rebuild`.
+ALTER TABLE file ADD COLUMN mime_type TEXT NOT NULL DEFAULT 'application/octet-stream';
+ALTER TABLE file ADD COLUMN kind TEXT NOT NULL DEFAULT 'other';
+
+CREATE INDEX file_kind ON file (kind);
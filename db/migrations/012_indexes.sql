@@ -0,0 +1,30 @@
+-- Link tables get primary keys, dropping duplicate rows, and indexes for lookups from their other side
+
+CREATE TABLE file_tag_new (
+    file_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    owner_id INTEGER NULL REFERENCES user (id) ON DELETE SET NULL,
+    PRIMARY KEY (file_id, tag_id),
+    FOREIGN KEY (file_id) REFERENCES file (id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tag (id) ON DELETE CASCADE
+) WITHOUT ROWID;
+INSERT OR IGNORE INTO file_tag_new (file_id, tag_id, owner_id) SELECT file_id, tag_id, owner_id FROM file_tag ORDER BY rowid;
+DROP TABLE file_tag;
+ALTER TABLE file_tag_new RENAME TO file_tag;
+CREATE INDEX file_tag_tag ON file_tag (tag_id, file_id);
+
+CREATE TABLE tag_parent_tag_new (
+    tag_id INTEGER NOT NULL,
+    parent_tag_id INTEGER NOT NULL,
+    PRIMARY KEY (tag_id, parent_tag_id),
+    FOREIGN KEY (tag_id) REFERENCES tag (id) ON DELETE CASCADE,
+    FOREIGN KEY (parent_tag_id) REFERENCES tag (id) ON DELETE CASCADE
+) WITHOUT ROWID;
+INSERT OR IGNORE INTO tag_parent_tag_new (tag_id, parent_tag_id) SELECT tag_id, parent_tag_id FROM tag_parent_tag;
+DROP TABLE tag_parent_tag;
+ALTER TABLE tag_parent_tag_new RENAME TO tag_parent_tag;
+CREATE INDEX tag_parent_tag_parent ON tag_parent_tag (parent_tag_id, tag_id);
+
+-- Files are looked up by path when added and edited from the CLI, and listed by name
+CREATE INDEX file_path ON file (path);
+CREATE INDEX file_live_name ON file (name) WHERE deleted_at IS NULL;
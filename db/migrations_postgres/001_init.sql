@@ -0,0 +1,141 @@
+-- The schema of the SQLite migrations up to 012_indexes.sql, which new PostgreSQL databases start from.
+-- Later changes to the schema get a migration here as well as in migrations.
+
+CREATE TABLE "user" (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+-- Tags and assignments without an owner belong to everyone
+CREATE TABLE tag (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    color TEXT NOT NULL,
+    "order" INTEGER NOT NULL,
+    revision INTEGER NOT NULL DEFAULT 1,
+    updated_at TIMESTAMPTZ NOT NULL,
+    deleted_at TIMESTAMPTZ NULL,
+    owner_id BIGINT NULL REFERENCES "user" (id) ON DELETE SET NULL,
+    private BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE tag_parent_tag (
+    tag_id BIGINT NOT NULL REFERENCES tag (id) ON DELETE CASCADE,
+    parent_tag_id BIGINT NOT NULL REFERENCES tag (id) ON DELETE CASCADE,
+    PRIMARY KEY (tag_id, parent_tag_id)
+);
+CREATE INDEX tag_parent_tag_parent ON tag_parent_tag (parent_tag_id, tag_id);
+
+CREATE TABLE file (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    path TEXT NOT NULL,
+    name TEXT NOT NULL,
+    revision INTEGER NOT NULL DEFAULT 1,
+    updated_at TIMESTAMPTZ NOT NULL,
+    deleted_at TIMESTAMPTZ NULL,
+    mime_type TEXT NOT NULL DEFAULT 'application/octet-stream',
+    kind TEXT NOT NULL DEFAULT 'other'
+);
+CREATE INDEX file_kind ON file (kind);
+CREATE INDEX file_path ON file (path);
+CREATE INDEX file_live_name ON file (name COLLATE "C") WHERE deleted_at IS NULL;
+
+CREATE TABLE file_tag (
+    file_id BIGINT NOT NULL REFERENCES file (id) ON DELETE CASCADE,
+    tag_id BIGINT NOT NULL REFERENCES tag (id) ON DELETE CASCADE,
+    owner_id BIGINT NULL REFERENCES "user" (id) ON DELETE SET NULL,
+    PRIMARY KEY (file_id, tag_id)
+);
+CREATE INDEX file_tag_tag ON file_tag (tag_id, file_id);
+
+-- Values read from the content of files, like the camera of a photo or the artist of a song
+CREATE TABLE file_metadata (
+    file_id BIGINT NOT NULL REFERENCES file (id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (file_id, key)
+);
+CREATE INDEX file_metadata_key_value ON file_metadata (key, value);
+
+CREATE TABLE operation (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    description TEXT NOT NULL,
+    changes TEXT NOT NULL,
+    undone BOOLEAN NOT NULL DEFAULT FALSE,
+    user_id BIGINT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE audit (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    operation TEXT NOT NULL,
+    entity TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    before TEXT NOT NULL,
+    after TEXT NOT NULL,
+    origin TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX audit_entity ON audit (entity, entity_id);
+CREATE INDEX audit_created_at ON audit (created_at);
+
+CREATE FUNCTION audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_no_update BEFORE UPDATE ON audit FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+CREATE TRIGGER audit_no_delete BEFORE DELETE ON audit FOR EACH ROW EXECUTE FUNCTION audit_append_only();
+
+CREATE TABLE setting (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+-- Keys without a user act as administrators and see everything
+CREATE TABLE api_key (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name TEXT NOT NULL,
+    hash TEXT NOT NULL UNIQUE,
+    scope TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ NULL,
+    revoked_at TIMESTAMPTZ NULL,
+    user_id BIGINT NULL REFERENCES "user" (id) ON DELETE CASCADE
+);
+
+CREATE TABLE webhook (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    -- JSON array of event names, "*" matching every event
+    events TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE webhook_delivery (
+    id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES webhook (id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    audit_id BIGINT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status_code INTEGER NULL,
+    error TEXT NULL,
+    -- NULL once delivered or given up
+    next_attempt_at TIMESTAMPTZ NULL,
+    delivered_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX webhook_delivery_pending ON webhook_delivery (next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+CREATE INDEX webhook_delivery_webhook ON webhook_delivery (webhook_id, id);
+
+-- Cache of the SHA-256 of files on disk, valid while their size and modification time are unchanged
+CREATE TABLE content_hash (
+    path TEXT PRIMARY KEY,
+    size BIGINT NOT NULL,
+    modified_at BIGINT NOT NULL,
+    hash TEXT NOT NULL
+);
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		expected string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT id FROM tag WHERE name = ? AND owner_id = ?", "SELECT id FROM tag WHERE name = $1 AND owner_id = $2"},
+		{"INSERT INTO file (name, path) VALUES (?,?)", "INSERT INTO file (name, path) VALUES ($1,$2)"},
+		{"SELECT '?' || ? FROM t WHERE a = '??'", "SELECT '?' || $1 FROM t WHERE a = '??'"},
+		{"SELECT 'it''s ?', ? FROM t", "SELECT 'it''s ?', $1 FROM t"},
+		{`SELECT "what?" FROM t WHERE "a""?" = ?`, `SELECT "what?" FROM t WHERE "a""?" = $1`},
+		{`SELECT '"?', ?, "'?", ?`, `SELECT '"?', $1, "'?", $2`},
+		// Queries have no comments, which are not skipped
+		{"SELECT ? -- é ?", "SELECT $1 -- é $2"},
+		{"SELECT 'unterminated ?", "SELECT 'unterminated ?"},
+		{"?", "$1"},
+		{"", ""},
+	} {
+		testutil.Equal(t, test.query, test.expected, rebind(test.query))
+	}
+}
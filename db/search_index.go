@@ -0,0 +1,269 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The search indexes are FTS5 tables keyed by file id: file_name of the names and paths of files, and file_text of
+// their content. SQLite only has FTS5 in builds made with -tags sqlite_fts5, so the tables are created at startup rather
+// than by a migration, and databases stay usable by other builds.
+
+// searchIndexTables are the FTS5 tables and the tables they store their index in
+var searchIndexTables = []string{
+	"file_name", "file_name_data", "file_name_idx", "file_name_content", "file_name_docsize", "file_name_config", "file_name_vocab",
+	"file_text", "file_text_data", "file_text_idx", "file_text_content", "file_text_docsize", "file_text_config",
+}
+
+// initSearchIndexes creates the search indexes if SQLite has FTS5, and returns whether it has
+func initSearchIndexes(db *sql.DB) bool {
+	var enabled bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	must(err)
+	if !enabled {
+		return false
+	}
+
+	// unicode61 folds case and accents, and splits paths and names like holiday_2023-beach.jpg into words
+	for _, statement := range []string{
+		"CREATE VIRTUAL TABLE IF NOT EXISTS file_name USING fts5(name, path, prefix = '2 3', tokenize = 'unicode61 remove_diacritics 2')",
+		"CREATE VIRTUAL TABLE IF NOT EXISTS file_name_vocab USING fts5vocab(file_name, row)",
+		"CREATE VIRTUAL TABLE IF NOT EXISTS file_text USING fts5(text, tokenize = 'unicode61 remove_diacritics 2')",
+
+		// Builds without FTS5 add and delete files without updating the indexes. Ids of purged files are reused, so the
+		// text of a file whose indexed name and path are not its own belongs to a purged file.
+		`DELETE FROM file_text WHERE rowid NOT IN (SELECT n.rowid FROM file_name n JOIN file f ON f.id = n.rowid AND f.name = n.name AND f.path = n.path)`,
+		`DELETE FROM file_name WHERE rowid NOT IN (SELECT n.rowid FROM file_name n JOIN file f ON f.id = n.rowid AND f.name = n.name AND f.path = n.path)`,
+		"INSERT INTO file_name (rowid, name, path) SELECT id, name, path FROM file WHERE id NOT IN (SELECT rowid FROM file_name)",
+	} {
+		_, err := db.Exec(statement)
+		must(err)
+	}
+	return true
+}
+
+// HasSearchIndex returns whether files can be searched by content and with typos
+func (db DB) HasSearchIndex() bool {
+	return db.searchIndex
+}
+
+// SetFileText replaces the indexed text of a file, it does nothing without search indexes
+func (db DB) SetFileText(fileId int, text string) {
+	if !db.searchIndex {
+		return
+	}
+
+	var current string
+	err := db.conn().QueryRow("SELECT text FROM file_text WHERE rowid = ?", fileId).Scan(&current)
+	if err == nil && current == text {
+		return
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		must(err)
+	}
+
+	_, err = db.conn().Exec("DELETE FROM file_text WHERE rowid = ?", fileId)
+	must(err)
+	if text != "" {
+		_, err := db.conn().Exec("INSERT INTO file_text (rowid, text) VALUES (?, ?)", fileId, text)
+		must(err)
+	}
+}
+
+// indexFileName replaces the indexed name and path of a file
+func (db DB) indexFileName(fileId int, name string, path string) {
+	if !db.searchIndex {
+		return
+	}
+	_, err := db.conn().Exec("DELETE FROM file_name WHERE rowid = ?", fileId)
+	must(err)
+	_, err = db.conn().Exec("INSERT INTO file_name (rowid, name, path) VALUES (?, ?, ?)", fileId, name, path)
+	must(err)
+}
+
+// unindexFile removes a deleted file from the search indexes
+func (db DB) unindexFile(fileId int) {
+	if !db.searchIndex {
+		return
+	}
+	for _, table := range []string{"file_name", "file_text"} {
+		_, err := db.conn().Exec("DELETE FROM "+table+" WHERE rowid = ?", fileId)
+		must(err)
+	}
+}
+
+// ftsString quotes a word for an FTS5 query
+func ftsString(word string) string {
+	return `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+}
+
+// textQuery turns the words of a search into an FTS5 query matching files containing all of them
+func textQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = ftsString(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// nameWords splits a searched name the way unicode61 splits indexed ones
+func nameWords(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// nameQuery matches files whose name or path have words starting with every word of name, best matches first.
+// Matches in the name rank above matches in the path. Fuzzy adds the indexed words a typo or two away from each word.
+// Without search indexes, the name or path must contain name.
+func (db DB) nameQuery(name string, fuzzy bool) fileQuery {
+	words := nameWords(name)
+	if !db.searchIndex || len(words) == 0 {
+		return fileQuery{
+			wheres: []string{"(f.name " + db.like() + " ? OR f.path " + db.like() + " ?)"},
+			params: []any{"%" + name + "%", "%" + name + "%"},
+		}
+	}
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		// Whole words also match the exact phrase, which ranks them above words they start
+		alternatives := []string{ftsString(word), ftsString(word) + "*"}
+		if fuzzy {
+			for _, term := range db.similarTerms(foldWord(word)) {
+				alternatives = append(alternatives, ftsString(term))
+			}
+		}
+		terms[i] = "(" + strings.Join(alternatives, " OR ") + ")"
+	}
+
+	return fileQuery{
+		join: `JOIN (SELECT rowid AS id, bm25(file_name, 10.0, 1.0) AS score FROM file_name WHERE file_name MATCH ?) name_match
+			ON name_match.id = f.id`,
+		joinParams: []any{strings.Join(terms, " AND ")},
+		// bm25 is lower for better matches
+		order: "name_match.score, f.name",
+	}
+}
+
+var accents = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// foldWord lowercases a word and removes its accents, like the indexed words
+func foldWord(word string) string {
+	folded, _, err := transform.String(accents, strings.ToLower(word))
+	if err != nil {
+		return strings.ToLower(word)
+	}
+	return folded
+}
+
+// maxSimilarTerms bounds the words a searched word can stand for in a fuzzy search
+const maxSimilarTerms = 10
+
+// similarTerms returns the indexed words closest to word, allowing a typo in words of 3 to 5 letters and two in longer
+// ones. Words being typed match the start of longer words. Only the indexed words starting with the first or second
+// letter of word are compared to it, so the first letter can be swapped or doubled but not mistyped, which keeps
+// fuzzy searches fast with hundreds of thousands of files.
+func (db DB) similarTerms(word string) []string {
+	letters := []rune(word)
+	maxDistance := 2
+	switch {
+	case len(letters) < 3:
+		return nil
+	case len(letters) <= 5:
+		maxDistance = 1
+	}
+
+	type candidate struct {
+		term     string
+		distance int
+	}
+	candidates := make([]candidate, 0)
+	firsts := []rune{letters[0]}
+	if letters[1] != letters[0] {
+		firsts = append(firsts, letters[1])
+	}
+	for _, first := range firsts {
+		// Terms are sorted, so those starting with first are between it and first followed by the last character
+		rows, err := db.conn().Query("SELECT term FROM file_name_vocab WHERE term >= ? AND term < ? AND length(term) >= ?",
+			string(first), string(first)+string(unicode.MaxRune), len(letters)-maxDistance)
+		must(err)
+
+		for rows.Next() {
+			var term string
+			err := rows.Scan(&term)
+			must(err)
+
+			termLetters := []rune(term)
+			distance := editDistance(letters, termLetters)
+			// The typo can be in a word being typed, which is compared to starts of about its length
+			for n := len(letters) - maxDistance; n <= len(letters)+maxDistance && n < len(termLetters); n++ {
+				distance = minInt(distance, editDistance(letters, termLetters[:n]))
+			}
+			if distance > 0 && distance <= maxDistance {
+				candidates = append(candidates, candidate{term, distance})
+			}
+		}
+		must(rows.Err())
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].term < candidates[j].term
+	})
+	if len(candidates) > maxSimilarTerms {
+		candidates = candidates[:maxSimilarTerms]
+	}
+
+	terms := make([]string, len(candidates))
+	for i, c := range candidates {
+		terms[i] = c.term
+	}
+	return terms
+}
+
+// editDistance is the Levenshtein distance, swapping two letters counting as one edit
+func editDistance(a []rune, b []rune) int {
+	// rows[i%3][j] is the distance between a[:i] and b[:j], the two previous rows being needed for swaps
+	var rows [3][]int
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		row, prev := rows[i%3], rows[(i-1)%3]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			row[j] = minInt(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				row[j] = minInt(row[j], rows[(i-2)%3][j-2]+1)
+			}
+		}
+	}
+	return rows[len(a)%3][len(b)]
+}
+
+func minInt(first int, others ...int) int {
+	for _, n := range others {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
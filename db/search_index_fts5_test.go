@@ -0,0 +1,164 @@
+//go:build sqlite_fts5
+
+package db
+
+import (
+	"path/filepath"
+	"sort"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+func initSearchIndexDb(t *testing.T) DB {
+	t.Helper()
+	db := Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	if !db.HasSearchIndex() {
+		t.Fatal("expected SQLite to have FTS5 in builds with the sqlite_fts5 tag")
+	}
+	return db
+}
+
+func searchIds(db DB, search FileSearch) []int {
+	ids := make([]int, 0)
+	for _, file := range db.SearchFiles(search) {
+		ids = append(ids, file.Id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestSetFileText(t *testing.T) {
+	db := initSearchIndexDb(t)
+	report := db.AddFile("/docs/report.txt", nil)
+	notes := db.AddFile("/docs/notes.md", nil)
+	db.SetFileText(report, "Quarterly Café report")
+	db.SetFileText(notes, "Report of the meeting, in Ελληνικά")
+
+	expectText := func(step string, text string, expected ...int) {
+		t.Helper()
+		testutil.Equal(t, step+": "+text, append([]int{}, expected...), searchIds(db, FileSearch{Text: &text}))
+	}
+	expectText("set", "quarterly", report)
+	expectText("set", "QUARTERLY report", report)
+	expectText("set", "cafe", report)
+	expectText("set", "REPORT", report, notes)
+	expectText("set", "ελληνικά", notes)
+	expectText("set", "quarterly meeting")
+	expectText("set", `report OR "meeting"`)
+	expectText("set", "quart")
+
+	db.SetFileText(report, "Quarterly Café report")
+	expectText("unchanged", "quarterly", report)
+
+	db.SetFileText(report, "Annual summary")
+	expectText("replaced", "quarterly")
+	expectText("replaced", "annual", report)
+
+	db.SetFileText(report, "")
+	expectText("cleared", "annual")
+	var count int
+	must(db.conn().QueryRow("SELECT COUNT(*) FROM file_text").Scan(&count))
+	testutil.Equal(t, "indexed texts", 1, count)
+
+	// Ids of purged files are reused, so their text must not be found for the next file
+	db.PurgeFile(notes)
+	added := db.AddFile("/docs/other.txt", nil)
+	testutil.Equal(t, "reused id", notes, added)
+	expectText("purged", "meeting")
+}
+
+// Builds without FTS5 change files without updating the indexes, which catch up when the database is opened again
+func TestSearchIndexCatchesUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+	db := Init(path)
+	kept := db.AddFile("/docs/kept.txt", nil)
+	purged := db.AddFile("/docs/purged.txt", nil)
+	db.SetFileText(kept, "kept words")
+	db.SetFileText(purged, "purged words")
+
+	without := db
+	without.searchIndex = false
+	_, err := without.conn().Exec("DELETE FROM file WHERE id = ?", purged)
+	must(err)
+	without.SetFileText(kept, "ignored")
+	added := without.AddFile("/docs/added.txt", nil)
+	testutil.Equal(t, "reused id", purged, added)
+
+	db = Init(path)
+	text := "words"
+	testutil.Equal(t, "text", []int{kept}, searchIds(db, FileSearch{Text: &text}))
+	name := "added"
+	testutil.Equal(t, "name", []int{added}, searchIds(db, FileSearch{Name: &name}))
+	name = "purged"
+	testutil.Equal(t, "purged name", []int{}, searchIds(db, FileSearch{Name: &name}))
+}
+
+func TestSearchIndexTables(t *testing.T) {
+	db := initSearchIndexDb(t)
+	for _, table := range searchIndexTables {
+		var count int
+		must(db.conn().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", table).Scan(&count))
+		testutil.Equal(t, table, 1, count)
+	}
+}
+
+func TestSimilarTerms(t *testing.T) {
+	db := initSearchIndexDb(t)
+	for _, name := range []string{"holiday", "holidays", "beach", "beaches", "bleach", "hotel", "tesa", "tesb", "tesc", "tesd",
+		"tese", "tesf", "tesg", "tesh", "tesi", "tesj", "tesk", "tesl"} {
+		db.AddFile("/p/"+name+".jpg", nil)
+	}
+
+	for _, test := range []struct {
+		word     string
+		expected []string
+	}{
+		{"holdiay", []string{"holiday", "holidays"}},
+		{"ohliday", []string{"holiday", "holidays"}},
+		{"bech", []string{"beach", "beaches"}},
+		// Words starting an indexed one are already matched as prefixes
+		{"beach", []string{"bleach"}},
+		{"holidya", []string{"holiday", "holidays"}},
+		{"hotle", []string{"hotel"}},
+		// The first letter cannot be mistyped
+		{"nolidays", []string{}},
+		{"be", []string{}},
+		{"xyz", []string{}},
+		{"tesz", []string{"tesa", "tesb", "tesc", "tesd", "tese", "tesf", "tesg", "tesh", "tesi", "tesj"}},
+	} {
+		testutil.Equal(t, test.word, test.expected, append([]string{}, db.similarTerms(test.word)...))
+	}
+}
+
+func TestSearchByContentAndTypos(t *testing.T) {
+	db := initSearchIndexDb(t)
+	inName := db.AddFile("/photos/holiday beach.jpg", nil)
+	inPath := db.AddFile("/holiday/sunset.jpg", nil)
+	report := db.AddFile("/docs/report.pdf", nil)
+	notes := db.AddFile("/docs/notes.txt", nil)
+	db.SetFileText(report, "Holiday expenses for the quarter")
+	db.SetFileText(notes, "Packing list for the beach")
+
+	search := func(search FileSearch) []int {
+		ids := make([]int, 0)
+		for _, file := range db.SearchFiles(search) {
+			ids = append(ids, file.Id)
+		}
+		return ids
+	}
+	text := func(text string) *string { return &text }
+
+	// Matches in names rank above matches in paths
+	testutil.Equal(t, "name", []int{inName, inPath}, search(FileSearch{Name: text("holiday")}))
+	testutil.Equal(t, "prefix", []int{inName, inPath}, search(FileSearch{Name: text("holi")}))
+	testutil.Equal(t, "typo", []int{}, search(FileSearch{Name: text("holdiay")}))
+	testutil.Equal(t, "fuzzy typo", []int{inName, inPath}, search(FileSearch{Name: text("holdiay"), Fuzzy: true}))
+	testutil.Equal(t, "fuzzy typos", []int{inName}, search(FileSearch{Name: text("holdiay bech"), Fuzzy: true}))
+	testutil.Equal(t, "fuzzy exact", []int{inName}, search(FileSearch{Name: text("Beach"), Fuzzy: true}))
+
+	testutil.Equal(t, "content", []int{report}, searchIds(db, FileSearch{Text: text("holiday")}))
+	testutil.Equal(t, "content words", []int{notes}, searchIds(db, FileSearch{Text: text("beach packing")}))
+	testutil.Equal(t, "content and name", []int{notes}, searchIds(db, FileSearch{Text: text("for the"), Name: text("notes")}))
+	testutil.Equal(t, "content typo", []int{}, searchIds(db, FileSearch{Text: text("expenes")}))
+	testutil.Equal(t, "blank content", []int{inName, inPath, report, notes}, searchIds(db, FileSearch{Text: text("  ")}))
+}
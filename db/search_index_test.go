@@ -0,0 +1,38 @@
+package db
+
+import (
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	for _, test := range []struct {
+		a        string
+		b        string
+		expected int
+	}{
+		{"", "", 0},
+		{"beach", "beach", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"bech", "beach", 1},
+		{"beach", "bleach", 1},
+		{"holdiay", "holiday", 1},
+		{"ab", "ba", 1},
+		{"ohliday", "holiday", 1},
+		// A swapped pair is not edited again
+		{"ca", "abc", 3},
+		{"café", "cafe", 1},
+		{"日本語", "日本", 1},
+	} {
+		testutil.Equal(t, test.a+" to "+test.b, test.expected, editDistance([]rune(test.a), []rune(test.b)))
+		testutil.Equal(t, test.b+" to "+test.a, test.expected, editDistance([]rune(test.b), []rune(test.a)))
+	}
+}
+
+func TestSearchWords(t *testing.T) {
+	testutil.Equal(t, "name words", []string{"Holiday", "2023", "beach", "jpg"}, nameWords("Holiday_2023-beach.jpg"))
+	testutil.Equal(t, "no name words", []string{}, nameWords(" _-. "))
+	testutil.Equal(t, "folded", "eclair a la creme", foldWord("Éclair à la CRÈME"))
+	testutil.Equal(t, "text query", `"quarterly" "say""hi""" "OR" "*"`, textQuery(` quarterly say"hi" OR  * `))
+}
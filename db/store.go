@@ -0,0 +1,68 @@
+package db
+
+// Store holds the tags and files the actions work on. DB keeps them in SQLite or PostgreSQL, and MemoryStore in memory.
+// S is the type implementing it, which Begin returns.
+type Store[S any] interface {
+	// Begin returns a store bound to a new transaction, or joins the one it is already bound to.
+	// Its changes are only seen by others after Commit.
+	Begin() S
+	Commit()
+	// Rollback is a no-op if the transaction was already committed, so it can always be deferred
+	Rollback()
+
+	// User is who the store acts as, nil for administrators who see every tag
+	User() *int
+	// HasSearchIndex returns whether files can be searched by content and with typos
+	HasSearchIndex() bool
+
+	GetAllTags() []Tag
+	// GetTag returns nil if the tag does not exist or is not visible
+	GetTag(id int) *Tag
+	TagExists(id int) bool
+	TagInTrash(id int) bool
+	// TagOwner returns nil if the tag has no owner
+	TagOwner(id int) *int
+	TagRevision(id int) int
+	// InsertTag creates a tag owned by the store's user
+	InsertTag(name string, color string, private bool, parentIds []int) int
+	// UpdateTag returns the new revision of the tag, or false if expectedRevision no longer matches
+	UpdateTag(id int, expectedRevision *int /* nilable */, name *string /* nilable */, color *string /* nilable */, private *bool /* nilable */, parentIds *[]int /* nilable */) (int, bool)
+	// DeleteTag moves the tag to the trash
+	DeleteTag(id int)
+	// GetAllParentTagIds returns the tag and its ancestors
+	GetAllParentTagIds(tagId int) []int
+	GetTagsOrder() []int
+	UpdateTagsOrder(ids []int)
+	// GetTagState returns nil if the tag does not exist
+	GetTagState(id int) *TagState
+
+	FileExists(id int) bool
+	FileExistsPath(path string) bool
+	FileInTrashPath(path string) bool
+	FilePathFromId(id int) string
+	FileIdFromPath(path string) int
+	AddFile(path string, tagIds []int) int
+	// GetFile returns nil if the file does not exist or is not visible
+	GetFile(id int) *File
+	// GetFiles returns the files of ids that exist and are visible, by name
+	GetFiles(ids []int) []File
+	SearchFiles(search FileSearch) []File
+	// UpdateFileTags returns the new revision of the file, or false if expectedRevision no longer matches
+	UpdateFileTags(fileId int, expectedRevision *int /* nilable */, tagIds []int) (int, bool)
+	FileRevision(id int) int
+	// FileTagOwner returns the user who assigned the tag to the file, nil if it has no owner
+	FileTagOwner(fileId int, tagId int) *int
+	// DeleteFile moves the file to the trash
+	DeleteFile(id int)
+	SetFileType(id int, mimeType string, kind string)
+	SetFileMetadata(fileId int, values map[string]string)
+	SetFileText(fileId int, text string)
+	// GetFileState returns nil if the file does not exist
+	GetFileState(id int) *FileState
+
+	// RecordOperation journals an operation so it can be undone
+	RecordOperation(operation string, description string, changes ...Change)
+}
+
+var _ Store[DB] = DB{}
+var _ Store[MemoryStore] = MemoryStore{}
@@ -0,0 +1,229 @@
+package db_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"tagged-fs/metadata"
+	"testing"
+	"time"
+)
+
+// postgresEnv is the environment variable holding the DSN of a PostgreSQL database to test against
+const postgresEnv = "TAGGED_FS_POSTGRES"
+
+// postgresDsn returns the DSN of a new schema of the PostgreSQL database of postgresEnv, dropped when the test ends,
+// and skips the test when postgresEnv is not set
+func postgresDsn(t *testing.T) string {
+	dsn := os.Getenv(postgresEnv)
+	if dsn == "" {
+		t.Skip(postgresEnv + " is not set")
+	}
+
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("tagged_fs_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := conn.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+
+	// DSNs are URLs or key=value pairs, both passing search_path to the server
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// step runs f as a subtest, failing it when f panics rather than ending the whole test binary
+func step(t *testing.T, name string, f func(t *testing.T)) {
+	t.Run(name, func(t *testing.T) {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Fatalf("panicked: %v", r)
+			}
+		}()
+		f(t)
+	})
+}
+
+func fileIds(files []db.File) []int {
+	ids := make([]int, len(files))
+	for i, file := range files {
+		ids[i] = file.Id
+	}
+	return ids
+}
+
+func sorted(ids []int) []int {
+	sort.Ints(ids)
+	return ids
+}
+
+func tagIds(tags []db.Tag) []int {
+	ids := make([]int, len(tags))
+	for i, tag := range tags {
+		ids[i] = tag.Id
+	}
+	return ids
+}
+
+// TestStoreConformance runs the actions on tags and files against every store implementation, which must behave the
+// way the actions expect. PostgreSQL is only tested when TAGGED_FS_POSTGRES is set.
+func TestStoreConformance(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		dir := t.TempDir()
+		testConformance(t, db.Init(filepath.Join(dir, "db.sqlite3")), dir)
+	})
+	t.Run("memory", func(t *testing.T) {
+		testConformance(t, db.NewMemoryStore(), t.TempDir())
+	})
+	t.Run("postgres", func(t *testing.T) {
+		testConformance(t, db.OpenPostgres(postgresDsn(t)), t.TempDir())
+	})
+}
+
+// testConformance runs the actions against an empty store, in a directory for the added files. Each step builds on
+// the previous ones.
+func testConformance[S db.Store[S]](t *testing.T, store S, dir string) {
+	str := func(s string) *string { return &s }
+	var root, child, other, a, b, c int
+
+	step(t, "add tags", func(t *testing.T) {
+		root = action.AddTag(store, "root", "#abcdef", false, nil)
+		child = action.AddTag(store, "child", "#000000", false, []int{root})
+		other = action.AddTag(store, "other", "#FFFFFF", false, nil)
+
+		testutil.Equal(t, "tags", []int{root, child, other}, tagIds(action.ListTags(store)))
+		testutil.Equal(t, "color", "#ABCDEF", action.GetTag(store, root).Color)
+		testutil.Equal(t, "parents", []int{root}, action.GetTag(store, child).ParentIds)
+		testutil.Equal(t, "revision", 1, action.GetTag(store, root).Revision)
+	})
+
+	step(t, "invalid tags", func(t *testing.T) {
+		testutil.ExpectPanic[action.InvalidError](t, func() { action.AddTag(store, "red", "red", false, nil) })
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.AddTag(store, "orphan", "#000000", false, []int{999}) })
+		testutil.ExpectPanic[action.InvalidError](t, func() { action.AddTag(store, "private", "#000000", true, nil) })
+		testutil.ExpectPanic[action.InvalidError](t, func() { action.EditTag(store, root, nil, nil, nil, nil, &[]int{root}) })
+		testutil.ExpectPanic[action.InvalidError](t, func() { action.EditTag(store, root, nil, nil, nil, nil, &[]int{child}) })
+		testutil.Equal(t, "tags", []int{root, child, other}, tagIds(action.ListTags(store)))
+	})
+
+	step(t, "edit tag", func(t *testing.T) {
+		revision := action.GetTag(store, root).Revision
+		testutil.Equal(t, "revision", revision+1, action.EditTag(store, root, &revision, str("renamed"), nil, nil, nil))
+		testutil.Equal(t, "name", "renamed", action.GetTag(store, root).Name)
+		testutil.ExpectPanic[action.StaleRevisionError](t, func() { action.EditTag(store, root, &revision, str("stale"), nil, nil, nil) })
+		testutil.Equal(t, "name after stale edit", "renamed", action.GetTag(store, root).Name)
+	})
+
+	step(t, "add files", func(t *testing.T) {
+		a = action.AddFile(store, testutil.WriteFile(t, dir, "a.txt", "a.txt"), []int{child})
+		b = action.AddFile(store, testutil.WriteFile(t, dir, "b.txt", "b.txt"), []int{root, other})
+		c = action.AddFile(store, testutil.WriteFile(t, dir, "zebra.txt", "zebra.txt"), nil)
+
+		file := action.GetFile(store, a)
+		testutil.Equal(t, "name", "a", file.Name)
+		testutil.Equal(t, "path", filepath.Join(dir, "a.txt"), action.FilePath(store, a))
+		testutil.Equal(t, "tags", []int{child}, tagIds(file.Tags))
+		testutil.Equal(t, "kind", metadata.Kind(file.MimeType), file.Kind)
+		testutil.ExpectPanic[action.ConflictError](t, func() { action.AddFile(store, filepath.Join(dir, "a.txt"), nil) })
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.AddFile(store, testutil.WriteFile(t, dir, "d.txt", "d.txt"), []int{999}) })
+		testutil.Equal(t, "files", []int{a, b, c}, fileIds(action.ListFiles(store, db.FileSearch{})))
+	})
+
+	step(t, "search files", func(t *testing.T) {
+		files := action.ListFiles(store, db.FileSearch{TagIds: []int{root}})
+		testutil.Equal(t, "files of the hierarchy", []int{a, b}, fileIds(files))
+		if len(files) == 2 {
+			testutil.Equal(t, "tags of the hierarchy", []int{root}, tagIds(files[1].Tags))
+		}
+		testutil.Equal(t, "files of every tag", []int{b}, fileIds(action.ListFiles(store, db.FileSearch{TagIds: []int{root, other}})))
+		testutil.Equal(t, "files of a leaf", []int{a}, fileIds(action.ListFiles(store, db.FileSearch{TagIds: []int{child}})))
+		testutil.Equal(t, "files by name", []int{c}, fileIds(action.ListFiles(store, db.FileSearch{Name: str("zebra")})))
+
+		kind := action.GetFile(store, a).Kind
+		testutil.Equal(t, "files by kind", []int{a, b, c}, fileIds(action.ListFiles(store, db.FileSearch{Kinds: []string{kind}})))
+		testutil.Equal(t, "files by kind token", []int{c}, fileIds(action.ListFiles(store, db.FileSearch{Name: str("zebra kind:" + kind)})))
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.ListFiles(store, db.FileSearch{TagIds: []int{999}}) })
+	})
+
+	step(t, "get files", func(t *testing.T) {
+		files := action.GetFiles(store, []int{c, 999, a})
+		testutil.Equal(t, "files", []int{a, c}, fileIds(files))
+		if len(files) == 2 {
+			testutil.Equal(t, "tags", []int{child}, tagIds(files[0].Tags))
+		}
+		testutil.Equal(t, "no files", []int{}, fileIds(action.GetFiles(store, nil)))
+	})
+
+	step(t, "edit file", func(t *testing.T) {
+		revision := action.GetFile(store, a).Revision
+		testutil.Equal(t, "revision", revision+1, action.EditFile(store, a, &revision, []int{other}))
+		testutil.Equal(t, "tags", []int{other}, tagIds(action.GetFile(store, a).Tags))
+		testutil.ExpectPanic[action.StaleRevisionError](t, func() { action.EditFile(store, a, &revision, []int{child}) })
+		testutil.Equal(t, "tags after stale edit", []int{other}, tagIds(action.GetFile(store, a).Tags))
+		testutil.Equal(t, "files of the hierarchy", []int{b}, fileIds(action.ListFiles(store, db.FileSearch{TagIds: []int{root}})))
+	})
+
+	step(t, "reorder tags", func(t *testing.T) {
+		action.ReorderTags(store, []int{other, root, child})
+		testutil.Equal(t, "tags", []int{other, root, child}, tagIds(action.ListTags(store)))
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.ReorderTags(store, []int{999}) })
+	})
+
+	step(t, "roll back", func(t *testing.T) {
+		tx := store.Begin()
+		action.AddTag(tx, "rolled back", "#000000", false, nil)
+		action.RmFile(tx, c)
+		tx.Rollback()
+
+		testutil.Equal(t, "tags", []int{other, root, child}, tagIds(action.ListTags(store)))
+		testutil.Equal(t, "files", []int{a, b, c}, fileIds(action.ListFiles(store, db.FileSearch{})))
+	})
+
+	step(t, "remove file", func(t *testing.T) {
+		action.RmFile(store, b)
+		testutil.Equal(t, "files", []int{a, c}, fileIds(action.ListFiles(store, db.FileSearch{})))
+		testutil.Equal(t, "trashed", true, store.GetFileState(b).DeletedAt != nil)
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.GetFile(store, b) })
+		testutil.ExpectPanic[action.ConflictError](t, func() { action.AddFile(store, filepath.Join(dir, "b.txt"), nil) })
+	})
+
+	step(t, "remove tag", func(t *testing.T) {
+		action.RmTag(store, other)
+		testutil.Equal(t, "tags", []int{root, child}, tagIds(action.ListTags(store)))
+		testutil.Equal(t, "tags of the file", []int{}, tagIds(action.GetFile(store, a).Tags))
+		testutil.Equal(t, "trashed", true, store.TagInTrash(other))
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.GetTag(store, other) })
+		testutil.ExpectPanic[action.NotFoundError](t, func() { action.EditFile(store, a, nil, []int{other}) })
+	})
+
+	step(t, "edit file with a trashed tag", func(t *testing.T) {
+		// The trashed tag is not shown, but comes back with its files if it is restored
+		action.EditFile(store, a, nil, []int{root})
+		testutil.Equal(t, "tags of the file", []int{root}, tagIds(action.GetFile(store, a).Tags))
+		testutil.Equal(t, "assigned tags", []int{root, other}, sorted(store.GetFileState(a).TagIds))
+	})
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+)
+
+func (db DB) GetTrashedTags() []Tag {
+	visible, params := db.visibleTag("tag")
+	rows, err := db.conn().Query("SELECT id, name, color, owner_id, private, revision, updated_at, deleted_at FROM tag WHERE deleted_at IS NOT NULL AND "+visible+" ORDER BY deleted_at DESC", params...)
+	must(err)
+
+	tags := make([]Tag, 0)
+	for rows.Next() {
+		tag := Tag{ParentIds: make([]int, 0)}
+		err := rows.Scan(&tag.Id, &tag.Name, &tag.Color, &tag.OwnerId, &tag.Private, &tag.Revision, &tag.UpdatedAt, &tag.DeletedAt)
+		must(err)
+
+		tags = append(tags, tag)
+	}
+	must(rows.Err())
+
+	return tags
+}
+
+func (db DB) GetTrashedFiles() []File {
+	visible, params := db.visibleFile("file")
+	rows, err := db.conn().Query("SELECT id, path, name, revision, updated_at, deleted_at, mime_type, kind FROM file WHERE deleted_at IS NOT NULL AND "+visible+" ORDER BY deleted_at DESC", params...)
+	must(err)
+
+	files := make([]File, 0)
+	for rows.Next() {
+		file := File{Tags: make([]Tag, 0), Metadata: make(map[string]string)}
+		err := rows.Scan(&file.Id, &file.Path, &file.Name, &file.Revision, &file.UpdatedAt, &file.DeletedAt, &file.MimeType, &file.Kind)
+		must(err)
+
+		files = append(files, file)
+	}
+	must(rows.Err())
+
+	return files
+}
+
+func (db DB) TagInTrash(id int) bool {
+	visible, params := db.visibleTag("tag")
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM tag WHERE id = ? AND deleted_at IS NOT NULL AND "+visible, append([]any{id}, params...)...)
+	must(row.Err())
+
+	var count int
+	err := row.Scan(&count)
+	must(err)
+	return count == 1
+}
+
+func (db DB) FileInTrash(id int) bool {
+	visible, params := db.visibleFile("file")
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM file WHERE id = ? AND deleted_at IS NOT NULL AND "+visible, append([]any{id}, params...)...)
+	must(row.Err())
+
+	var count int
+	err := row.Scan(&count)
+	must(err)
+	return count == 1
+}
+
+func (db DB) FileInTrashPath(path string) bool {
+	row := db.conn().QueryRow("SELECT COUNT(*) FROM file WHERE path = ? AND deleted_at IS NOT NULL", path)
+	must(row.Err())
+
+	var count int
+	err := row.Scan(&count)
+	must(err)
+	return count != 0
+}
+
+func (db DB) RestoreTag(id int) {
+	_, err := db.conn().Exec("UPDATE tag SET deleted_at = NULL, revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	must(err)
+}
+
+func (db DB) RestoreFile(id int) {
+	_, err := db.conn().Exec("UPDATE file SET deleted_at = NULL, revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	must(err)
+}
+
+// PurgeTag permanently deletes the tag with its assignments and relations
+func (db DB) PurgeTag(id int) {
+	_, err := db.conn().Exec("DELETE FROM tag WHERE id = ?", id)
+	must(err)
+}
+
+// PurgeFile permanently deletes the file with its tags
+func (db DB) PurgeFile(id int) {
+	_, err := db.conn().Exec("DELETE FROM file WHERE id = ?", id)
+	must(err)
+	db.unindexFile(id)
+}
+
+// Settings
+
+// GetSetting returns nil if the setting was never set
+func (db DB) GetSetting(key string) *string {
+	var value string
+	err := db.conn().QueryRow("SELECT value FROM setting WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+	return &value
+}
+
+func (db DB) SetSetting(key string, value string) {
+	_, err := db.conn().Exec("INSERT INTO setting (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value", key, value)
+	must(err)
+}
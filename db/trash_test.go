@@ -0,0 +1,48 @@
+package db_test
+
+import (
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Editing the tags of a file must keep its trashed tags, which are assigned again when restored
+func TestRestoredTagKeepsEditedFiles(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+
+	first := action.AddTag(db_, "first", "#000000", false, nil)
+	second := action.AddTag(db_, "second", "#000000", false, nil)
+	file := action.AddFile(db_, testutil.WriteFile(t, dir, "f.txt", "f.txt"), []int{first, second})
+
+	action.RmTag(db_, first)
+	action.EditFile(db_, file, nil, []int{second})
+	action.RestoreTag(db_, first)
+
+	testutil.Equal(t, "tags", []int{first, second}, sorted(tagIds(action.GetFile(db_, file).Tags)))
+}
+
+// Purging a file deletes its assignments, which only their owners can remove
+func TestPurgeFileChecksAssignments(t *testing.T) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(dir, "db.sqlite3"))
+	alice := db_.WithUser(action.AddUser(db_, "alice"))
+	bob := db_.WithUser(action.AddUser(db_, "bob"))
+
+	tag := action.AddTag(db_, "tag", "#000000", false, nil)
+	tagged := action.AddFile(db_, testutil.WriteFile(t, dir, "tagged.txt", "tagged"), nil)
+	action.EditFile(bob, tagged, nil, []int{tag})
+	untagged := action.AddFile(db_, testutil.WriteFile(t, dir, "untagged.txt", "untagged"), nil)
+	action.RmFile(alice, tagged)
+	action.RmFile(alice, untagged)
+
+	testutil.ExpectPanic[action.PermissionError](t, func() { action.PurgeFile(alice, tagged) })
+	tags, files := action.PurgeTrash(alice, 0)
+	testutil.Equal(t, "purged by alice", [2]int{0, 1}, [2]int{tags, files})
+	testutil.Equal(t, "trashed", true, db_.FileInTrash(tagged))
+
+	action.PurgeFile(bob, tagged)
+	testutil.Equal(t, "trashed", false, db_.FileInTrash(tagged))
+}
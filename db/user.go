@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type User struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// User returns the user whose tags and assignments are visible through this DB, nil when everything is visible
+func (db DB) User() *int {
+	return db.user
+}
+
+// WithUser returns a DB that only sees shared tags and the private tags of user, and attributes new tags and assignments to them
+func (db DB) WithUser(user int) DB {
+	db.user = &user
+	return db
+}
+
+// visibleTag returns the condition a tag aliased t must match to be visible to the DB's user, with its parameters
+func (db DB) visibleTag(t string) (string, []any) {
+	if db.user == nil {
+		return "TRUE", nil
+	}
+	return "(NOT " + t + ".private OR " + t + ".owner_id = ?)", []any{*db.user}
+}
+
+// visibleFile returns the condition a file aliased f must match to be visible to the DB's user, with its parameters.
+// A file is hidden when every tag it has is private to someone else.
+func (db DB) visibleFile(f string) (string, []any) {
+	if db.user == nil {
+		return "TRUE", nil
+	}
+	return `(NOT EXISTS (SELECT 1 FROM file_tag vft JOIN tag vt ON vt.id = vft.tag_id WHERE vft.file_id = ` + f + `.id AND vt.deleted_at IS NULL)
+		OR EXISTS (SELECT 1 FROM file_tag vft JOIN tag vt ON vt.id = vft.tag_id WHERE vft.file_id = ` + f + `.id AND vt.deleted_at IS NULL AND (NOT vt.private OR vt.owner_id = ?)))`, []any{*db.user}
+}
+
+func (db DB) InsertUser(name string) int {
+	var id int
+	err := db.conn().QueryRow(`INSERT INTO "user" (name, created_at) VALUES (?, CURRENT_TIMESTAMP) RETURNING id`, name).Scan(&id)
+	must(err)
+	return id
+}
+
+func (db DB) GetAllUsers() []User {
+	rows, err := db.conn().Query(`SELECT id, name, created_at FROM "user" ORDER BY id`)
+	must(err)
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.Id, &user.Name, &user.CreatedAt)
+		must(err)
+
+		users = append(users, user)
+	}
+	must(rows.Err())
+
+	return users
+}
+
+// UserIdFromName returns nil if no user has this name
+func (db DB) UserIdFromName(name string) *int {
+	var id int
+	err := db.conn().QueryRow(`SELECT id FROM "user" WHERE name = ?`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+	return &id
+}
+
+func (db DB) UserExists(id int) bool {
+	row := db.conn().QueryRow(`SELECT COUNT(*) FROM "user" WHERE id = ?`, id)
+	must(row.Err())
+
+	var count int
+	err := row.Scan(&count)
+	must(err)
+	return count == 1
+}
+
+// TagOwner returns nil if the tag has no owner
+func (db DB) TagOwner(id int) *int {
+	var owner *int
+	err := db.conn().QueryRow("SELECT owner_id FROM tag WHERE id = ?", id).Scan(&owner)
+	must(err)
+	return owner
+}
+
+// FileTagOwner returns the user who assigned the tag to the file, nil if it has no owner
+func (db DB) FileTagOwner(fileId int, tagId int) *int {
+	var owner *int
+	err := db.conn().QueryRow("SELECT owner_id FROM file_tag WHERE file_id = ? AND tag_id = ?", fileId, tagId).Scan(&owner)
+	must(err)
+	return owner
+}
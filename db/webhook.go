@@ -0,0 +1,182 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type Webhook struct {
+	Id     int      `json:"id"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	// Secret is only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	Id            int             `json:"id"`
+	WebhookId     int             `json:"webhookId"`
+	Event         string          `json:"event"`
+	AuditId       int             `json:"auditId"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	StatusCode    *int            `json:"statusCode"`
+	Error         *string         `json:"error"`
+	NextAttemptAt *time.Time      `json:"nextAttemptAt"`
+	DeliveredAt   *time.Time      `json:"deliveredAt"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+func (db DB) InsertWebhook(url string, secret string, events []string) int {
+	eventsJson, err := json.Marshal(events)
+	must(err)
+
+	var id int
+	err = db.conn().QueryRow("INSERT INTO webhook (url, secret, events, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP) RETURNING id", url, secret, string(eventsJson)).Scan(&id)
+	must(err)
+	return id
+}
+
+func (db DB) queryWebhooks(query string, args ...any) []Webhook {
+	rows, err := db.conn().Query(query, args...)
+	must(err)
+
+	webhooks := make([]Webhook, 0)
+	for rows.Next() {
+		var webhook Webhook
+		var events string
+		err := rows.Scan(&webhook.Id, &webhook.Url, &webhook.Secret, &events, &webhook.CreatedAt)
+		must(err)
+
+		err = json.Unmarshal([]byte(events), &webhook.Events)
+		must(err)
+		webhooks = append(webhooks, webhook)
+	}
+	must(rows.Err())
+
+	return webhooks
+}
+
+// GetAllWebhooks returns the webhooks with their secret
+func (db DB) GetAllWebhooks() []Webhook {
+	return db.queryWebhooks("SELECT id, url, secret, events, created_at FROM webhook ORDER BY id")
+}
+
+// GetWebhook returns nil if the webhook does not exist
+func (db DB) GetWebhook(id int) *Webhook {
+	webhooks := db.queryWebhooks("SELECT id, url, secret, events, created_at FROM webhook WHERE id = ?", id)
+	if len(webhooks) == 0 {
+		return nil
+	}
+	return &webhooks[0]
+}
+
+func (db DB) DeleteWebhook(id int) {
+	_, err := db.conn().Exec("DELETE FROM webhook WHERE id = ?", id)
+	must(err)
+}
+
+func (db DB) InsertWebhookDelivery(webhookId int, event string, auditId int, payload []byte) {
+	_, err := db.conn().Exec(`INSERT INTO webhook_delivery (webhook_id, event, audit_id, payload, next_attempt_at, created_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`, webhookId, event, auditId, string(payload))
+	must(err)
+}
+
+func (db DB) queryWebhookDeliveries(query string, args ...any) []WebhookDelivery {
+	rows, err := db.conn().Query(query, args...)
+	must(err)
+
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload string
+		err := rows.Scan(&delivery.Id, &delivery.WebhookId, &delivery.Event, &delivery.AuditId, &payload, &delivery.Attempts,
+			&delivery.StatusCode, &delivery.Error, &delivery.NextAttemptAt, &delivery.DeliveredAt, &delivery.CreatedAt)
+		must(err)
+
+		delivery.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, delivery)
+	}
+	must(rows.Err())
+
+	return deliveries
+}
+
+const webhookDeliveryColumns = "id, webhook_id, event, audit_id, payload, attempts, status_code, error, next_attempt_at, delivered_at, created_at"
+
+// GetWebhookDeliveries returns the most recent deliveries of a webhook first
+func (db DB) GetWebhookDeliveries(webhookId int, limit int) []WebhookDelivery {
+	return db.queryWebhookDeliveries("SELECT "+webhookDeliveryColumns+" FROM webhook_delivery WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", webhookId, limit)
+}
+
+// GetDueWebhookDeliveries returns the deliveries whose next attempt is due, oldest first
+func (db DB) GetDueWebhookDeliveries(now time.Time, limit int) []WebhookDelivery {
+	return db.queryWebhookDeliveries("SELECT "+webhookDeliveryColumns+" FROM webhook_delivery WHERE next_attempt_at <= ? ORDER BY id LIMIT ?",
+		db.timeParam(now), limit)
+}
+
+// ClaimWebhookDelivery counts a new attempt of the delivery and returns false if another process already claimed it
+func (db DB) ClaimWebhookDelivery(id int, attempts int) bool {
+	res, err := db.conn().Exec("UPDATE webhook_delivery SET attempts = attempts + 1 WHERE id = ? AND attempts = ?", id, attempts)
+	must(err)
+
+	count, err := res.RowsAffected()
+	must(err)
+	return count == 1
+}
+
+// FinishWebhookAttempt records the outcome of an attempt. nextAttemptAt is nil once delivered or given up.
+func (db DB) FinishWebhookAttempt(id int, statusCode *int /* nilable */, errorMessage *string /* nilable */, delivered bool, nextAttemptAt *time.Time /* nilable */) {
+	var next any
+	if nextAttemptAt != nil {
+		next = db.timeParam(*nextAttemptAt)
+	}
+	var deliveredAt any
+	if delivered {
+		deliveredAt = db.timeParam(time.Now())
+	}
+
+	_, err := db.conn().Exec("UPDATE webhook_delivery SET status_code = ?, error = ?, next_attempt_at = ?, delivered_at = ? WHERE id = ?",
+		statusCode, errorMessage, next, deliveredAt, id)
+	must(err)
+}
+
+// RetryWebhookDelivery schedules a delivery to be attempted again now
+func (db DB) RetryWebhookDelivery(id int) bool {
+	res, err := db.conn().Exec("UPDATE webhook_delivery SET next_attempt_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	must(err)
+
+	count, err := res.RowsAffected()
+	must(err)
+	return count == 1
+}
+
+// WebhookDeliveryWebhookId returns nil if the delivery does not exist
+func (db DB) WebhookDeliveryWebhookId(id int) *int {
+	var webhookId int
+	err := db.conn().QueryRow("SELECT webhook_id FROM webhook_delivery WHERE id = ?", id).Scan(&webhookId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	must(err)
+	return &webhookId
+}
+
+// AdvanceSetting sets key to value if it is still old, nil meaning unset, and returns false otherwise
+func (db DB) AdvanceSetting(key string, old *string /* nilable */, value string) bool {
+	var res sql.Result
+	var err error
+	if old == nil {
+		res, err = db.conn().Exec("INSERT INTO setting (key, value) VALUES (?, ?) ON CONFLICT (key) DO NOTHING", key, value)
+	} else {
+		res, err = db.conn().Exec("UPDATE setting SET value = ? WHERE key = ? AND value = ?", value, key, *old)
+	}
+	must(err)
+
+	count, err := res.RowsAffected()
+	must(err)
+	return count == 1
+}
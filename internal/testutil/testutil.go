@@ -0,0 +1,40 @@
+// Package testutil holds the helpers shared by the tests of several packages.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Equal fails the test unless actual deeply equals expected, what telling what was compared
+func Equal(t *testing.T, what string, expected any, actual any) {
+	t.Helper()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("%v: expected %v, got %v", what, expected, actual)
+	}
+}
+
+// WriteFile writes content to a file named name in dir and returns its path
+func WriteFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ExpectPanic fails the test unless f panics an E
+func ExpectPanic[E any](t *testing.T, f func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if _, ok := r.(E); !ok {
+			t.Errorf("expected a %T to be panicked, got %v", *new(E), r)
+		}
+	}()
+	f()
+}
@@ -0,0 +1,218 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EXIF tags read from the first IFD, the EXIF IFD and the GPS IFD
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIfd          = 0x8769
+	tagGpsIfd           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagGpsLatitudeRef   = 0x0001
+	tagGpsLatitude      = 0x0002
+	tagGpsLongitudeRef  = 0x0003
+	tagGpsLongitude     = 0x0004
+)
+
+// EXIF value types
+const (
+	typeAscii    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+)
+
+var typeSizes = map[uint16]int{1: 1, typeAscii: 1, typeShort: 2, typeLong: 4, typeRational: 8, 7: 1, 9: 4, 10: 8}
+
+// exifDateLayout is how EXIF writes dates, in the camera's time zone which it does not record
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// tiff is the TIFF structure EXIF data is stored in
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count int
+	// value holds the bytes of the value, stored in the entry when they fit in 4 bytes and elsewhere otherwise
+	value []byte
+}
+
+// jpegExif returns the EXIF data of a JPEG, nil if it has none
+func jpegExif(data []byte) *tiff {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	// Walk the segments before the image data, looking for the APP1 segment holding EXIF
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return parseTiff(segment[6:])
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+func parseTiff(data []byte) *tiff {
+	if len(data) < 8 {
+		return nil
+	}
+	switch string(data[:2]) {
+	case "II":
+		return &tiff{data, binary.LittleEndian}
+	case "MM":
+		return &tiff{data, binary.BigEndian}
+	}
+	return nil
+}
+
+// ifd reads the entries of the IFD at offset, ignoring the ones pointing outside of the data
+func (t *tiff) ifd(offset int) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if offset <= 0 || offset+2 > len(t.data) {
+		return entries
+	}
+
+	count := int(t.order.Uint16(t.data[offset:]))
+	for e := 0; e < count; e++ {
+		start := offset + 2 + 12*e
+		if start+12 > len(t.data) {
+			break
+		}
+		entry := ifdEntry{typ: t.order.Uint16(t.data[start+2:]), count: int(t.order.Uint32(t.data[start+4:]))}
+
+		size := typeSizes[entry.typ] * entry.count
+		if size <= 0 || size > len(t.data) {
+			continue
+		}
+		if size <= 4 {
+			entry.value = t.data[start+8 : start+8+size]
+		} else {
+			at := int(t.order.Uint32(t.data[start+8:]))
+			if at < 0 || at+size > len(t.data) {
+				continue
+			}
+			entry.value = t.data[at : at+size]
+		}
+		entries[t.order.Uint16(t.data[start:])] = entry
+	}
+	return entries
+}
+
+func (t *tiff) firstIfd() map[uint16]ifdEntry {
+	return t.ifd(int(t.order.Uint32(t.data[4:])))
+}
+
+// ascii returns the text of an entry, or "" if it is missing, the zero entry having no type
+func (t *tiff) ascii(entry ifdEntry) string {
+	if entry.typ != typeAscii {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+func (t *tiff) uint(entry ifdEntry) (int, bool) {
+	switch {
+	case entry.count < 1:
+		return 0, false
+	case entry.typ == typeShort:
+		return int(t.order.Uint16(entry.value)), true
+	case entry.typ == typeLong:
+		return int(t.order.Uint32(entry.value)), true
+	}
+	return 0, false
+}
+
+// degrees reads a GPS coordinate, stored as degrees, minutes and seconds rationals
+func (t *tiff) degrees(entry ifdEntry) (float64, bool) {
+	if entry.typ != typeRational || entry.count < 3 {
+		return 0, false
+	}
+
+	value := 0.0
+	for i, unit := range []float64{1, 60, 3600} {
+		num := t.order.Uint32(entry.value[8*i:])
+		den := t.order.Uint32(entry.value[8*i+4:])
+		if den == 0 {
+			return 0, false
+		}
+		value += float64(num) / float64(den) / unit
+	}
+	return value, true
+}
+
+// Orientation returns the EXIF orientation of a JPEG, from 1 (upright) to 8, or 1 if it has none.
+// Cameras store photos as shot and record how to turn them in this tag.
+func Orientation(data []byte) int {
+	t := jpegExif(data)
+	if t == nil {
+		return 1
+	}
+
+	orientation, ok := t.uint(t.firstIfd()[tagOrientation])
+	if !ok || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// readExif adds the camera, the date a photo was taken and where to values
+func readExif(data []byte, values map[string]string) {
+	t := jpegExif(data)
+	if t == nil {
+		return
+	}
+
+	first := t.firstIfd()
+	if make_ := t.ascii(first[tagMake]); make_ != "" {
+		values[KeyCameraMake] = make_
+	}
+	if model := t.ascii(first[tagModel]); model != "" {
+		values[KeyCameraModel] = model
+	}
+
+	taken := t.ascii(first[tagDateTime])
+	if offset, ok := t.uint(first[tagExifIfd]); ok {
+		if original := t.ascii(t.ifd(offset)[tagDateTimeOriginal]); original != "" {
+			taken = original
+		}
+	}
+	if date, err := time.Parse(exifDateLayout, taken); err == nil {
+		values[KeyTakenAt] = date.Format("2006-01-02T15:04:05")
+	}
+
+	if offset, ok := t.uint(first[tagGpsIfd]); ok {
+		gps := t.ifd(offset)
+		lat, latOk := t.degrees(gps[tagGpsLatitude])
+		lon, lonOk := t.degrees(gps[tagGpsLongitude])
+		if latOk && lonOk {
+			if t.ascii(gps[tagGpsLatitudeRef]) == "S" {
+				lat = -lat
+			}
+			if t.ascii(gps[tagGpsLongitudeRef]) == "W" {
+				lon = -lon
+			}
+			values[KeyLatitude] = fmt.Sprintf("%.6f", lat)
+			values[KeyLongitude] = fmt.Sprintf("%.6f", lon)
+		}
+	}
+}
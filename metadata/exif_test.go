@@ -0,0 +1,239 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffBuilder writes TIFF data, IFDs being appended so the ones pointed to are written first
+type tiffBuilder struct {
+	order byteOrder
+	data  []byte
+}
+
+func newTiff(order byteOrder) *tiffBuilder {
+	b := &tiffBuilder{order: order, data: []byte("II\x2A\x00\x08\x00\x00\x00")}
+	if order == byteOrder(binary.BigEndian) {
+		b.data = []byte("MM\x00\x2A\x00\x00\x00\x08")
+	}
+	return b
+}
+
+// ifd appends an IFD with its values and returns its offset
+func (b *tiffBuilder) ifd(entries []exifEntry) int {
+	offset := len(b.data)
+	extra := offset + 2 + 12*len(entries) + 4
+	values := make([]byte, 0)
+
+	b.data = b.order.AppendUint16(b.data, uint16(len(entries)))
+	for _, e := range entries {
+		b.data = b.order.AppendUint16(b.data, e.tag)
+		b.data = b.order.AppendUint16(b.data, e.typ)
+		b.data = b.order.AppendUint32(b.data, e.count)
+		if len(e.value) <= 4 {
+			b.data = append(b.data, e.value...)
+			b.data = append(b.data, make([]byte, 4-len(e.value))...)
+		} else {
+			b.data = b.order.AppendUint32(b.data, uint32(extra+len(values)))
+			values = append(values, e.value...)
+		}
+	}
+	b.data = append(b.data, 0, 0, 0, 0)
+	b.data = append(b.data, values...)
+	return offset
+}
+
+func (b *tiffBuilder) first(offset int) []byte {
+	b.order.PutUint32(b.data[4:], uint32(offset))
+	return b.data
+}
+
+func (b *tiffBuilder) short(tag uint16, value uint16) exifEntry {
+	return exifEntry{tag, typeShort, 1, b.order.AppendUint16(nil, value)}
+}
+
+func (b *tiffBuilder) long(tag uint16, value int) exifEntry {
+	return exifEntry{tag, typeLong, 1, b.order.AppendUint32(nil, uint32(value))}
+}
+
+func ascii(tag uint16, value string) exifEntry {
+	return exifEntry{tag, typeAscii, uint32(len(value) + 1), append([]byte(value), 0)}
+}
+
+func (b *tiffBuilder) rationals(tag uint16, values ...uint32) exifEntry {
+	data := make([]byte, 0)
+	for _, v := range values {
+		data = b.order.AppendUint32(data, v)
+	}
+	return exifEntry{tag, typeRational, uint32(len(values) / 2), data}
+}
+
+// photoExif is the EXIF of a photo taken sideways in the south west, with every value read
+func photoExif(order byteOrder) []byte {
+	b := newTiff(order)
+	exif := b.ifd([]exifEntry{ascii(tagDateTimeOriginal, "2024:05:06 07:08:09")})
+	gps := b.ifd([]exifEntry{
+		ascii(tagGpsLatitudeRef, "S"),
+		b.rationals(tagGpsLatitude, 12, 1, 30, 1, 0, 1),
+		ascii(tagGpsLongitudeRef, "W"),
+		b.rationals(tagGpsLongitude, 45, 1, 15, 1, 36, 1),
+	})
+	return b.first(b.ifd([]exifEntry{
+		ascii(tagMake, "Canon"),
+		ascii(tagModel, "EOS"),
+		b.short(tagOrientation, 6),
+		ascii(tagDateTime, "2024:12:31 23:59:59"),
+		b.long(tagExifIfd, exif),
+		b.long(tagGpsIfd, gps),
+	}))
+}
+
+// exifJpeg returns the start of a JPEG holding tiff in its APP1 segment
+func exifJpeg(tiff []byte) []byte {
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	data = binary.BigEndian.AppendUint16(data, uint16(2+6+len(tiff)))
+	data = append(data, "Exif\x00\x00"...)
+	data = append(data, tiff...)
+	return append(data, 0xFF, 0xD9)
+}
+
+var photoValues = map[string]string{
+	KeyCameraMake:  "Canon",
+	KeyCameraModel: "EOS",
+	KeyTakenAt:     "2024-05-06T07:08:09",
+	KeyLatitude:    "-12.500000",
+	KeyLongitude:   "-45.260000",
+}
+
+func TestReadExif(t *testing.T) {
+	b := newTiff(binary.LittleEndian)
+	hugeCount := b.first(b.ifd([]exifEntry{
+		{tagMake, typeAscii, 0xFFFFFFFF, []byte("Cano")},
+		b.long(tagExifIfd, 1<<30),
+	}))
+
+	b = newTiff(binary.LittleEndian)
+	outside := b.first(b.ifd([]exifEntry{
+		{tagModel, typeAscii, 16, nil},
+		b.long(tagGpsIfd, 0xFFFFFFF0),
+	}))
+	// The value of the model points past the end of the data
+	binary.LittleEndian.PutUint32(outside[8+2+8:], 0xFFFFFFF0)
+
+	b = newTiff(binary.BigEndian)
+	gps := b.ifd([]exifEntry{
+		b.rationals(tagGpsLatitude, 12, 0, 30, 1, 0, 1),
+		b.rationals(tagGpsLongitude, 45, 1),
+	})
+	badGps := b.first(b.ifd([]exifEntry{
+		b.long(tagGpsIfd, gps),
+		ascii(tagDateTime, "yesterday"),
+		{tagMake, typeShort, 1, []byte{1, 2}},
+	}))
+
+	manyEntries := newTiff(binary.LittleEndian).first(8)
+	manyEntries = append(manyEntries, 0xFF, 0xFF)
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		expected map[string]string
+	}{
+		{"little endian", exifJpeg(photoExif(binary.LittleEndian)), photoValues},
+		{"big endian", exifJpeg(photoExif(binary.BigEndian)), photoValues},
+		{"empty", nil, map[string]string{}},
+		{"not a JPEG", photoExif(binary.LittleEndian), map[string]string{}},
+		{"no EXIF", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9}, map[string]string{}},
+		{"segment longer than the data", exifJpeg(photoExif(binary.LittleEndian))[:40], map[string]string{}},
+		{"unknown byte order", exifJpeg(append([]byte("XX"), photoExif(binary.LittleEndian)[2:]...)), map[string]string{}},
+		{"TIFF header cut", exifJpeg([]byte("II\x2A\x00")), map[string]string{}},
+		{"first IFD outside", exifJpeg(newTiff(binary.LittleEndian).first(1 << 30)), map[string]string{}},
+		{"more entries than the data holds", exifJpeg(manyEntries), map[string]string{}},
+		{"huge count and offset", exifJpeg(hugeCount), map[string]string{}},
+		{"values outside", exifJpeg(outside), map[string]string{}},
+		{"bad GPS, date and type", exifJpeg(badGps), map[string]string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			values := make(map[string]string)
+			readExif(test.data, values)
+			testutil.Equal(t, "values", test.expected, values)
+		})
+	}
+}
+
+// Every truncation and corruption of the EXIF is read without reaching past the data, which would panic
+func TestReadExifMalformed(t *testing.T) {
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		photo := exifJpeg(photoExif(order))
+		for n := range photo {
+			readExif(photo[:n], make(map[string]string))
+			Orientation(photo[:n])
+		}
+		for i := range photo {
+			for _, b := range []byte{0x00, 0x80, 0xFF} {
+				corrupt := append([]byte{}, photo...)
+				corrupt[i] = b
+				readExif(corrupt, make(map[string]string))
+				Orientation(corrupt)
+			}
+		}
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	b := newTiff(binary.LittleEndian)
+	outOfRange := b.first(b.ifd([]exifEntry{b.short(tagOrientation, 9)}))
+	b = newTiff(binary.BigEndian)
+	long := b.first(b.ifd([]exifEntry{b.long(tagOrientation, 8)}))
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		expected int
+	}{
+		{"sideways", exifJpeg(photoExif(binary.LittleEndian)), 6},
+		{"stored as a long", exifJpeg(long), 8},
+		{"out of range", exifJpeg(outOfRange), 1},
+		{"no EXIF", []byte{0xFF, 0xD8}, 1},
+		{"not a JPEG", []byte("GIF89a"), 1},
+	} {
+		testutil.Equal(t, test.name, test.expected, Orientation(test.data))
+	}
+}
+
+// Dimensions are those of the photo upright
+func TestExtractImage(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The APP1 segment goes right after the start of image marker
+	app1 := exifJpeg(photoExif(binary.LittleEndian))
+	data := append(append(encoded.Bytes()[:2:2], app1[2:len(app1)-2]...), encoded.Bytes()[2:]...)
+
+	values, err := Extract(testutil.WriteFile(t, t.TempDir(), "photo", string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{KeyWidth: "2", KeyHeight: "4"}
+	for key, value := range photoValues {
+		expected[key] = value
+	}
+	testutil.Equal(t, "values", expected, values)
+}
@@ -0,0 +1,172 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Frames maps the ID3v2 text frames read to metadata keys, TYER being the year of v2.3 and TDRC the date of v2.4
+var id3Frames = map[string]string{
+	"TIT2": KeyTitle,
+	"TPE1": KeyArtist,
+	"TALB": KeyAlbum,
+	"TYER": KeyYear,
+	"TDRC": KeyYear,
+	"TCON": KeyGenre,
+}
+
+// readId3 adds the title, artist, album, year and genre of an audio file to values, from its ID3v2 tag or else its ID3v1 tag
+func readId3(file *os.File, values map[string]string) error {
+	found, err := readId3v2(file, values)
+	if err != nil || found {
+		return err
+	}
+	return readId3v1(file, values)
+}
+
+// synchsafe decodes the 28 bit sizes of ID3v2, whose bytes keep their high bit clear
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func readId3v2(file *os.File, values map[string]string) (bool, error) {
+	header := make([]byte, 10)
+	_, err := file.ReadAt(header, 0)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	version := header[3]
+	// v2.2 has 3 letter frames and is rare, its files usually also have an ID3v1 tag
+	if string(header[:3]) != "ID3" || (version != 3 && version != 4) {
+		return false, nil
+	}
+
+	// The size is not trusted further than the file goes
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := int64(synchsafe(header[6:]))
+	if rest := info.Size() - 10; size > rest {
+		size = rest
+	}
+
+	tag := make([]byte, size)
+	n, err := file.ReadAt(tag, 10)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	tag = tag[:n]
+
+	pos := 0
+	if header[5]&0x40 != 0 && len(tag) >= 4 {
+		// Extended header, its size includes itself in v2.4 but not in v2.3
+		if version == 4 {
+			pos = synchsafe(tag)
+		} else {
+			pos = int(binary.BigEndian.Uint32(tag)) + 4
+		}
+	}
+
+	for pos+10 <= len(tag) && tag[pos] != 0 {
+		id := string(tag[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(tag[pos+4:]))
+		if version == 4 {
+			size = synchsafe(tag[pos+4:])
+		}
+		pos += 10
+		if size < 0 || pos+size > len(tag) {
+			break
+		}
+
+		if key, ok := id3Frames[id]; ok && size > 1 {
+			text := id3Text(tag[pos : pos+size])
+			if key == KeyYear && len(text) > 4 {
+				text = text[:4]
+			}
+			if text != "" {
+				values[key] = text
+			}
+		}
+		pos += size
+	}
+	return true, nil
+}
+
+// id3Text decodes a text frame, whose first byte is the encoding
+func id3Text(frame []byte) string {
+	var text string
+	data := frame[1:]
+	switch frame[0] {
+	case 1, 2: // UTF-16 with a byte order mark, UTF-16BE
+		bigEndian := frame[0] == 2
+		if len(data) >= 2 && (data[0] == 0xFE && data[1] == 0xFF || data[0] == 0xFF && data[1] == 0xFE) {
+			bigEndian = data[0] == 0xFE
+			data = data[2:]
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(data[2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(data[2*i:])
+			}
+		}
+		text = string(utf16.Decode(units))
+	case 3: // UTF-8
+		text = string(data)
+	default: // ISO-8859-1
+		text = latin1(data)
+	}
+
+	// Frames can hold several values separated by NUL, only the first is kept
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// readId3v1 reads the fixed size tag at the end of older MP3 files
+func readId3v1(file *os.File, values map[string]string) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() < 128 {
+		return err
+	}
+
+	tag := make([]byte, 128)
+	_, err = file.ReadAt(tag, info.Size()-128)
+	if err != nil {
+		return err
+	}
+	if string(tag[:3]) != "TAG" {
+		return nil
+	}
+
+	field := func(from int, to int) string {
+		text, _, _ := strings.Cut(latin1(tag[from:to]), "\x00")
+		return strings.TrimSpace(text)
+	}
+	for key, value := range map[string]string{
+		KeyTitle:  field(3, 33),
+		KeyArtist: field(33, 63),
+		KeyAlbum:  field(63, 93),
+		KeyYear:   field(93, 97),
+	} {
+		if value != "" {
+			values[key] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"os"
+	"runtime"
+	"tagged-fs/internal/testutil"
+	"testing"
+	"unicode/utf16"
+)
+
+// id3Frame returns a text frame, its size synchsafe in v2.4
+func id3Frame(version byte, id string, encoding byte, text []byte) []byte {
+	size := uint32(1 + len(text))
+	if version == 4 {
+		size = size&0x7F | size<<1&0x7F00 | size<<2&0x7F0000 | size<<3&0x7F000000
+	}
+	frame := binary.BigEndian.AppendUint32([]byte(id), size)
+	frame = append(frame, 0, 0, encoding)
+	return append(frame, text...)
+}
+
+// id3Tag returns an ID3v2 tag holding frames, followed by padding
+func id3Tag(version byte, flags byte, frames ...[]byte) []byte {
+	body := make([]byte, 0)
+	for _, frame := range frames {
+		body = append(body, frame...)
+	}
+	body = append(body, make([]byte, 16)...)
+	size := len(body)
+	tag := []byte{'I', 'D', '3', version, 0, flags, byte(size >> 21 & 0x7F), byte(size >> 14 & 0x7F), byte(size >> 7 & 0x7F), byte(size & 0x7F)}
+	return append(tag, body...)
+}
+
+// id3v1Tag returns the 128 bytes ending older MP3 files
+func id3v1Tag(title string, artist string, album string, year string) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	return tag
+}
+
+func utf16Text(bigEndian bool, bom bool, text string) []byte {
+	data := make([]byte, 0)
+	if bom && bigEndian {
+		data = append(data, 0xFE, 0xFF)
+	} else if bom {
+		data = append(data, 0xFF, 0xFE)
+	}
+	for _, unit := range utf16.Encode([]rune(text)) {
+		if bigEndian {
+			data = binary.BigEndian.AppendUint16(data, unit)
+		} else {
+			data = binary.LittleEndian.AppendUint16(data, unit)
+		}
+	}
+	return data
+}
+
+func readId3Data(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	file, err := os.Open(testutil.WriteFile(t, t.TempDir(), "audio.mp3", string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	if err := readId3(file, values); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestReadId3(t *testing.T) {
+	v23 := id3Tag(3, 0,
+		id3Frame(3, "TIT2", 0, []byte("Caf\xe9")),
+		id3Frame(3, "TPE1", 1, utf16Text(false, true, "Ünïcode")),
+		id3Frame(3, "TALB", 2, utf16Text(true, false, "Album")),
+		id3Frame(3, "TYER", 0, []byte("1999")),
+		id3Frame(3, "TCON", 3, []byte("Jazz\x00Blues")),
+	)
+	v24 := id3Tag(4, 0,
+		id3Frame(4, "TIT2", 3, make([]byte, 200)),
+		id3Frame(4, "TIT2", 3, []byte(" Title ")),
+		id3Frame(4, "TDRC", 3, []byte("2021-03-04")),
+		id3Frame(4, "COMM", 3, []byte("not read")),
+	)
+	// The v2.4 extended header size includes itself, the v2.3 one does not
+	extended24 := id3Tag(4, 0x40, []byte{0, 0, 0, 6, 1, 0}, id3Frame(4, "TIT2", 3, []byte("Extended")))
+	extended23 := id3Tag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, id3Frame(3, "TIT2", 3, []byte("Extended")))
+
+	// A frame longer than the tag ends it, the ones before being kept
+	overlong := id3Tag(3, 0, id3Frame(3, "TPE1", 3, []byte("Artist")), id3Frame(3, "TIT2", 3, []byte("Title")))
+	binary.BigEndian.PutUint32(overlong[10+len(id3Frame(3, "TPE1", 3, []byte("Artist")))+4:], 0xFFFFFFFF)
+
+	// The tag claims more than the file holds
+	cut := id3Tag(4, 0, id3Frame(4, "TIT2", 3, []byte("Title")))
+	cut[6], cut[7], cut[8], cut[9] = 0x7F, 0x7F, 0x7F, 0x7F
+
+	v1 := id3v1Tag("Old", "Someone", "", "1985")
+
+	for _, test := range []struct {
+		name     string
+		data     []byte
+		expected map[string]string
+	}{
+		{"v2.3", v23, map[string]string{KeyTitle: "Café", KeyArtist: "Ünïcode", KeyAlbum: "Album", KeyYear: "1999", KeyGenre: "Jazz"}},
+		{"v2.4", v24, map[string]string{KeyTitle: "Title", KeyYear: "2021"}},
+		{"v2.4 extended header", extended24, map[string]string{KeyTitle: "Extended"}},
+		{"v2.3 extended header", extended23, map[string]string{KeyTitle: "Extended"}},
+		{"v2.3 before v1", append(v23, v1...), map[string]string{KeyTitle: "Café", KeyArtist: "Ünïcode", KeyAlbum: "Album", KeyYear: "1999", KeyGenre: "Jazz"}},
+		{"v1", append(make([]byte, 300), v1...), map[string]string{KeyTitle: "Old", KeyArtist: "Someone", KeyYear: "1985"}},
+		{"v2.2 falls back on v1", append(id3Tag(2, 0, id3Frame(3, "TIT2", 3, []byte("Ignored"))), v1...), map[string]string{KeyTitle: "Old", KeyArtist: "Someone", KeyYear: "1985"}},
+		{"empty", nil, map[string]string{}},
+		{"shorter than a header", []byte("ID3\x03"), map[string]string{}},
+		{"header only", id3Tag(3, 0)[:10], map[string]string{}},
+		{"overlong frame", overlong, map[string]string{KeyArtist: "Artist"}},
+		{"size past the end", cut, map[string]string{KeyTitle: "Title"}},
+		{"empty frames", id3Tag(3, 0, id3Frame(3, "TIT2", 1, []byte{0xFF}), id3Frame(3, "TPE1", 0, []byte("   "))), map[string]string{}},
+		{"extended header past the end", id3Tag(3, 0x40, []byte{0xFF, 0xFF, 0xFF, 0xFF}), map[string]string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.Equal(t, "values", test.expected, readId3Data(t, test.data))
+		})
+	}
+}
+
+// Every truncation and corruption of a tag is read without reaching past the tag, which would panic
+func TestReadId3Malformed(t *testing.T) {
+	for _, version := range []byte{3, 4} {
+		tag := id3Tag(version, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0},
+			id3Frame(version, "TIT2", 1, utf16Text(false, true, "Title")),
+			id3Frame(version, "TPE1", 2, utf16Text(true, false, "Artist")),
+		)
+		for n := range tag {
+			readId3Data(t, tag[:n])
+		}
+		for i := range tag {
+			for _, b := range []byte{0x00, 0x7F, 0xFF} {
+				corrupt := append([]byte{}, tag...)
+				corrupt[i] = b
+				readId3Data(t, corrupt)
+			}
+		}
+	}
+}
+
+// A tag claiming 256MB is only read as far as the file goes
+func TestReadId3BoundsTheTagSize(t *testing.T) {
+	tag := id3Tag(4, 0, id3Frame(4, "TIT2", 3, []byte("Title")))
+	tag[6], tag[7], tag[8], tag[9] = 0xFF, 0xFF, 0xFF, 0xFF
+	file, err := os.Open(testutil.WriteFile(t, t.TempDir(), "audio.mp3", string(tag)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	values := make(map[string]string)
+	if _, err := readId3v2(file, values); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	testutil.Equal(t, "values", map[string]string{KeyTitle: "Title"}, values)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("expected the read to be bounded by the file, allocated %v bytes", allocated)
+	}
+}
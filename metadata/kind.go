@@ -0,0 +1,182 @@
+package metadata
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds group MIME types by what people look for
+const (
+	KindImage    = "image"
+	KindVideo    = "video"
+	KindAudio    = "audio"
+	KindDocument = "document"
+	KindArchive  = "archive"
+	KindOther    = "other"
+)
+
+// Kinds lists every kind, in the order they are shown
+var Kinds = []string{KindImage, KindVideo, KindAudio, KindDocument, KindArchive, KindOther}
+
+// extensionTypes are the types of common extensions, which the standard library only knows when the system has a mime.types file
+var extensionTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".svg":  "image/svg+xml",
+	".heic": "image/heic",
+	".avif": "image/avif",
+	".ico":  "image/x-icon",
+
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+	".avi":  "video/x-msvideo",
+	".wmv":  "video/x-ms-wmv",
+	".flv":  "video/x-flv",
+	".mpg":  "video/mpeg",
+	".mpeg": "video/mpeg",
+
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".opus": "audio/opus",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".wma":  "audio/x-ms-wma",
+
+	".pdf":  "application/pdf",
+	".txt":  "text/plain",
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".html": "text/html",
+	".htm":  "text/html",
+	".rtf":  "application/rtf",
+	".epub": "application/epub+zip",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+
+	".zip": "application/zip",
+	".tar": "application/x-tar",
+	".gz":  "application/gzip",
+	".tgz": "application/gzip",
+	".bz2": "application/x-bzip2",
+	".xz":  "application/x-xz",
+	".7z":  "application/x-7z-compressed",
+	".rar": "application/vnd.rar",
+	".zst": "application/zstd",
+}
+
+// genericTypes are what sniffing returns for content it cannot tell apart, such as office documents being zip files
+var genericTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+	"text/xml":                 true,
+	"application/zip":          true,
+}
+
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-tar":            true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/vnd.rar":          true,
+	"application/x-rar-compressed": true,
+	"application/zstd":             true,
+}
+
+var documentTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/rtf":               true,
+	"application/msword":            true,
+	"application/epub+zip":          true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+}
+
+func typeByExtension(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func mediaType(t string) string {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.TrimSpace(strings.ToLower(t))
+}
+
+// MimeType returns the type of a file from its first bytes, falling back on its extension when they are not conclusive
+func MimeType(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	sniffed := http.DetectContentType(head[:n])
+
+	if byExtension := typeByExtension(path); byExtension != "" && genericTypes[mediaType(sniffed)] {
+		return byExtension, nil
+	}
+	return sniffed, nil
+}
+
+// Kind returns the kind of a MIME type, KindOther if it is none of the others
+func Kind(mimeType string) string {
+	t := mediaType(mimeType)
+	switch {
+	case strings.HasPrefix(t, "image/"):
+		return KindImage
+	case strings.HasPrefix(t, "video/"):
+		return KindVideo
+	case strings.HasPrefix(t, "audio/"), t == "application/ogg":
+		return KindAudio
+	case archiveTypes[t]:
+		return KindArchive
+	case strings.HasPrefix(t, "text/"), documentTypes[t],
+		strings.HasPrefix(t, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(t, "application/vnd.oasis.opendocument."):
+		return KindDocument
+	}
+	return KindOther
+}
+
+// IsKind returns whether kind is one of Kinds
+func IsKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
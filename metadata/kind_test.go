@@ -0,0 +1,84 @@
+package metadata
+
+import (
+	"path/filepath"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+const (
+	jpegHead = "\xFF\xD8\xFF\xE0\x00\x10JFIF\x00"
+	pngHead  = "\x89PNG\r\n\x1a\n"
+	zipHead  = "PK\x03\x04\x14\x00\x00\x00"
+)
+
+// Content decides the type, unless sniffing only finds a generic type the extension is more precise than
+func TestMimeType(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"photo.jpg", jpegHead, "image/jpeg"},
+		{"photo.png", jpegHead, "image/jpeg"},
+		{"image.jpg", pngHead, "image/png"},
+		{"song.pdf", "ID3\x04\x00\x00\x00\x00\x00\x00", "audio/mpeg"},
+		{"page.txt", "<html><body>Hi</body></html>", "text/html; charset=utf-8"},
+		{"document.pdf", "%PDF-1.7\n", "application/pdf"},
+		{"archive.zip", zipHead, "application/zip"},
+		{"report.docx", zipHead, "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"sheet.ods", zipHead, "application/vnd.oasis.opendocument.spreadsheet"},
+		{"drawing.svg", `<?xml version="1.0"?><svg/>`, "image/svg+xml"},
+		{"NOTES.MD", "# Notes", "text/markdown"},
+		{"empty.pdf", "", "application/pdf"},
+		{"movie.mkv", "\x00\x01\x02\x03", "video/x-matroska"},
+		{"notes", "plain words", "text/plain; charset=utf-8"},
+		{"data.tfs-unknown", "\x00\x01\x02\x03", "application/octet-stream"},
+		{"data", "\x00\x01\x02\x03", "application/octet-stream"},
+		{"empty", "", "text/plain; charset=utf-8"},
+	} {
+		actual, err := MimeType(testutil.WriteFile(t, dir, test.name, test.content))
+		testutil.Equal(t, test.name+" error", nil, err)
+		testutil.Equal(t, test.name, test.expected, actual)
+	}
+
+	if _, err := MimeType(filepath.Join(dir, "missing.jpg")); err == nil {
+		t.Errorf("expected a missing file to be an error")
+	}
+}
+
+func TestKind(t *testing.T) {
+	for _, test := range []struct {
+		mimeType string
+		expected string
+	}{
+		{"image/jpeg", KindImage},
+		{"Image/SVG+XML", KindImage},
+		{"video/mp4", KindVideo},
+		{"audio/mpeg", KindAudio},
+		{"application/ogg", KindAudio},
+		{"application/zip", KindArchive},
+		{"application/gzip", KindArchive},
+		{"text/plain; charset=utf-8", KindDocument},
+		{" text/html ; charset=utf-8", KindDocument},
+		{"application/pdf", KindDocument},
+		{"application/vnd.openxmlformats-officedocument.presentationml.presentation", KindDocument},
+		{"application/vnd.oasis.opendocument.text", KindDocument},
+		{"application/octet-stream", KindOther},
+		{"application/x-unknown", KindOther},
+		{"imagery/png", KindOther},
+		{"", KindOther},
+	} {
+		testutil.Equal(t, test.mimeType, test.expected, Kind(test.mimeType))
+	}
+}
+
+func TestIsKind(t *testing.T) {
+	for _, kind := range Kinds {
+		testutil.Equal(t, kind, true, IsKind(kind))
+	}
+	for _, kind := range []string{"", "Image", "photo", "image/jpeg"} {
+		testutil.Equal(t, kind, false, IsKind(kind))
+	}
+}
@@ -0,0 +1,90 @@
+// Package metadata reads what files say about themselves: their type, image dimensions, the EXIF of photos, the ID3
+// tags of audio files and the information of PDFs
+package metadata
+
+import (
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Keys of the extracted values, a file only has the ones found in it
+const (
+	KeyWidth       = "width"
+	KeyHeight      = "height"
+	KeyCameraMake  = "camera_make"
+	KeyCameraModel = "camera_model"
+	// KeyTakenAt is the local time a photo was taken, as 2006-01-02T15:04:05
+	KeyTakenAt   = "taken_at"
+	KeyLatitude  = "latitude"
+	KeyLongitude = "longitude"
+	KeyTitle     = "title"
+	KeyArtist    = "artist"
+	KeyAlbum     = "album"
+	KeyYear      = "year"
+	KeyGenre     = "genre"
+	KeyAuthor    = "author"
+)
+
+// Extract returns the metadata of a file, empty for kinds of files it knows nothing about.
+// Malformed files yield what could be read rather than an error, which is only returned when the file cannot be read.
+func Extract(path string) (map[string]string, error) {
+	values := make(map[string]string)
+
+	t, err := MimeType(path)
+	if err != nil {
+		return nil, err
+	}
+	t = mediaType(t)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch {
+	case strings.HasPrefix(t, "image/"):
+		err = readImage(file, values)
+	case t == "audio/mpeg":
+		err = readId3(file, values)
+	case t == "application/pdf":
+		err = readPdf(file, values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func readImage(file *os.File, values map[string]string) error {
+	// EXIF is at the start of JPEGs
+	head := make([]byte, 128<<10)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	readExif(head[:n], values)
+
+	config, _, err := image.DecodeConfig(file)
+	if err == nil {
+		// Dimensions are given upright, photos taken sideways being stored with width and height swapped
+		width, height := config.Width, config.Height
+		if Orientation(head[:n]) >= 5 {
+			width, height = height, width
+		}
+		values[KeyWidth] = strconv.Itoa(width)
+		values[KeyHeight] = strconv.Itoa(height)
+	}
+	return nil
+}
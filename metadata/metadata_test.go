@@ -0,0 +1,35 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Malformed files yield what could be read, only files that cannot be read being errors
+func TestExtractMalformed(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected map[string]string
+	}{
+		{"photo.jpg", "\xFF\xD8\xFF\xE1\x00\x10Exif\x00\x00II*\x00", map[string]string{}},
+		{"image.png", "\x89PNG\r\n\x1a\n\x00\x00", map[string]string{}},
+		{"song.mp3", "ID3\x04\x00\x00\x7F\x7F\x7F\x7FTIT2\xFF\xFF", map[string]string{}},
+		{"doc.pdf", "%PDF-1.4\n/Title (Cut", map[string]string{KeyTitle: "Cut"}},
+		{"notes.txt", "", map[string]string{}},
+	} {
+		values, err := Extract(testutil.WriteFile(t, dir, test.name, test.content))
+		testutil.Equal(t, test.name+" error", nil, err)
+		testutil.Equal(t, test.name, test.expected, values)
+	}
+
+	if _, err := Extract(filepath.Join(dir, "missing.jpg")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file to be an error, got %v", err)
+	}
+	if _, err := Extract(dir); err == nil {
+		t.Errorf("expected a directory to be an error")
+	}
+}
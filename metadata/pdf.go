@@ -0,0 +1,162 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfReadLimit bounds how much of a PDF is searched, half from the start and half from the end where updated information is appended
+const pdfReadLimit = 16 << 20
+
+// readPdfData returns the content of a PDF, or its start and end if it is larger than pdfReadLimit
+func readPdfData(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() <= pdfReadLimit {
+		return io.ReadAll(file)
+	}
+
+	data := make([]byte, pdfReadLimit)
+	half := pdfReadLimit / 2
+	_, err = file.ReadAt(data[:half], 0)
+	if err == nil {
+		_, err = file.ReadAt(data[half:], info.Size()-int64(half))
+	}
+	return data, err
+}
+
+// readPdf adds the title and author of the document information dictionary of a PDF to values.
+// Information stored in compressed object streams is not found.
+func readPdf(file *os.File, values map[string]string) error {
+	data, err := readPdfData(file)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil
+	}
+
+	for key, name := range map[string]string{KeyTitle: "/Title", KeyAuthor: "/Author"} {
+		// The last entry wins, as incremental updates append a new dictionary
+		at := bytes.LastIndex(data, []byte(name))
+		if at < 0 {
+			continue
+		}
+		if value := pdfString(data[at+len(name):]); value != "" {
+			values[key] = value
+		}
+	}
+	return nil
+}
+
+// pdfString decodes the literal (...) or hexadecimal <...> string at the start of data, after whitespace
+func pdfString(data []byte) string {
+	raw, _ := pdfRawString(bytes.TrimLeft(data, " \t\r\n"))
+	return strings.TrimSpace(pdfText(raw))
+}
+
+// pdfRawString returns the bytes of the literal or hexadecimal string starting data, and the length of data it spans
+func pdfRawString(data []byte) ([]byte, int) {
+	if len(data) == 0 {
+		return nil, 0
+	}
+
+	switch data[0] {
+	case '(':
+		raw, n := pdfLiteral(data[1:])
+		return raw, n + 1
+	case '<':
+		end := bytes.IndexByte(data, '>')
+		if end < 0 {
+			return nil, len(data)
+		}
+		digits := bytes.Map(func(r rune) rune {
+			if strings.ContainsRune(" \t\r\n", r) {
+				return -1
+			}
+			return r
+		}, data[1:end])
+		if len(digits)%2 == 1 {
+			digits = append(digits, '0')
+		}
+		decoded, err := hex.DecodeString(string(digits))
+		if err != nil {
+			return nil, end + 1
+		}
+		return decoded, end + 1
+	}
+	return nil, 0
+}
+
+// pdfText decodes the bytes of a string.
+// Strings starting with a byte order mark are UTF-16BE, others use PDFDocEncoding which is close enough to Latin-1.
+func pdfText(raw []byte) string {
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, (len(raw)-2)/2)
+		for i := range units {
+			units[i] = uint16(raw[2+2*i])<<8 | uint16(raw[3+2*i])
+		}
+		return string(utf16.Decode(units))
+	}
+	return latin1(raw)
+}
+
+// pdfLiteral unescapes a literal string up to its closing parenthesis, parentheses being allowed inside when balanced.
+// It also returns the length of data up to and including the closing parenthesis.
+func pdfLiteral(data []byte) ([]byte, int) {
+	out := make([]byte, 0)
+	depth := 0
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\' && i+1 < len(data):
+			i++
+			switch e := data[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					j := i
+					for ; j < len(data) && j < i+3 && data[j] >= '0' && data[j] <= '7'; j++ {
+						value = value*8 + int(data[j]-'0')
+					}
+					out = append(out, byte(value))
+					i = j - 1
+				} else {
+					out = append(out, e)
+				}
+			}
+		case c == '(':
+			depth++
+			out = append(out, c)
+		case c == ')':
+			if depth == 0 {
+				return out, i + 1
+			}
+			depth--
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, len(data)
+}
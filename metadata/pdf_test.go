@@ -0,0 +1,108 @@
+package metadata
+
+import (
+	"os"
+	"path/filepath"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+func TestPdfString(t *testing.T) {
+	for _, test := range []struct {
+		data     string
+		expected string
+	}{
+		{"(Title)", "Title"},
+		{" \r\n (Title) /Author (Someone)", "Title"},
+		{`(Nested (balanced) parens)`, "Nested (balanced) parens"},
+		{`(Escaped \( \) \\ \n)`, "Escaped ( ) \\"},
+		{`(Octal \351t\351 \0616)`, "Octal été 16"},
+		{"(Line \\\ncontinued)", "Line continued"},
+		{"(Unterminated", "Unterminated"},
+		{`(Trailing backslash \`, "Trailing backslash \\"},
+		{"<54 69 74 6C 65>", "Title"},
+		{"<5469746>", "Tit`"},
+		{"<FEFF00E9007400E9>", "été"},
+		{"<FEFF00E90074>", "ét"},
+		{"<FEFF00E900>", "é"},
+		{"<not hex>", ""},
+		{"<5469", ""},
+		{"", ""},
+		{"/Name", ""},
+		{"()", ""},
+	} {
+		testutil.Equal(t, test.data, test.expected, pdfString([]byte(test.data)))
+	}
+}
+
+func readPdfFile(t *testing.T, path string) map[string]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	if err := readPdf(file, values); err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestReadPdf(t *testing.T) {
+	document := "%PDF-1.4\n1 0 obj\n<< /Title (First) /Author <FEFF0041006E006E00E9> >>\nendobj\n" +
+		"2 0 obj\n<< /Title (Updated) >>\nendobj\n%%EOF\n"
+
+	for _, test := range []struct {
+		name     string
+		data     string
+		expected map[string]string
+	}{
+		{"last entry wins", document, map[string]string{KeyTitle: "Updated", KeyAuthor: "Anné"}},
+		{"not a PDF", "1 0 obj << /Title (Hidden) >>", map[string]string{}},
+		{"empty", "", map[string]string{}},
+		{"cut after the name", "%PDF-1.4\n<< /Author (Someone) /Title", map[string]string{KeyAuthor: "Someone"}},
+		{"cut in the string", "%PDF-1.4\n<< /Title <FEFF00", map[string]string{}},
+		{"not a string", "%PDF-1.4\n<< /Title 12 0 R >>", map[string]string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			testutil.Equal(t, "values", test.expected, readPdfFile(t, testutil.WriteFile(t, t.TempDir(), "doc.pdf", test.data)))
+		})
+	}
+}
+
+// Every truncation of a document is read without reaching past its data, which would panic
+func TestReadPdfTruncated(t *testing.T) {
+	document := "%PDF-1.4\n<< /Title (A \\(nested\\) \\351 title) /Author <FEFF0041006E006E00E9> >>\n%%EOF\n"
+	dir := t.TempDir()
+	for n := range document {
+		readPdfFile(t, testutil.WriteFile(t, dir, "doc.pdf", document[:n]))
+	}
+}
+
+// Only the start and end of large documents are read, their information being found at the end
+func TestReadPdfBoundsTheRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "large.pdf")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	end := "<< /Title (At the end) >>\n%%EOF\n"
+	size := int64(4 * pdfReadLimit)
+	// Writing the start and end only leaves a sparse file
+	if _, err := file.WriteAt([]byte("%PDF-1.4\n<< /Title (At the start) /Author (Someone) >>\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte(end), size-int64(len(end))); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readPdfData(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, "read", pdfReadLimit, len(data))
+	testutil.Equal(t, "values", map[string]string{KeyTitle: "At the end", KeyAuthor: "Someone"}, readPdfFile(t, path))
+}
@@ -0,0 +1,235 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// TextLimit bounds how much text is returned for a file, the rest not being searchable
+const TextLimit = 1 << 20
+
+// textTypes are the types read as text besides text/*, source code being sniffed as text/plain
+var textTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"image/svg+xml":          true,
+}
+
+// Text returns the searchable text of plain text, Markdown, source code and PDF files, empty for other types
+func Text(path string) (string, error) {
+	t, err := MimeType(path)
+	if err != nil {
+		return "", err
+	}
+	t = mediaType(t)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	switch {
+	case strings.HasPrefix(t, "text/"), textTypes[t]:
+		data, err := io.ReadAll(io.LimitReader(file, TextLimit))
+		if err != nil {
+			return "", err
+		}
+		return decodeText(data), nil
+	case t == "application/pdf":
+		data, err := readPdfData(file)
+		if err != nil {
+			return "", err
+		}
+		return truncateText(readPdfText(data)), nil
+	}
+	return "", nil
+}
+
+// decodeText decodes UTF-16 text starting with a byte order mark, and drops what is not UTF-8 from other text
+func decodeText(data []byte) string {
+	if len(data) >= 2 && (data[0] == 0xFE && data[1] == 0xFF || data[0] == 0xFF && data[1] == 0xFE) {
+		bigEndian := data[0] == 0xFE
+		units := make([]uint16, (len(data)-2)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = binary.BigEndian.Uint16(data[2+2*i:])
+			} else {
+				units[i] = binary.LittleEndian.Uint16(data[2+2*i:])
+			}
+		}
+		// Text can take more bytes in UTF-8 than in UTF-16
+		return truncateText(string(utf16.Decode(units)))
+	}
+	return strings.ToValidUTF8(string(bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))), "")
+}
+
+func truncateText(text string) string {
+	if len(text) <= TextLimit {
+		return text
+	}
+	text = text[:TextLimit]
+	// Do not cut a character in half
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// pdfSkippedStreams mark streams that hold no page text, like fonts and images
+var pdfSkippedStreams = [][]byte{[]byte("/Length1"), []byte("/Image"), []byte("/XRef"), []byte("/ObjStm"), []byte("/Metadata")}
+
+// readPdfText returns the text shown by the content streams of a PDF.
+// Only uncompressed and Flate streams are read, and text drawn with fonts mapping glyphs to their own codes comes out garbled
+// and is dropped.
+func readPdfText(data []byte) string {
+	var text strings.Builder
+	for offset := 0; ; {
+		start := bytes.Index(data[offset:], []byte("stream"))
+		if start < 0 {
+			break
+		}
+		start += offset
+		offset = start + len("stream")
+
+		// Skip the end of the previous stream and words that contain stream
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			continue
+		}
+		body := offset
+		if bytes.HasPrefix(data[body:], []byte("\r\n")) {
+			body += 2
+		} else if bytes.HasPrefix(data[body:], []byte("\n")) {
+			body++
+		} else {
+			continue
+		}
+		end := bytes.Index(data[body:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		end += body
+		offset = end + len("endstream")
+
+		// The stream's dictionary is between the object header and the stream keyword
+		dictStart := bytes.LastIndex(data[:start], []byte(" obj"))
+		if dictStart < 0 {
+			continue
+		}
+		dict := data[dictStart:start]
+		skip := false
+		for _, marker := range pdfSkippedStreams {
+			skip = skip || bytes.Contains(dict, marker)
+		}
+		if skip {
+			continue
+		}
+
+		content := data[body:end]
+		if bytes.Contains(dict, []byte("/Filter")) {
+			if !bytes.Contains(dict, []byte("/FlateDecode")) {
+				continue
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still give what could be inflated
+			content, _ = io.ReadAll(io.LimitReader(reader, pdfReadLimit))
+		}
+		pdfContentText(content, &text)
+		if text.Len() > TextLimit {
+			break
+		}
+	}
+	return text.String()
+}
+
+// pdfContentText writes the strings shown by the text operators of a content stream to text, a line per positioning operator
+func pdfContentText(content []byte, text *strings.Builder) {
+	var last string
+	var array strings.Builder
+	inArray := false
+
+	emit := func(s string) {
+		if readable(s) {
+			text.WriteString(s)
+		}
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(' || (c == '<' && (i+1 >= len(content) || content[i+1] != '<')):
+			raw, n := pdfRawString(content[i:])
+			s := pdfText(raw)
+			if inArray {
+				array.WriteString(s)
+			} else {
+				last = s
+			}
+			i += n
+		case c == '[':
+			inArray = true
+			array.Reset()
+			i++
+		case c == ']':
+			inArray = false
+			i++
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(content) && (content[j] == '.' || (content[j] >= '0' && content[j] <= '9')) {
+				j++
+			}
+			// Large negative adjustments inside TJ arrays separate words
+			if n, err := strconv.ParseFloat(string(content[i:j]), 64); inArray && err == nil && n < -200 {
+				array.WriteByte(' ')
+			}
+			i = j
+		case c == '\'' || c == '"' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '*':
+			j := i + 1
+			for j < len(content) && ((content[j] >= 'A' && content[j] <= 'Z') || (content[j] >= 'a' && content[j] <= 'z') || content[j] == '*') {
+				j++
+			}
+			switch string(content[i:j]) {
+			case "Tj":
+				emit(last)
+			case "'", "\"":
+				text.WriteByte('\n')
+				emit(last)
+			case "TJ":
+				emit(array.String())
+			case "Td", "TD", "T*", "ET":
+				text.WriteByte('\n')
+			}
+			last = ""
+			i = j
+		default:
+			i++
+		}
+	}
+}
+
+// readable tells text from the glyph codes of fonts with their own encoding, which decode to control characters
+func readable(s string) bool {
+	for _, r := range s {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
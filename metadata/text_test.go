@@ -0,0 +1,111 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"tagged-fs/internal/testutil"
+	"testing"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// deflate compresses parts, and returns where each of them ends in the compressed data
+func deflate(t *testing.T, parts ...string) (string, []int) {
+	t.Helper()
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	ends := make([]int, len(parts))
+	for i, part := range parts {
+		if _, err := writer.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		ends[i] = compressed.Len()
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.String(), ends
+}
+
+// pdfObject returns an object holding a stream
+func pdfObject(id int, dict string, stream string) string {
+	return fmt.Sprintf("%v 0 obj\n<< %v >>\nstream\n%v\nendstream\nendobj\n", id, dict, stream)
+}
+
+func TestText(t *testing.T) {
+	dir := t.TempDir()
+	utf16le := []byte{0xFF, 0xFE}
+	for _, unit := range utf16.Encode([]rune("Grüße 🌍")) {
+		utf16le = binary.LittleEndian.AppendUint16(utf16le, unit)
+	}
+	utf16be := []byte{0xFE, 0xFF}
+	for _, unit := range utf16.Encode([]rune("Hello")) {
+		utf16be = binary.BigEndian.AppendUint16(utf16be, unit)
+	}
+	flate, ends := deflate(t, "BT [(Sec) -50 (ond) -300 (page)] TJ T* ", "<FEFF00E9007400E9> Tj ET")
+
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{"notes.txt", "Plain words\nover lines", []string{"Plain", "words", "over", "lines"}},
+		{"notes.md", "# Café", []string{"#", "Café"}},
+		{"main.go", "package main", []string{"package", "main"}},
+		{"data.json", `{"key": "value"}`, []string{`{"key":`, `"value"}`}},
+		{"bom.txt", "\xEF\xBB\xBFWith a BOM", []string{"With", "a", "BOM"}},
+		{"latin1.txt", "caf\xe9 au lait", []string{"caf", "au", "lait"}},
+		{"utf16le.txt", string(utf16le), []string{"Grüße", "🌍"}},
+		{"utf16be", string(utf16be), []string{"Hello"}},
+		{"odd.txt", string(utf16be[:len(utf16be)-1]), []string{"Hell"}},
+		{"doc.pdf", "%PDF-1.4\n" +
+			pdfObject(1, "/Length 44", "BT /F1 12 Tf 72 712 Td (Hello world) Tj ET") +
+			pdfObject(2, "/Length1 10", "BT (Font data) Tj ET") +
+			pdfObject(3, "/Filter /FlateDecode", flate) +
+			pdfObject(4, "", "BT (\x01\x02\x03) Tj ET") +
+			pdfObject(5, "/Filter /DCTDecode", "BT (Image) Tj ET"),
+			[]string{"Hello", "world", "Second", "page", "été"}},
+		{"cut.pdf", "%PDF-1.4\n" + pdfObject(1, "/Filter /FlateDecode", flate[:ends[0]+2]), []string{"Second", "page"}},
+		{"unterminated.pdf", "%PDF-1.4\n1 0 obj\n<< >>\nstream\nBT (Lost) Tj", []string{}},
+		{"photo.jpg", jpegHead, []string{}},
+		{"archive.zip", zipHead + "(Not text) Tj", []string{}},
+	} {
+		text, err := Text(testutil.WriteFile(t, dir, test.name, test.content))
+		testutil.Equal(t, test.name+" error", nil, err)
+		testutil.Equal(t, test.name, test.expected, strings.Fields(text))
+	}
+
+	if _, err := Text(filepath.Join(dir, "missing.txt")); err == nil {
+		t.Errorf("expected a missing file to be an error")
+	}
+}
+
+// Text beyond TextLimit is dropped, without cutting a character in half
+func TestTextLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name     string
+		content  string
+		expected int
+	}{
+		{"ascii.txt", strings.Repeat("word ", TextLimit/4), TextLimit},
+		{"accents.txt", "a" + strings.Repeat("é", TextLimit), TextLimit - 1},
+		{"short.txt", "short", 5},
+		{"large.pdf", "%PDF-1.4\n" + pdfObject(1, "", "BT (a"+strings.Repeat("\xE9", TextLimit)+") Tj ET"), TextLimit - 1},
+		{"utf16.txt", "\xFF\xFE" + strings.Repeat("\xAC\x20", TextLimit/2), TextLimit - 1},
+	} {
+		text, err := Text(testutil.WriteFile(t, dir, test.name, test.content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, test.name+" length", test.expected, len(text))
+		testutil.Equal(t, test.name+" valid", true, utf8.ValidString(text))
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Created resources are answered with 201, their location and revision
+func TestCreatedResources(t *testing.T) {
+	r, _, dir := newTestServer(t)
+
+	w := request(r, http.MethodPost, ApiPrefix+"/tags", map[string]any{"name": "tag", "color": "#000000"}, nil)
+	expectStatus(t, w, http.StatusCreated)
+	tag := decode[db.Tag](t, w)
+	testutil.Equal(t, "name", "tag", tag.Name)
+	testutil.Equal(t, "location", ApiPrefix+"/tags/"+strconv.Itoa(tag.Id), w.Header().Get("Location"))
+	testutil.Equal(t, "etag", `"`+strconv.Itoa(tag.Revision)+`"`, w.Header().Get("ETag"))
+
+	w = request(r, http.MethodGet, w.Header().Get("Location"), nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	testutil.Equal(t, "tag at the location", tag.Id, decode[db.Tag](t, w).Id)
+
+	path := testutil.WriteFile(t, dir, "a.txt", "a")
+	w = request(r, http.MethodPost, ApiPrefix+"/files", map[string]any{"path": path, "tags": []int{tag.Id}}, nil)
+	expectStatus(t, w, http.StatusCreated)
+	file := decode[db.File](t, w)
+	testutil.Equal(t, "location", ApiPrefix+"/files/"+strconv.Itoa(file.Id), w.Header().Get("Location"))
+	testutil.Equal(t, "tags", 1, len(file.Tags))
+
+	// Routes are only served under the version prefix
+	expectStatus(t, request(r, http.MethodGet, "/tags", nil, nil), http.StatusNotFound)
+}
+
+// Errors are problem details whose code tells what went wrong
+func TestProblemDetails(t *testing.T) {
+	r, _, _ := newTestServer(t)
+
+	for _, test := range []struct {
+		method string
+		path   string
+		body   any
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/tags/999", nil, http.StatusNotFound, CodeNotFound},
+		{http.MethodGet, "/files/999", nil, http.StatusNotFound, CodeNotFound},
+		{http.MethodGet, "/tags/abc", nil, http.StatusBadRequest, CodeInvalidRequest},
+		{http.MethodPost, "/tags", map[string]any{"color": "#000000"}, http.StatusBadRequest, CodeInvalidRequest},
+		{http.MethodPut, "/tags/999", map[string]any{"name": "renamed"}, http.StatusNotFound, CodeNotFound},
+	} {
+		w := request(r, test.method, ApiPrefix+test.path, test.body, nil)
+		expectStatus(t, w, test.status)
+		testutil.Equal(t, "content type of "+test.path, "application/problem+json", w.Header().Get("Content-Type"))
+		problem := decode[Problem](t, w)
+		testutil.Equal(t, "problem of "+test.method+" "+test.path, Problem{
+			Type: "about:blank", Title: http.StatusText(test.status), Status: test.status, Detail: problem.Detail, Code: test.code,
+		}, problem)
+		if problem.Detail == "" {
+			t.Errorf("expected the problem of %v %v to have a detail", test.method, test.path)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"tagged-fs/action"
+	"tagged-fs/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readOnlyRoutes use a mutating method but only read, so read scoped keys can call them
+var readOnlyRoutes = map[string]bool{
+	"POST " + ApiPrefix + "/files/search": true,
+	// Mutations are refused by the resolvers
+	"POST " + ApiPrefix + "/graphql": true,
+}
+
+// readOnlyKeyKey holds the name of the request's API key when it is read-only
+const readOnlyKeyKey = "readOnlyKey"
+
+// ReadOnlyKeyError is panicked when a read-only API key is used to make a change, it is answered with 403
+type ReadOnlyKeyError struct {
+	Name string
+}
+
+func (e ReadOnlyKeyError) Error() string {
+	return fmt.Sprintf("API key '%v' is read-only", e.Name)
+}
+
+func requestSecret(c *gin.Context) string {
+	if authorization := c.GetHeader("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+	return c.GetHeader("X-API-Key")
+}
+
+// authMiddleware requires an API key when the server is configured for it.
+// Changes made with a key are attributed to it in the audit log, and keys of a user only see what the user can see.
+func authMiddleware(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.RequireAuth || c.Request.Method == http.MethodOptions {
+			return
+		}
+
+		secret := requestSecret(c)
+		if secret == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortWithProblem(c, http.StatusUnauthorized, CodeUnauthorized, "Missing API key")
+			return
+		}
+
+		db_ := getDB(c)
+		key := action.Authenticate(db_, secret)
+		if key == nil {
+			c.Header("WWW-Authenticate", "Bearer")
+			abortWithProblem(c, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+			return
+		}
+
+		method := c.Request.Method
+		write := method != http.MethodGet && method != http.MethodHead && !readOnlyRoutes[method+" "+c.FullPath()]
+		if key.Scope != db.ScopeReadWrite {
+			if write {
+				abortWithProblem(c, http.StatusForbidden, CodeReadOnlyKey, ReadOnlyKeyError{key.Name}.Error())
+				return
+			}
+			c.Set(readOnlyKeyKey, key.Name)
+		}
+
+		db_ = db_.WithOrigin(db_.Origin() + ":" + key.Name)
+		if key.UserId != nil {
+			db_ = db_.WithUser(*key.UserId)
+		}
+		c.Set(dbKey, db_)
+	}
+}
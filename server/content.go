@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"tagged-fs/action"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contentETag identifies a version of a file from its size and modification time, so it changes when the file is rewritten
+func contentETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// serveContent sends a tracked file inline, or as an attachment when download is set.
+// http.ServeContent answers Range, If-Range, If-None-Match and If-Modified-Since requests, so players can seek in large media
+// and unchanged files are not sent again.
+func serveContent(c *gin.Context, path string, download bool) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		panic(action.NotFoundError{Message: fmt.Sprintf("File '%v' is missing from the disk", path)})
+	}
+	must(err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	must(err)
+	if info.IsDir() {
+		panic(action.InvalidError{Message: fmt.Sprintf("'%v' is a directory", path)})
+	}
+
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+
+	header := c.Writer.Header()
+	header.Set("ETag", contentETag(info))
+	// Files can change on disk at any time, so caches must check the ETag before reusing them
+	header.Set("Cache-Control", "private, no-cache")
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filepath.Base(path)}))
+	// Files are not trusted, opening one in a browser must not run it as a page of the API
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "sandbox")
+
+	// The type is guessed from the extension, then from the content
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
+}
+
+// serveThumbnail sends a cached thumbnail, whose name is the content hash and size so it makes a strong ETag
+func serveThumbnail(c *gin.Context, thumbPath string) {
+	file, err := os.Open(thumbPath)
+	must(err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	must(err)
+
+	name := filepath.Base(thumbPath)
+	header := c.Writer.Header()
+	header.Set("ETag", `"`+strings.TrimSuffix(name, filepath.Ext(name))+`"`)
+	// The same URL shows another thumbnail when the file changes
+	header.Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), file)
+}
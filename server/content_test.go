@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"tagged-fs/action"
+	"tagged-fs/internal/testutil"
+	"testing"
+	"time"
+)
+
+func expectHeader(t *testing.T, header http.Header, key string, value string) {
+	t.Helper()
+	if header.Get(key) != value {
+		t.Errorf("expected %v: %v, got %v", key, value, header.Get(key))
+	}
+}
+
+func TestFileContent(t *testing.T) {
+	r, db_, dir := newTestServer(t)
+	path := testutil.WriteFile(t, dir, "a file.txt", "0123456789")
+	url := ApiPrefix + "/files/" + strconv.Itoa(action.AddFile(db_, path, nil)) + "/file"
+
+	w := request(r, http.MethodGet, url, nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	if w.Body.String() != "0123456789" {
+		t.Errorf("expected the content of the file, got %v", w.Body.String())
+	}
+	expectHeader(t, w.Header(), "Content-Disposition", `inline; filename="a file.txt"`)
+	expectHeader(t, w.Header(), "Accept-Ranges", "bytes")
+	expectHeader(t, w.Header(), "X-Content-Type-Options", "nosniff")
+	etag := w.Header().Get("ETag")
+
+	t.Run("download", func(t *testing.T) {
+		w := request(r, http.MethodGet, url+"?download=true", nil, nil)
+		expectStatus(t, w, http.StatusOK)
+		expectHeader(t, w.Header(), "Content-Disposition", `attachment; filename="a file.txt"`)
+	})
+
+	t.Run("range", func(t *testing.T) {
+		w := request(r, http.MethodGet, url, nil, http.Header{"Range": {"bytes=2-5"}})
+		expectStatus(t, w, http.StatusPartialContent)
+		expectHeader(t, w.Header(), "Content-Range", "bytes 2-5/10")
+		if w.Body.String() != "2345" {
+			t.Errorf("expected the range of the file, got %v", w.Body.String())
+		}
+
+		w = request(r, http.MethodGet, url, nil, http.Header{"Range": {"bytes=20-"}})
+		expectStatus(t, w, http.StatusRequestedRangeNotSatisfiable)
+	})
+
+	t.Run("if none match", func(t *testing.T) {
+		w := request(r, http.MethodGet, url, nil, http.Header{"If-None-Match": {etag}})
+		expectStatus(t, w, http.StatusNotModified)
+		if w.Body.Len() != 0 {
+			t.Errorf("expected no body, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("rewritten file", func(t *testing.T) {
+		testutil.WriteFile(t, dir, "a file.txt", "rewritten")
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+
+		w := request(r, http.MethodGet, url, nil, http.Header{"If-None-Match": {etag}})
+		expectStatus(t, w, http.StatusOK)
+		if w.Body.String() != "rewritten" || w.Header().Get("ETag") == etag {
+			t.Errorf("expected the new content with a new ETag, got %v with %v", w.Body.String(), w.Header().Get("ETag"))
+		}
+
+		// A range of the old version must not be mixed with the new one
+		w = request(r, http.MethodGet, url, nil, http.Header{"Range": {"bytes=0-1"}, "If-Range": {etag}})
+		expectStatus(t, w, http.StatusOK)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		expectStatus(t, request(r, http.MethodGet, url, nil, nil), http.StatusNotFound)
+	})
+}
+
+func TestThumbnailNotModified(t *testing.T) {
+	r, db_, dir := newTestServer(t)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	url := ApiPrefix + "/files/" + strconv.Itoa(action.AddFile(db_, testutil.WriteFile(t, dir, "image.png", buf.String()), nil)) + "/thumbnail"
+
+	w := request(r, http.MethodGet, url, nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	expectHeader(t, w.Header(), "Content-Type", "image/jpeg")
+
+	w = request(r, http.MethodGet, url, nil, http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+	expectStatus(t, w, http.StatusNotModified)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunWriter swallows the handler's response so the dry-run summary can be sent instead
+type dryRunWriter struct {
+	gin.ResponseWriter
+	header http.Header
+}
+
+func (w *dryRunWriter) Header() http.Header               { return w.header }
+func (w *dryRunWriter) WriteHeader(int)                   {}
+func (w *dryRunWriter) WriteHeaderNow()                   {}
+func (w *dryRunWriter) Write(data []byte) (int, error)    { return len(data), nil }
+func (w *dryRunWriter) WriteString(s string) (int, error) { return len(s), nil }
+func (w *dryRunWriter) Written() bool                     { return false }
+func (w *dryRunWriter) Status() int                       { return http.StatusOK }
+func (w *dryRunWriter) Size() int                         { return -1 }
+
+// dryRunMiddleware runs mutating routes inside a rolled back transaction when called with ?dryRun=true,
+// responding with the rows that would have been inserted, updated or deleted
+func dryRunMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("dryRun") != "true" {
+			return
+		}
+
+		tx := getDB(c).BeginDryRun()
+		defer tx.Rollback()
+		c.Set(dbKey, tx)
+
+		// Restored before the recovery middleware writes errors
+		writer := c.Writer
+		c.Writer = &dryRunWriter{ResponseWriter: writer, header: make(http.Header)}
+		defer func() { c.Writer = writer }()
+
+		c.Next()
+
+		c.Writer = writer
+		c.JSON(http.StatusOK, gin.H{"dryRun": true, "summary": tx.DryRunSummary()})
+	}
+}
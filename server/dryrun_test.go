@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"tagged-fs/db"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// ?dryRun=true answers what the request would have changed instead of its response, and changes nothing
+func TestDryRun(t *testing.T) {
+	r, _, _ := newTestServer(t)
+
+	w := request(r, http.MethodPost, ApiPrefix+"/tags?dryRun=true", map[string]any{"name": "tag", "color": "#000000"}, nil)
+	expectStatus(t, w, http.StatusOK)
+	testutil.Equal(t, "location", "", w.Header().Get("Location"))
+	response := decode[struct {
+		DryRun  bool             `json:"dryRun"`
+		Summary db.DryRunSummary `json:"summary"`
+	}](t, w)
+	testutil.Equal(t, "dry run", true, response.DryRun)
+	testutil.Equal(t, "tag changes", db.TableChanges{Inserted: 1}, response.Summary.Tables["tag"])
+
+	w = request(r, http.MethodGet, ApiPrefix+"/tags", nil, nil)
+	expectStatus(t, w, http.StatusOK)
+	testutil.Equal(t, "tags", 0, len(decode[[]db.Tag](t, w)))
+
+	// Errors are answered as usual
+	w = request(r, http.MethodPost, ApiPrefix+"/tags?dryRun=true", map[string]any{"name": "tag", "color": "black"}, nil)
+	expectStatus(t, w, http.StatusBadRequest)
+	testutil.Equal(t, "code", CodeInvalidRequest, decode[Problem](t, w).Code)
+}
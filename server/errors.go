@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tagged-fs/action"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Problem is the RFC 7807 body of every error response, Code identifies the kind of error for clients
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+}
+
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeReadOnlyKey      = "read_only_key"
+	CodeForbiddenPath    = "forbidden_path"
+	CodePermissionDenied = "permission_denied"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeStaleRevision    = "stale_revision"
+	CodeUnsupported      = "unsupported_media_type"
+	CodeTimeout          = "timeout"
+	CodeCanceled         = "canceled"
+	CodeInternal         = "internal_error"
+)
+
+func abortWithProblem(c *gin.Context, status int, code string, detail string) {
+	c.Header("Content-Type", "application/problem+json")
+	c.AbortWithStatusJSON(status, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	})
+}
+
+// recoverProblem turns the values panicked by actions and handlers into problem responses
+func recoverProblem(c *gin.Context, recovered any) {
+	err := recoveredError(recovered)
+	status, code := problemFor(err, hasIfMatch(c))
+	abortWithProblem(c, status, code, err.Error())
+}
+
+func recoveredError(recovered any) error {
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("%v", recovered)
+	}
+	return err
+}
+
+// problemFor returns the status and code answering err, ifMatch tells whether the request was guarded by If-Match
+func problemFor(err error, ifMatch bool) (int, string) {
+	var readOnly ReadOnlyKeyError
+	var forbidden ForbiddenPathError
+	var permission action.PermissionError
+	var notFound action.NotFoundError
+	var conflict action.ConflictError
+	var invalid action.InvalidError
+	var stale action.StaleRevisionError
+	var unsupported action.UnsupportedError
+	switch {
+	case errors.As(err, &readOnly):
+		return http.StatusForbidden, CodeReadOnlyKey
+	case errors.As(err, &forbidden):
+		return http.StatusForbidden, CodeForbiddenPath
+	case errors.As(err, &permission):
+		return http.StatusForbidden, CodePermissionDenied
+	case errors.As(err, &notFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.As(err, &conflict):
+		return http.StatusConflict, CodeConflict
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest, CodeInvalidRequest
+	case errors.As(err, &stale):
+		// Stale writes are 412 when guarded by If-Match and 409 when guarded by a body revision
+		if ifMatch {
+			return http.StatusPreconditionFailed, CodeStaleRevision
+		}
+		return http.StatusConflict, CodeStaleRevision
+	case errors.As(err, &unsupported):
+		return http.StatusUnsupportedMediaType, CodeUnsupported
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable, CodeTimeout
+	case errors.Is(err, context.Canceled):
+		// The client disconnected or the server is shutting down
+		return http.StatusServiceUnavailable, CodeCanceled
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
+
+// idParam returns the :id path parameter
+func idParam(c *gin.Context) int {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		panic(action.InvalidError{Message: fmt.Sprintf("Invalid id: '%v'", c.Param("id"))})
+	}
+	return id
+}
+
+func bindJSON(c *gin.Context, obj any) {
+	err := c.ShouldBindJSON(obj)
+	if err != nil {
+		panic(action.InvalidError{Message: err.Error()})
+	}
+}
+
+func bindQuery(c *gin.Context, obj any) {
+	err := c.ShouldBindQuery(obj)
+	if err != nil {
+		panic(action.InvalidError{Message: err.Error()})
+	}
+}
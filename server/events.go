@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"tagged-fs/action"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// eventsKeepAlive is how often a comment is sent on idle streams so proxies do not close them
+	eventsKeepAlive = 15 * time.Second
+	// maxEventReplay is the number of missed events sent to a resuming client, which is sent a reset event instead when
+	// it missed more
+	maxEventReplay = 1000
+)
+
+func writeEvent(w io.Writer, event action.Event) {
+	data, err := json.Marshal(event)
+	must(err)
+
+	fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", event.Id, data)
+}
+
+// writeReset tells the client it missed too many changes to be sent them, and must reload everything
+func writeReset(w io.Writer, lastId int) {
+	data, err := json.Marshal(gin.H{"id": lastId, "type": action.EventReset})
+	must(err)
+
+	fmt.Fprintf(w, "id: %d\nevent: reset\ndata: %s\n\n", lastId, data)
+}
+
+// eventsHandler streams changes as server-sent events until the client disconnects or shutdown is closed.
+// Clients resuming with Last-Event-ID (or ?since=) first receive the events they missed, or a reset event if they
+// missed more than maxEventReplay.
+func eventsHandler(bus *action.EventBus, shutdown <-chan struct{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := getDB(c).User()
+
+		lastId := -1
+		resume := c.GetHeader("Last-Event-ID")
+		if resume == "" {
+			resume = c.Query("since")
+		}
+		if resume != "" {
+			id, err := strconv.Atoi(resume)
+			if err != nil {
+				panic(action.InvalidError{Message: fmt.Sprintf("Invalid event id: '%v'", resume)})
+			}
+			lastId = id
+		}
+
+		// Subscribe before reading missed events so none are lost in between, duplicates are skipped by id
+		events, unsubscribe := bus.Subscribe()
+		defer unsubscribe()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		send := func(event action.Event) {
+			if event.Id <= lastId || !event.VisibleTo(user) {
+				return
+			}
+			writeEvent(c.Writer, event)
+			lastId = event.Id
+		}
+
+		if lastId >= 0 {
+			missed, complete := bus.Since(lastId, maxEventReplay)
+			if complete {
+				for _, event := range missed {
+					send(event)
+				}
+			} else {
+				lastId = bus.LastId()
+				writeReset(c.Writer, lastId)
+			}
+		}
+		c.Writer.Flush()
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+				send(event)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-c.Request.Context().Done():
+				return false
+			case <-shutdown:
+				return false
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"tagged-fs/action"
+	"tagged-fs/db"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+)
+
+//go:embed schema.graphql
+var graphqlSchema string
+
+type graphqlRequestKey struct{}
+
+// graphqlRequest holds what the resolvers of a request share.
+// Tags and file assignments are loaded once so nested selections do not query the database for every tag and file.
+type graphqlRequest struct {
+	db db.DB
+	// readOnlyKey is the name of the request's API key if it cannot make changes
+	readOnlyKey string
+	config      Config
+
+	tags    []db.Tag // nil until loaded
+	tagById map[int]db.Tag
+	// fileTagIds are the tags of the files found by searches by tags, loaded for all of unloadedFileIds at once
+	fileTagIds      map[int][]int
+	unloadedFileIds []int
+}
+
+func requestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+func (r *graphqlRequest) allTags() []db.Tag {
+	if r.tags == nil {
+		r.tags = action.ListTags(r.db)
+		r.tagById = make(map[int]db.Tag)
+		for _, tag := range r.tags {
+			r.tagById[tag.Id] = tag
+		}
+	}
+	return r.tags
+}
+
+// tagResolvers looks up tags by id, skipping those the request cannot see
+func (r *graphqlRequest) tagResolvers(ids []int) []*tagResolver {
+	r.allTags()
+
+	resolvers := make([]*tagResolver, 0)
+	for _, id := range ids {
+		if tag, ok := r.tagById[id]; ok {
+			resolvers = append(resolvers, &tagResolver{tag})
+		}
+	}
+	return resolvers
+}
+
+func (r *graphqlRequest) fileTags(fileId int) []int {
+	if _, ok := r.fileTagIds[fileId]; !ok {
+		if r.fileTagIds == nil {
+			r.fileTagIds = make(map[int][]int)
+		}
+		ids := append(r.unloadedFileIds, fileId)
+		for _, id := range ids {
+			r.fileTagIds[id] = nil
+		}
+		for _, file := range action.GetFiles(r.db, ids) {
+			r.fileTagIds[file.Id] = tagIds(file.Tags)
+		}
+		r.unloadedFileIds = nil
+	}
+	return r.fileTagIds[fileId]
+}
+
+// write is called by every mutation, it forgets what was loaded as it is about to change
+func (r *graphqlRequest) write() {
+	if r.readOnlyKey != "" {
+		panic(ReadOnlyKeyError{r.readOnlyKey})
+	}
+	r.tags = nil
+	r.tagById = nil
+	r.fileTagIds = nil
+}
+
+func tagIds(tags []db.Tag) []int {
+	ids := make([]int, len(tags))
+	for i, tag := range tags {
+		ids[i] = tag.Id
+	}
+	return ids
+}
+
+func toInts(ints []int32) []int {
+	result := make([]int, len(ints))
+	for i, n := range ints {
+		result[i] = int(n)
+	}
+	return result
+}
+
+// metadataInput is a key and part of its value, as GraphQL has no maps
+type metadataInput struct {
+	Key   string
+	Value string
+}
+
+func metadataSearch(inputs *[]metadataInput /* nilable */) map[string]string {
+	if inputs == nil {
+		return nil
+	}
+	search := make(map[string]string)
+	for _, input := range *inputs {
+		search[input.Key] = input.Value
+	}
+	return search
+}
+
+func optionalInt(n *int32 /* nilable */) *int {
+	if n == nil {
+		return nil
+	}
+	i := int(*n)
+	return &i
+}
+
+type tagResolver struct {
+	tag db.Tag
+}
+
+func (t *tagResolver) Id() int32               { return int32(t.tag.Id) }
+func (t *tagResolver) Name() string            { return t.tag.Name }
+func (t *tagResolver) Color() string           { return t.tag.Color }
+func (t *tagResolver) Private() bool           { return t.tag.Private }
+func (t *tagResolver) Revision() int32         { return int32(t.tag.Revision) }
+func (t *tagResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: t.tag.UpdatedAt} }
+func (t *tagResolver) OwnerId() *int32 /* nilable */ {
+	if t.tag.OwnerId == nil {
+		return nil
+	}
+	id := int32(*t.tag.OwnerId)
+	return &id
+}
+
+func (t *tagResolver) Parents(ctx context.Context) []*tagResolver {
+	return requestFrom(ctx).tagResolvers(t.tag.ParentIds)
+}
+
+func (t *tagResolver) Children(ctx context.Context) []*tagResolver {
+	children := make([]*tagResolver, 0)
+	for _, tag := range requestFrom(ctx).allTags() {
+		for _, parentId := range tag.ParentIds {
+			if parentId == t.tag.Id {
+				children = append(children, &tagResolver{tag})
+			}
+		}
+	}
+	return children
+}
+
+func (t *tagResolver) Files(ctx context.Context, args struct {
+	Name     *string
+	Fuzzy    bool
+	Text     *string
+	Kinds    *[]string
+	Metadata *[]metadataInput
+}) []*fileResolver {
+	search := db.FileSearch{Name: args.Name, Fuzzy: args.Fuzzy, Text: args.Text, TagIds: []int{t.tag.Id}, Metadata: metadataSearch(args.Metadata)}
+	if args.Kinds != nil {
+		search.Kinds = *args.Kinds
+	}
+	req := requestFrom(ctx)
+	return req.fileResolvers(action.ListFiles(req.db, search), true)
+}
+
+type fileResolver struct {
+	file db.File
+	// partialTags is set when the file comes from a search by tags, which only returns the matching tags
+	partialTags bool
+}
+
+// fileResolvers resolves files, whose tags are loaded together if they are partial
+func (r *graphqlRequest) fileResolvers(files []db.File, partialTags bool) []*fileResolver {
+	resolvers := make([]*fileResolver, len(files))
+	for i, file := range files {
+		resolvers[i] = &fileResolver{file, partialTags}
+		if partialTags {
+			r.unloadedFileIds = append(r.unloadedFileIds, file.Id)
+		}
+	}
+	return resolvers
+}
+
+func (f *fileResolver) Id() int32               { return int32(f.file.Id) }
+func (f *fileResolver) Path() string            { return f.file.Path }
+func (f *fileResolver) Name() string            { return f.file.Name }
+func (f *fileResolver) Revision() int32         { return int32(f.file.Revision) }
+func (f *fileResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: f.file.UpdatedAt} }
+func (f *fileResolver) MimeType() string        { return f.file.MimeType }
+func (f *fileResolver) Kind() string            { return f.file.Kind }
+
+// Metadata is sorted by key, so the order does not change between queries
+func (f *fileResolver) Metadata() []*metadataResolver {
+	keys := make([]string, 0, len(f.file.Metadata))
+	for key := range f.file.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	resolvers := make([]*metadataResolver, len(keys))
+	for i, key := range keys {
+		resolvers[i] = &metadataResolver{key, f.file.Metadata[key]}
+	}
+	return resolvers
+}
+
+// Tags are looked up in the request's tags, as the file's own lack their parents
+func (f *fileResolver) Tags(ctx context.Context) []*tagResolver {
+	req := requestFrom(ctx)
+	if f.partialTags {
+		return req.tagResolvers(req.fileTags(f.file.Id))
+	}
+	return req.tagResolvers(tagIds(f.file.Tags))
+}
+
+type metadataResolver struct {
+	key   string
+	value string
+}
+
+func (m *metadataResolver) Key() string   { return m.key }
+func (m *metadataResolver) Value() string { return m.value }
+
+// graphqlResolver resolves the fields of Query and Mutation
+type graphqlResolver struct{}
+
+func (*graphqlResolver) Tags(ctx context.Context) []*tagResolver {
+	tags := requestFrom(ctx).allTags()
+
+	resolvers := make([]*tagResolver, len(tags))
+	for i, tag := range tags {
+		resolvers[i] = &tagResolver{tag}
+	}
+	return resolvers
+}
+
+func (*graphqlResolver) Tag(ctx context.Context, args struct{ Id int32 }) *tagResolver {
+	return &tagResolver{action.GetTag(requestFrom(ctx).db, int(args.Id))}
+}
+
+func (*graphqlResolver) Files(ctx context.Context, args struct {
+	Name     *string
+	Fuzzy    bool
+	Text     *string
+	TagIds   *[]int32
+	Kinds    *[]string
+	Metadata *[]metadataInput
+}) []*fileResolver {
+	search := db.FileSearch{Name: args.Name, Fuzzy: args.Fuzzy, Text: args.Text, Metadata: metadataSearch(args.Metadata)}
+	if args.TagIds != nil {
+		search.TagIds = toInts(*args.TagIds)
+	}
+	if args.Kinds != nil {
+		search.Kinds = *args.Kinds
+	}
+	req := requestFrom(ctx)
+	return req.fileResolvers(action.ListFiles(req.db, search), len(search.TagIds) != 0)
+}
+
+func (*graphqlResolver) File(ctx context.Context, args struct{ Id int32 }) *fileResolver {
+	return &fileResolver{action.GetFile(requestFrom(ctx).db, int(args.Id)), false}
+}
+
+func (*graphqlResolver) AddTag(ctx context.Context, args struct {
+	Name      string
+	Color     string
+	Private   bool
+	ParentIds []int32
+}) *tagResolver {
+	req := requestFrom(ctx)
+	req.write()
+
+	id := action.AddTag(req.db, args.Name, args.Color, args.Private, toInts(args.ParentIds))
+	return &tagResolver{action.GetTag(req.db, id)}
+}
+
+func (*graphqlResolver) EditTag(ctx context.Context, args struct {
+	Id        int32
+	Revision  *int32
+	Name      *string
+	Color     *string
+	Private   *bool
+	ParentIds *[]int32
+}) *tagResolver {
+	req := requestFrom(ctx)
+	req.write()
+
+	var parentIds *[]int
+	if args.ParentIds != nil {
+		ids := toInts(*args.ParentIds)
+		parentIds = &ids
+	}
+
+	action.EditTag(req.db, int(args.Id), optionalInt(args.Revision), args.Name, args.Color, args.Private, parentIds)
+	return &tagResolver{action.GetTag(req.db, int(args.Id))}
+}
+
+func (*graphqlResolver) RmTag(ctx context.Context, args struct{ Id int32 }) bool {
+	req := requestFrom(ctx)
+	req.write()
+
+	action.RmTag(req.db, int(args.Id))
+	return true
+}
+
+func (r *graphqlResolver) ReorderTags(ctx context.Context, args struct{ Ids []int32 }) []*tagResolver {
+	req := requestFrom(ctx)
+	req.write()
+
+	action.ReorderTags(req.db, toInts(args.Ids))
+	return r.Tags(ctx)
+}
+
+func (*graphqlResolver) AddFile(ctx context.Context, args struct {
+	Path   string
+	TagIds []int32
+}) *fileResolver {
+	req := requestFrom(ctx)
+	req.write()
+
+	req.config.checkAllowedPath(args.Path)
+	id := action.AddFile(req.db, args.Path, toInts(args.TagIds))
+	return &fileResolver{action.GetFile(req.db, id), false}
+}
+
+func (*graphqlResolver) EditFile(ctx context.Context, args struct {
+	Id       int32
+	Revision *int32
+	TagIds   []int32
+}) *fileResolver {
+	req := requestFrom(ctx)
+	req.write()
+
+	action.EditFile(req.db, int(args.Id), optionalInt(args.Revision), toInts(args.TagIds))
+	return &fileResolver{action.GetFile(req.db, int(args.Id)), false}
+}
+
+func (*graphqlResolver) RmFile(ctx context.Context, args struct{ Id int32 }) bool {
+	req := requestFrom(ctx)
+	req.write()
+
+	action.RmFile(req.db, int(args.Id))
+	return true
+}
+
+// graphqlPanics turns the values panicked by actions into errors with the code problem responses would have,
+// only logging unexpected ones
+type graphqlPanics struct{}
+
+func (graphqlPanics) MakePanicError(ctx context.Context, value any) *gqlerrors.QueryError {
+	err := recoveredError(value)
+	_, code := problemFor(err, false)
+
+	queryErr := gqlerrors.Errorf("%v", err)
+	queryErr.Err = err
+	queryErr.Extensions = map[string]any{"code": code}
+	return queryErr
+}
+
+func (graphqlPanics) LogPanic(ctx context.Context, value any) {
+	if _, code := problemFor(recoveredError(value), false); code == CodeInternal {
+		log.Printf("graphql: %v", value)
+	}
+}
+
+const (
+	// graphqlMaxDepth bounds the nesting of queries, each level of which can multiply the work, like
+	// tags { files { tags { files ... } } }
+	graphqlMaxDepth = 7
+	// graphqlMaxQueryLength bounds the number of fields a query can select at each level with aliases
+	graphqlMaxQueryLength = 10_000
+)
+
+// graphqlHandler executes the queries and mutations of schema.graphql.
+// Resolvers run one at a time as they share the request's transaction during dry runs.
+func graphqlHandler(config Config) gin.HandlerFunc {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlResolver{},
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(graphqlMaxDepth),
+		graphql.MaxParallelism(1),
+		graphql.PanicHandler(graphqlPanics{}),
+		graphql.Logger(graphqlPanics{}),
+	)
+
+	return func(c *gin.Context) {
+		var params struct {
+			Query         string         `json:"query" binding:"required"`
+			OperationName string         `json:"operationName"`
+			Variables     map[string]any `json:"variables"`
+		}
+		bindJSON(c, &params)
+		if len(params.Query) > graphqlMaxQueryLength {
+			panic(action.InvalidError{Message: fmt.Sprintf("Query longer than %v bytes", graphqlMaxQueryLength)})
+		}
+
+		ctx := context.WithValue(c.Request.Context(), graphqlRequestKey{}, &graphqlRequest{
+			db:          getDB(c),
+			readOnlyKey: c.GetString(readOnlyKeyKey),
+			config:      config,
+		})
+		c.JSON(http.StatusOK, schema.Exec(ctx, params.Query, params.OperationName, params.Variables))
+	}
+}
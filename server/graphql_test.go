@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"tagged-fs/action"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// The files of a tag list all their tags, loaded for those files only
+func TestGraphqlFilesOfTagHaveAllTheirTags(t *testing.T) {
+	r, db_, dir := newTestServer(t)
+	root := action.AddTag(db_, "root", "#000000", false, nil)
+	other := action.AddTag(db_, "other", "#000000", false, nil)
+	a := action.AddFile(db_, testutil.WriteFile(t, dir, "a.txt", "a"), []int{root, other})
+	action.AddFile(db_, testutil.WriteFile(t, dir, "b.txt", "b"), []int{other})
+
+	w := request(r, http.MethodPost, ApiPrefix+"/graphql", map[string]any{
+		"query":     "query($id: Int!) { tag(id: $id) { files { id tags { id } } } }",
+		"variables": map[string]any{"id": root},
+	}, nil)
+	expectStatus(t, w, http.StatusOK)
+
+	type file struct {
+		Id   int
+		Tags []struct{ Id int }
+	}
+	response := decode[struct {
+		Data struct{ Tag struct{ Files []file } }
+	}](t, w)
+	expected := []file{{a, []struct{ Id int }{{root}, {other}}}}
+	testutil.Equal(t, "files", expected, response.Data.Tag.Files)
+}
+
+// Queries nesting fields too deeply or too long are refused before being run
+func TestGraphqlLimits(t *testing.T) {
+	r, db_, dir := newTestServer(t)
+	tag := action.AddTag(db_, "tag", "#000000", false, nil)
+	action.AddFile(db_, testutil.WriteFile(t, dir, "a.txt", "a"), []int{tag})
+
+	query := func(query string) (int, string) {
+		w := request(r, http.MethodPost, ApiPrefix+"/graphql", map[string]any{"query": query}, nil)
+		if w.Code != http.StatusOK {
+			return w.Code, decode[Problem](t, w).Code
+		}
+		response := decode[struct {
+			Data   any
+			Errors []struct{ Message string }
+		}](t, w)
+		if len(response.Errors) != 0 {
+			if response.Data != nil {
+				t.Errorf("expected no data with the errors, got %v", response.Data)
+			}
+			return w.Code, response.Errors[0].Message
+		}
+		return w.Code, ""
+	}
+
+	status, message := query("{ tags { files { tags { files { tags { files { id } } } } } } }")
+	testutil.Equal(t, "at the max depth", [2]any{http.StatusOK, ""}, [2]any{status, message})
+
+	status, message = query("{ tags { files { tags { files { tags { files { tags { id } } } } } } } }")
+	testutil.Equal(t, "over the max depth", [2]any{http.StatusOK, `Field "id" has depth 8 that exceeds max depth 7`}, [2]any{status, message})
+
+	status, message = query("{ tags { id " + strings.Repeat("name ", graphqlMaxQueryLength/5) + "} }")
+	testutil.Equal(t, "over the max length", [2]any{http.StatusBadRequest, CodeInvalidRequest}, [2]any{status, message})
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openApi describes every route registered by SetupGin, TestOpenApiDescribesEveryRoute fails if they diverge
+//
+//go:embed openapi.json
+var openApi []byte
+
+func serveOpenApi(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openApi)
+}
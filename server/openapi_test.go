@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// undocumentedRoutes are registered by SetupGin but are not part of the API
+var undocumentedRoutes = map[string]bool{
+	"OPTIONS /*cors":   true,
+	"GET /favicon.png": true,
+}
+
+var openApiParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// openApiRoutes returns the routes described by the document, as "METHOD /api/v1/path/:param"
+func openApiRoutes() []string {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openApi, &doc); err != nil {
+		panic(err)
+	}
+
+	routes := make([]string, 0)
+	for path, operations := range doc.Paths {
+		ginPath := ApiPrefix + openApiParam.ReplaceAllString(path, ":$1")
+		for method := range operations {
+			routes = append(routes, strings.ToUpper(method)+" "+ginPath)
+		}
+	}
+
+	return routes
+}
+
+// Every route must be in openapi.json, which must not describe routes that do not exist
+func TestOpenApiDescribesEveryRoute(t *testing.T) {
+	r, _, _ := newTestServer(t)
+
+	documented := make(map[string]bool)
+	for _, route := range openApiRoutes() {
+		documented[route] = true
+	}
+
+	missing := make([]string, 0)
+	for _, info := range r.Routes() {
+		route := info.Method + " " + info.Path
+		if undocumentedRoutes[route] {
+			continue
+		}
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+		delete(documented, route)
+	}
+
+	extra := make([]string, 0, len(documented))
+	for route := range documented {
+		extra = append(extra, route)
+	}
+
+	if len(missing) != 0 || len(extra) != 0 {
+		sort.Strings(missing)
+		sort.Strings(extra)
+		t.Errorf("openapi.json is out of sync with the routes. Undocumented: %v. Documented but not registered: %v", missing, extra)
+	}
+}
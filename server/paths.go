@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"tagged-fs/action"
+)
+
+// ForbiddenPathError is panicked when a file is outside the configured roots, it is answered with 403
+type ForbiddenPathError struct {
+	Path string
+}
+
+func (e ForbiddenPathError) Error() string {
+	return fmt.Sprintf("Path '%v' is outside the allowed root directories", e.Path)
+}
+
+// isWithin reports whether path is root or one of its descendants, both must be clean absolute paths
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// realPath resolves symlinks so a link inside a root cannot point outside of it
+func realPath(path string) string {
+	abs, err := filepath.Abs(path)
+	must(err)
+
+	real, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		panic(action.NotFoundError{Message: fmt.Sprintf("File '%v' is missing from the disk", path)})
+	}
+	must(err)
+
+	return real
+}
+
+// checkAllowedPath returns the real path of path, panicking with a ForbiddenPathError if it escapes the allowed roots.
+// Every path is allowed when no roots are configured.
+func (cfg Config) checkAllowedPath(path string) string {
+	real := realPath(path)
+	if len(cfg.Roots) == 0 {
+		return real
+	}
+
+	for _, root := range cfg.Roots {
+		absRoot, err := filepath.Abs(root)
+		must(err)
+		realRoot, err := filepath.EvalSymlinks(absRoot)
+		if os.IsNotExist(err) {
+			continue
+		}
+		must(err)
+
+		if isWithin(realRoot, real) {
+			return real
+		}
+	}
+
+	panic(ForbiddenPathError{path})
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"tagged-fs/action"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+func TestIsWithin(t *testing.T) {
+	root := filepath.FromSlash("/data/root")
+	for path, within := range map[string]bool{
+		"/data/root":          true,
+		"/data/root/a/b.txt":  true,
+		"/data/root/..hidden": true,
+		"/data":               false,
+		"/data/rootless/a":    false,
+		"/data/other/a":       false,
+		"/":                   false,
+	} {
+		if isWithin(root, filepath.FromSlash(path)) != within {
+			t.Errorf("isWithin(%v, %v) should be %v", root, path, within)
+		}
+	}
+}
+
+func TestCheckAllowedPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	inside := testutil.WriteFile(t, root, "inside.txt", "")
+	secret := testutil.WriteFile(t, outside, "secret.txt", "")
+	config := Config{Roots: []string{root}}
+
+	realInside, err := filepath.EvalSymlinks(inside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := config.checkAllowedPath(inside); path != realInside {
+		t.Errorf("expected %v to be allowed as %v, got %v", inside, realInside, path)
+	}
+
+	escape := filepath.Join(root, "..", filepath.Base(outside), "secret.txt")
+	testutil.ExpectPanic[ForbiddenPathError](t, func() { config.checkAllowedPath(escape) })
+	testutil.ExpectPanic[ForbiddenPathError](t, func() { config.checkAllowedPath(secret) })
+
+	link := filepath.Join(root, "link.txt")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatal(err)
+	}
+	testutil.ExpectPanic[ForbiddenPathError](t, func() { config.checkAllowedPath(link) })
+	linkedDir := filepath.Join(root, "linked")
+	if err := os.Symlink(outside, linkedDir); err != nil {
+		t.Fatal(err)
+	}
+	testutil.ExpectPanic[ForbiddenPathError](t, func() { config.checkAllowedPath(filepath.Join(linkedDir, "secret.txt")) })
+	testutil.ExpectPanic[action.NotFoundError](t, func() { config.checkAllowedPath(filepath.Join(root, "missing.txt")) })
+
+	if path := (Config{}).checkAllowedPath(secret); path == "" {
+		t.Errorf("expected every path to be allowed without roots")
+	}
+}
+
+// Routes refuse files outside the roots, however they were added or linked
+func TestRoutesRefuseFilesOutsideTheRoots(t *testing.T) {
+	r, db_, root := newTestServer(t)
+	outside := t.TempDir()
+	secret := testutil.WriteFile(t, outside, "secret.txt", "secret")
+	link := filepath.Join(root, "link.txt")
+	if err := os.Symlink(secret, link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{secret, link, filepath.Join(root, "..", filepath.Base(outside), "secret.txt")} {
+		w := request(r, http.MethodPost, ApiPrefix+"/files", map[string]any{"path": path, "tags": []int{}}, nil)
+		expectStatus(t, w, http.StatusForbidden)
+		if problem := decode[Problem](t, w); problem.Code != CodeForbiddenPath {
+			t.Errorf("expected the code %v, got %v", CodeForbiddenPath, problem.Code)
+		}
+	}
+
+	// Files tracked by another client without roots, directly or through a link, are not served either
+	for _, path := range []string{secret, link} {
+		id := action.AddFile(db_, path, nil)
+		for _, route := range []string{"/file", "/file?download=true", "/thumbnail"} {
+			expectStatus(t, request(r, http.MethodGet, ApiPrefix+"/files/"+strconv.Itoa(id)+route, nil, nil), http.StatusForbidden)
+		}
+		expectStatus(t, request(r, http.MethodPost, ApiPrefix+"/files/"+strconv.Itoa(id)+"/open-folder", nil, nil), http.StatusForbidden)
+	}
+
+	for _, route := range []string{"/file", "/thumbnail"} {
+		expectStatus(t, request(r, http.MethodGet, ApiPrefix+"/files/999"+route, nil, nil), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"tagged-fs/action"
+	"tagged-fs/internal/testutil"
+	"testing"
+)
+
+// Edits of tags and files are guarded by the revision of their If-Match header or body, and answer the new ETag
+func TestConditionalWrites(t *testing.T) {
+	r, db_, dir := newTestServer(t)
+	tagId := action.AddTag(db_, "tag", "#000000", false, nil)
+	fileId := action.AddFile(db_, testutil.WriteFile(t, dir, "a.txt", "a"), nil)
+
+	for _, resource := range []struct {
+		name string
+		url  string
+		body func(revision *int) map[string]any
+	}{
+		{"tag", ApiPrefix + "/tags/" + strconv.Itoa(tagId), func(revision *int) map[string]any {
+			return map[string]any{"name": "renamed", "revision": revision}
+		}},
+		{"file", ApiPrefix + "/files/" + strconv.Itoa(fileId), func(revision *int) map[string]any {
+			return map[string]any{"tags": []int{tagId}, "revision": revision}
+		}},
+	} {
+		t.Run(resource.name, func(t *testing.T) {
+			etag := func() string {
+				w := request(r, http.MethodGet, resource.url, nil, nil)
+				expectStatus(t, w, http.StatusOK)
+				return w.Header().Get("ETag")
+			}
+			revision := func() int {
+				revision, err := strconv.Atoi(etag()[1 : len(etag())-1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				return revision
+			}
+			expectProblem := func(header string, body map[string]any, status int, code string) {
+				t.Helper()
+				before := etag()
+				w := request(r, http.MethodPut, resource.url, body, http.Header{"If-Match": {header}})
+				expectStatus(t, w, status)
+				testutil.Equal(t, "code", code, decode[Problem](t, w).Code)
+				testutil.Equal(t, "etag after a refused write", before, etag())
+			}
+
+			// The ETag of the current revision is matched, and the new one answered
+			current := etag()
+			w := request(r, http.MethodPut, resource.url, resource.body(nil), http.Header{"If-Match": {current}})
+			expectStatus(t, w, http.StatusNoContent)
+			testutil.Equal(t, "etag", etag(), w.Header().Get("ETag"))
+			if w.Header().Get("ETag") == current {
+				t.Errorf("expected a new ETag, got %v again", current)
+			}
+
+			// Weak ETags match too
+			w = request(r, http.MethodPut, resource.url, resource.body(nil), http.Header{"If-Match": {"W/" + etag()}})
+			expectStatus(t, w, http.StatusNoContent)
+
+			// A stale If-Match fails the precondition, even when the body has the current revision
+			stale := revision() - 1
+			expectProblem(current, resource.body(nil), http.StatusPreconditionFailed, CodeStaleRevision)
+			expectProblem(`"`+strconv.Itoa(stale)+`"`, resource.body(&[]int{revision()}[0]), http.StatusPreconditionFailed, CodeStaleRevision)
+
+			// Without If-Match, a stale body revision conflicts
+			w = request(r, http.MethodPut, resource.url, resource.body(&stale), nil)
+			expectStatus(t, w, http.StatusConflict)
+			testutil.Equal(t, "code", CodeStaleRevision, decode[Problem](t, w).Code)
+
+			// Without If-Match nor a body revision, or with *, writes are unconditional
+			w = request(r, http.MethodPut, resource.url, resource.body(nil), nil)
+			expectStatus(t, w, http.StatusNoContent)
+			w = request(r, http.MethodPut, resource.url, resource.body(&stale), http.Header{"If-Match": {"*"}})
+			expectStatus(t, w, http.StatusConflict)
+			w = request(r, http.MethodPut, resource.url, resource.body(nil), http.Header{"If-Match": {"*"}})
+			expectStatus(t, w, http.StatusNoContent)
+
+			for _, malformed := range []string{`"abc"`, "W/", `"1", "2"`} {
+				expectProblem(malformed, resource.body(nil), http.StatusBadRequest, CodeInvalidRequest)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	_ "embed"
 	"fmt"
 	"net/http"
@@ -9,11 +10,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"tagged-fs/action"
 	"tagged-fs/db"
+	"tagged-fs/thumbnail"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sqweek/dialog"
 )
 
 //go:embed favicon.png
@@ -25,18 +28,60 @@ func must(err error) {
 	}
 }
 
-func SetupGin(db_ db.DB) *gin.Engine {
+// hasIfMatch tells whether the If-Match header guards the request with a revision, If-Match: * guarding nothing
+func hasIfMatch(c *gin.Context) bool {
+	ifMatch := c.GetHeader("If-Match")
+	return ifMatch != "" && ifMatch != "*"
+}
+
+// expectedRevision reads the revision a write is based on from the If-Match header, falling back to the request body
+func expectedRevision(c *gin.Context, bodyRevision *int /* nilable */) *int {
+	if !hasIfMatch(c) {
+		return bodyRevision
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	etag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	revision, err := strconv.Atoi(etag)
+	if err != nil {
+		panic(action.InvalidError{Message: fmt.Sprintf("Invalid If-Match header: '%v'", ifMatch)})
+	}
+	return &revision
+}
+
+func setETag(c *gin.Context, revision int) {
+	c.Header("ETag", fmt.Sprintf(`"%d"`, revision))
+}
+
+// Config holds the server options that are not stored in the database
+type Config struct {
+	// Roots are the directories files can be added, served and opened from, every directory if empty
+	Roots []string
+	// RequireAuth makes every route require an API key, needed when the server is reachable from other machines
+	RequireAuth bool
+	// ThumbnailDir is where thumbnails are cached
+	ThumbnailDir string
+	// Timeouts overrides the timeout of routes like "POST /api/v1/files/search", or of every other route with "default"
+	Timeouts map[string]time.Duration
+	// Context is cancelled when the server stops, ending event streams and the background work of the server.
+	// Nil means context.Background(), the background work then running as long as the process.
+	Context context.Context
+}
+
+const dbKey = "db"
+
+// ApiPrefix is prepended to every API route, it changes when the API breaks compatibility
+const ApiPrefix = "/api/v1"
+
+// getDB returns the DB handlers must use, which is bound to a transaction during dry runs
+func getDB(c *gin.Context) db.DB {
+	return c.MustGet(dbKey).(db.DB)
+}
+
+func SetupGin(db_ db.DB, config Config) *gin.Engine {
 	r := gin.Default()
 
-	r.Use(gin.CustomRecovery(func(c *gin.Context, recovered any) {
-		if err, ok := recovered.(string); ok {
-			c.String(http.StatusInternalServerError, fmt.Sprintf("error: %s", err))
-		}
-		if err, ok := recovered.(error); ok {
-			c.String(http.StatusInternalServerError, fmt.Sprintf("error: %s", err.Error()))
-		}
-		c.AbortWithStatus(http.StatusInternalServerError)
-	}))
+	r.Use(gin.CustomRecovery(recoverProblem))
 
 	r.Use(func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
@@ -47,11 +92,28 @@ func SetupGin(db_ db.DB) *gin.Engine {
 		if originUrl.Hostname() == "localhost" || originUrl.Hostname() == "127.0.0.1" {
 			c.Header("Access-Control-Allow-Origin", origin)
 			c.Header("Access-Control-Allow-Methods", "GET,HEAD,OPTIONS,POST,PUT,DELETE")
-			c.Header("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Origin, Accept, X-Requested-With, Content-Type, Access-Control-Request-Method, Access-Control-Request-Headers")
+			c.Header("Access-Control-Allow-Headers", "Access-Control-Allow-Headers, Origin, Accept, X-Requested-With, Content-Type, Access-Control-Request-Method, Access-Control-Request-Headers, If-Match, Authorization, X-API-Key")
+			c.Header("Access-Control-Expose-Headers", "ETag, Location")
 		}
 
 	})
 
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	events := action.NewEventBus(db_)
+	go events.Run(ctx, time.Second)
+	go action.NewWebhookDispatcher(db_, events).Run(ctx, 5*time.Second)
+	db_ = events.Notify(db_)
+
+	r.Use(func(c *gin.Context) {
+		c.Set(dbKey, db_)
+	})
+	r.Use(timeoutMiddleware(config))
+	r.Use(authMiddleware(config))
+	dryRun := dryRunMiddleware()
+
 	r.OPTIONS("/*cors", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
@@ -60,170 +122,345 @@ func SetupGin(db_ db.DB) *gin.Engine {
 		c.Data(http.StatusOK, "image/png", favicon)
 	})
 
-	r.POST("/open-folder", func(c *gin.Context) {
-		var data struct {
-			Path string `json:"path" binding:"required"`
-		}
-		err := c.BindJSON(&data)
-		must(err)
+	api := r.Group(ApiPrefix)
 
-		if runtime.GOOS == "windows" {
-			// ignore err, explorer always returns 1
-			exec.Command("explorer", "/select,"+data.Path).Run()
-		}
-		if runtime.GOOS == "linux" {
-			err = exec.Command("xdg-open", filepath.Dir(data.Path)).Run()
-			must(err)
-		}
+	api.GET("/openapi.json", serveOpenApi)
 
-		c.Status(http.StatusNoContent)
-	})
+	api.GET("/events", eventsHandler(events, ctx.Done()))
 
-	r.POST("/file-picker", func(c *gin.Context) {
-		filename, err := dialog.File().Load()
-		must(err)
+	api.POST("/graphql", dryRun, graphqlHandler(config))
 
-		c.String(http.StatusOK, filename)
+	// Tag routes
+	api.GET("/tags", func(c *gin.Context) {
+		c.JSON(http.StatusOK, action.ListTags(getDB(c)))
 	})
 
-	// Tag routes
-	r.GET("/tags", func(c *gin.Context) {
-		c.JSON(http.StatusOK, action.ListTags(db_))
+	api.GET("/tags/:id", func(c *gin.Context) {
+		tag := action.GetTag(getDB(c), idParam(c))
+
+		setETag(c, tag.Revision)
+		c.JSON(http.StatusOK, tag)
 	})
 
-	r.POST("/tags", func(c *gin.Context) {
+	api.POST("/tags", dryRun, func(c *gin.Context) {
 		var data struct {
 			Name      string `json:"name" binding:"required"`
 			Color     string `json:"color" binding:"required"`
+			Private   bool   `json:"private"`
 			ParentIds []int  `json:"parentIds"`
 		}
-		err := c.BindJSON(&data)
-		must(err)
+		bindJSON(c, &data)
 
-		action.AddTag(db_, data.Name, data.Color, data.ParentIds)
+		db_ := getDB(c)
+		tag := action.GetTag(db_, action.AddTag(db_, data.Name, data.Color, data.Private, data.ParentIds))
 
-		c.Status(http.StatusNoContent)
+		c.Header("Location", fmt.Sprintf("%v/tags/%v", ApiPrefix, tag.Id))
+		setETag(c, tag.Revision)
+		c.JSON(http.StatusCreated, tag)
 	})
 
-	r.PUT("/tags/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		if idStr == "" {
-			panic("Missing id")
-		}
-		id, err := strconv.Atoi(idStr)
-		must(err)
+	api.PUT("/tags/:id", dryRun, func(c *gin.Context) {
+		id := idParam(c)
 
 		var data struct {
 			Name      *string `json:"name"`
 			Color     *string `json:"color"`
+			Private   *bool   `json:"private"`
 			ParentIds *[]int  `json:"parentIds"`
+			Revision  *int    `json:"revision"`
 		}
-		err = c.BindJSON(&data)
-		must(err)
+		bindJSON(c, &data)
 
-		action.EditTag(db_, id, data.Name, data.Color, data.ParentIds)
+		revision := action.EditTag(getDB(c), id, expectedRevision(c, data.Revision), data.Name, data.Color, data.Private, data.ParentIds)
 
+		setETag(c, revision)
 		c.Status(http.StatusNoContent)
 	})
 
-	r.PUT("/tags/order", func(c *gin.Context) {
+	api.PUT("/tag-order", dryRun, func(c *gin.Context) {
 		var ids []int
-		err := c.BindJSON(&ids)
-		must(err)
+		bindJSON(c, &ids)
 
-		db_.UpdateTagsOrder(ids)
+		action.ReorderTags(getDB(c), ids)
 
 		c.Status(http.StatusNoContent)
 	})
 
-	r.DELETE("/tags/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		if idStr == "" {
-			panic("Missing id")
-		}
-		id, err := strconv.Atoi(idStr)
-		must(err)
+	api.DELETE("/tags/:id", dryRun, func(c *gin.Context) {
+		id := idParam(c)
 
-		action.RmTag(db_, id)
+		action.RmTag(getDB(c), id)
 
 		c.Status(http.StatusNoContent)
 	})
 
 	// File routes
-	r.GET("/files", func(c *gin.Context) {
-		c.JSON(http.StatusOK, action.ListFiles(db_, nil, nil))
+	api.GET("/files", func(c *gin.Context) {
+		c.JSON(http.StatusOK, action.ListFiles(getDB(c), db.FileSearch{}))
 	})
 
-	r.GET("/files/:id/file", func(c *gin.Context) {
-		idStr := c.Param("id")
-		if idStr == "" {
-			panic("Missing id")
+	fileContent := func(c *gin.Context) {
+		id := idParam(c)
+
+		var query struct {
+			Download bool `form:"download"`
+		}
+		bindQuery(c, &query)
+
+		path := config.checkAllowedPath(action.FilePath(getDB(c), id))
+		serveContent(c, path, query.Download)
+	}
+	api.GET("/files/:id/file", fileContent)
+	api.HEAD("/files/:id/file", fileContent)
+
+	thumbnails := action.NewThumbnailCache(config.ThumbnailDir)
+	api.GET("/files/:id/thumbnail", func(c *gin.Context) {
+		id := idParam(c)
+
+		query := struct {
+			Size int `form:"size"`
+		}{thumbnail.DefaultSize}
+		bindQuery(c, &query)
+
+		db_ := getDB(c)
+		path := config.checkAllowedPath(action.FilePath(db_, id))
+		serveThumbnail(c, action.Thumbnail(db_, thumbnails, path, query.Size))
+	})
+
+	api.POST("/files/:id/open-folder", func(c *gin.Context) {
+		id := idParam(c)
+
+		path := config.checkAllowedPath(action.FilePath(getDB(c), id))
+
+		if runtime.GOOS == "windows" {
+			// ignore err, explorer always returns 1
+			exec.Command("explorer", "/select,"+path).Run()
+		}
+		if runtime.GOOS == "linux" {
+			err := exec.Command("xdg-open", filepath.Dir(path)).Run()
+			must(err)
 		}
-		id, err := strconv.Atoi(idStr)
-		must(err)
 
-		path := db_.FilePathFromId(id)
-		c.File(path)
+		c.Status(http.StatusNoContent)
 	})
 
-	r.POST("/files/search", func(c *gin.Context) {
+	api.POST("/files/search", func(c *gin.Context) {
 		var data struct {
-			Name *string `json:"name" binding:"-"`
-			Tags []int   `json:"tags" binding:"-"`
+			Name     *string           `json:"name" binding:"-"`
+			Tags     []int             `json:"tags" binding:"-"`
+			Metadata map[string]string `json:"metadata" binding:"-"`
+			Kinds    []string          `json:"kinds" binding:"-"`
+			Text     *string           `json:"text" binding:"-"`
+			Fuzzy    bool              `json:"fuzzy" binding:"-"`
 		}
 
 		if c.Request.ContentLength > 0 {
-			err := c.BindJSON(&data)
-			must(err)
+			bindJSON(c, &data)
 		}
 
-		c.JSON(http.StatusOK, action.ListFiles(db_, data.Name, data.Tags))
+		c.JSON(http.StatusOK, action.ListFiles(getDB(c), db.FileSearch{Name: data.Name, TagIds: data.Tags, Metadata: data.Metadata, Kinds: data.Kinds, Text: data.Text, Fuzzy: data.Fuzzy}))
 	})
 
-	r.POST("/files", func(c *gin.Context) {
+	api.GET("/files/:id", func(c *gin.Context) {
+		file := action.GetFile(getDB(c), idParam(c))
+
+		setETag(c, file.Revision)
+		c.JSON(http.StatusOK, file)
+	})
+
+	api.POST("/files", dryRun, func(c *gin.Context) {
 		var data struct {
 			Path string `json:"path" binding:"required"`
 			Tags []int  `json:"tags" binding:"required"`
 		}
-		err := c.BindJSON(&data)
-		must(err)
+		bindJSON(c, &data)
 
-		action.AddFile(db_, data.Path, data.Tags)
+		config.checkAllowedPath(data.Path)
+		db_ := getDB(c)
+		file := action.GetFile(db_, action.AddFile(db_, data.Path, data.Tags))
+
+		c.Header("Location", fmt.Sprintf("%v/files/%v", ApiPrefix, file.Id))
+		setETag(c, file.Revision)
+		c.JSON(http.StatusCreated, file)
+	})
+
+	api.PUT("/files/:id", dryRun, func(c *gin.Context) {
+		id := idParam(c)
+
+		var data struct {
+			Tags     []int `json:"tags" binding:"required"`
+			Revision *int  `json:"revision"`
+		}
+		bindJSON(c, &data)
+
+		revision := action.EditFile(getDB(c), id, expectedRevision(c, data.Revision), data.Tags)
+
+		setETag(c, revision)
+		c.Status(http.StatusNoContent)
+	})
+
+	api.DELETE("/files/:id", dryRun, func(c *gin.Context) {
+		id := idParam(c)
+
+		action.RmFile(getDB(c), id)
 
 		c.Status(http.StatusNoContent)
 	})
 
-	r.PUT("/files/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		if idStr == "" {
-			panic("Missing id")
+	// History routes
+	api.GET("/history", func(c *gin.Context) {
+		var query struct {
+			Limit int `form:"limit,default=50"`
 		}
-		id, err := strconv.Atoi(idStr)
-		must(err)
+		bindQuery(c, &query)
+
+		c.JSON(http.StatusOK, action.History(getDB(c), query.Limit))
+	})
+
+	api.POST("/history/undo", dryRun, func(c *gin.Context) {
+		c.JSON(http.StatusOK, action.Undo(getDB(c)))
+	})
+
+	api.POST("/history/redo", dryRun, func(c *gin.Context) {
+		c.JSON(http.StatusOK, action.Redo(getDB(c)))
+	})
+
+	// Trash routes
+	api.GET("/trash", func(c *gin.Context) {
+		tags, files := action.ListTrash(getDB(c))
+		c.JSON(http.StatusOK, gin.H{"tags": tags, "files": files})
+	})
+
+	api.POST("/trash/tags/:id/restore", dryRun, func(c *gin.Context) {
+		id := idParam(c)
+
+		action.RestoreTag(getDB(c), id)
+
+		c.Status(http.StatusNoContent)
+	})
+
+	api.POST("/trash/files/:id/restore", dryRun, func(c *gin.Context) {
+		id := idParam(c)
+
+		action.RestoreFile(getDB(c), id)
+
+		c.Status(http.StatusNoContent)
+	})
+
+	api.DELETE("/trash/tags/:id", dryRun, func(c *gin.Context) {
+		id := idParam(c)
+
+		action.PurgeTag(getDB(c), id)
+
+		c.Status(http.StatusNoContent)
+	})
+
+	api.DELETE("/trash/files/:id", dryRun, func(c *gin.Context) {
+		id := idParam(c)
+
+		action.PurgeFile(getDB(c), id)
 
+		c.Status(http.StatusNoContent)
+	})
+
+	api.DELETE("/trash", dryRun, func(c *gin.Context) {
+		var query struct {
+			OlderThanDays int `form:"olderThanDays"`
+		}
+		bindQuery(c, &query)
+
+		tags, files := action.PurgeTrash(getDB(c), time.Duration(query.OlderThanDays)*24*time.Hour)
+		c.JSON(http.StatusOK, gin.H{"tags": tags, "files": files})
+	})
+
+	api.GET("/trash/retention", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"days": action.TrashRetentionDays(getDB(c))})
+	})
+
+	api.PUT("/trash/retention", dryRun, func(c *gin.Context) {
 		var data struct {
-			Tags []int `json:"tags" binding:"required"`
+			Days *int `json:"days" binding:"required"`
 		}
-		err = c.BindJSON(&data)
-		must(err)
+		bindJSON(c, &data)
 
-		action.EditFile(db_, id, data.Tags)
+		action.SetTrashRetentionDays(getDB(c), *data.Days)
 
 		c.Status(http.StatusNoContent)
 	})
 
-	r.DELETE("/files/:id", func(c *gin.Context) {
-		idStr := c.Param("id")
-		if idStr == "" {
-			panic("Missing id")
+	// Audit routes
+	api.GET("/audit", func(c *gin.Context) {
+		var query struct {
+			File    *int       `form:"file"`
+			Tag     *int       `form:"tag"`
+			Since   *time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+			Until   *time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+			Origin  *string    `form:"origin"`
+			Page    int        `form:"page,default=1"`
+			PerPage int        `form:"perPage,default=50"`
 		}
-		id, err := strconv.Atoi(idStr)
-		must(err)
+		bindQuery(c, &query)
 
-		action.RmFile(db_, id)
+		if query.Page < 1 {
+			panic(action.InvalidError{Message: fmt.Sprintf("Invalid page: '%v'", query.Page)})
+		}
+
+		filter := db.AuditFilter{FileId: query.File, TagId: query.Tag, Since: query.Since, Until: query.Until, Origin: query.Origin}
+		entries, total := action.SearchAudit(getDB(c), filter, query.PerPage, (query.Page-1)*query.PerPage)
+
+		c.JSON(http.StatusOK, gin.H{
+			"entries": entries,
+			"total":   total,
+			"page":    query.Page,
+			"perPage": query.PerPage,
+		})
+	})
+
+	// Webhook routes
+	api.GET("/webhooks", func(c *gin.Context) {
+		c.JSON(http.StatusOK, action.ListWebhooks(getDB(c)))
+	})
+
+	api.GET("/webhooks/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, action.GetWebhook(getDB(c), idParam(c)))
+	})
+
+	api.POST("/webhooks", dryRun, func(c *gin.Context) {
+		var data struct {
+			Url    string   `json:"url" binding:"required"`
+			Events []string `json:"events" binding:"required"`
+			Secret string   `json:"secret"`
+		}
+		bindJSON(c, &data)
+
+		webhook := action.AddWebhook(getDB(c), data.Url, data.Events, data.Secret)
+
+		c.Header("Location", fmt.Sprintf("%v/webhooks/%v", ApiPrefix, webhook.Id))
+		c.JSON(http.StatusCreated, webhook)
+	})
+
+	api.DELETE("/webhooks/:id", dryRun, func(c *gin.Context) {
+		action.RmWebhook(getDB(c), idParam(c))
 
 		c.Status(http.StatusNoContent)
 	})
 
+	api.GET("/webhooks/:id/deliveries", func(c *gin.Context) {
+		var query struct {
+			Limit int `form:"limit,default=50"`
+		}
+		bindQuery(c, &query)
+
+		c.JSON(http.StatusOK, action.WebhookDeliveries(getDB(c), idParam(c), query.Limit))
+	})
+
+	api.POST("/webhook-deliveries/:id/redeliver", dryRun, func(c *gin.Context) {
+		action.RedeliverWebhook(getDB(c), idParam(c))
+
+		c.Status(http.StatusNoContent)
+	})
+
+	checkTimeouts(r, config)
+
 	return r
 }
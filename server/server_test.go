@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"tagged-fs/db"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestServer returns a server over a new database, stopped when the test ends, and a directory for its files which is its only root
+func newTestServer(t *testing.T) (*gin.Engine, db.DB, string) {
+	dir := t.TempDir()
+	db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return SetupGin(db_, Config{Roots: []string{dir}, ThumbnailDir: t.TempDir(), Context: ctx}), db_, dir
+}
+
+// request sends a request to r, with body as JSON unless it is nil
+func request(r http.Handler, method string, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			panic(err)
+		}
+		reader = strings.NewReader(string(data))
+	}
+	req := httptest.NewRequest(method, path, reader)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decode reads the JSON body of a response into a T
+func decode[T any](t *testing.T, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("invalid response %v: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %v, got %v: %v", status, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultTimeout bounds the database work of a request when Config.Timeouts does not set its route's
+const DefaultTimeout = 30 * time.Second
+
+// defaultTimeouts are the routes whose timeout differs from DefaultTimeout, 0 meaning none
+var defaultTimeouts = map[string]time.Duration{
+	"GET " + ApiPrefix + "/events":   0,
+	"POST " + ApiPrefix + "/graphql": time.Minute,
+}
+
+// timeout returns the timeout of a route like "POST /api/v1/files/search", Config.Timeouts taking precedence over the defaults
+func (config Config) timeout(route string) time.Duration {
+	if timeout, ok := config.Timeouts[route]; ok {
+		return timeout
+	}
+	if timeout, ok := defaultTimeouts[route]; ok {
+		return timeout
+	}
+	if timeout, ok := config.Timeouts["default"]; ok {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+// timeoutMiddleware cancels the queries of a request when its client disconnects or its route's timeout is over.
+// Cancelled requests answer 503 Service Unavailable, and their transactions are rolled back.
+func timeoutMiddleware(config Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if timeout := config.timeout(c.Request.Method + " " + c.FullPath()); timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		c.Set(dbKey, getDB(c).WithContext(ctx))
+		c.Next()
+	}
+}
+
+// checkTimeouts panics if Config.Timeouts sets the timeout of a route that does not exist
+func checkTimeouts(r *gin.Engine, config Config) {
+	routes := map[string]bool{"default": true}
+	for _, info := range r.Routes() {
+		routes[info.Method+" "+info.Path] = true
+	}
+
+	unknown := make([]string, 0)
+	for route := range config.Timeouts {
+		if !routes[route] {
+			unknown = append(unknown, route)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		panic(fmt.Sprintf("Timeouts are set for routes that do not exist: %v", unknown))
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"tagged-fs/action"
+	"tagged-fs/db"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteTimeouts(t *testing.T) {
+	config := Config{Timeouts: map[string]time.Duration{
+		"default":                             time.Second,
+		"POST " + ApiPrefix + "/files/search": 5 * time.Second,
+		"POST " + ApiPrefix + "/graphql":      0,
+	}}
+	for route, timeout := range map[string]time.Duration{
+		"GET " + ApiPrefix + "/tags":          time.Second,
+		"POST " + ApiPrefix + "/files/search": 5 * time.Second,
+		"POST " + ApiPrefix + "/graphql":      0,
+		"GET " + ApiPrefix + "/events":        0,
+	} {
+		if config.timeout(route) != timeout {
+			t.Errorf("expected the timeout of %v to be %v, got %v", route, timeout, config.timeout(route))
+		}
+	}
+	if (Config{}).timeout("GET "+ApiPrefix+"/tags") != DefaultTimeout {
+		t.Errorf("expected routes to default to %v", DefaultTimeout)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected the timeout of a route that does not exist to be refused")
+		}
+	}()
+	SetupGin(db.Init(filepath.Join(t.TempDir(), "db.sqlite3")), Config{Timeouts: map[string]time.Duration{"GET /missing": time.Second}})
+}
+
+// A request whose context ends while it is writing answers 503, and its changes are rolled back
+func TestTimeoutRollsBack(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		timeout time.Duration
+		cancel  bool
+		code    string
+	}{
+		{"timeout", 100 * time.Millisecond, false, CodeTimeout},
+		{"canceled", 0, true, CodeCanceled},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db_ := db.Init(filepath.Join(t.TempDir(), "db.sqlite3"))
+			r := SetupGin(db_, Config{Timeouts: map[string]time.Duration{"default": test.timeout}})
+			// Adds a tag, then another once the request's context is done
+			r.POST("/slow", func(c *gin.Context) {
+				tx := getDB(c).Begin()
+				defer tx.Rollback()
+
+				action.AddTag(tx, "first", "#000000", false, nil)
+				<-tx.Context().Done()
+				action.AddTag(tx, "second", "#000000", false, nil)
+				tx.Commit()
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/slow", nil)
+			if test.cancel {
+				// Like a client disconnecting
+				ctx, cancel := context.WithCancel(req.Context())
+				time.AfterFunc(100*time.Millisecond, cancel)
+				req = req.WithContext(ctx)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			expectStatus(t, w, http.StatusServiceUnavailable)
+			if problem := decode[map[string]any](t, w); problem["code"] != test.code {
+				t.Errorf("expected the code %v, got %v", test.code, problem["code"])
+			}
+			if tags := action.ListTags(db_); len(tags) != 0 {
+				t.Errorf("expected the tags to be rolled back, got %v", tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Package thumbnail renders small JPEG previews of images, and of videos when ffmpeg is installed
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strings"
+	"tagged-fs/metadata"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes are the bounding boxes thumbnails can be rendered in, limited so a cache holds few versions of each file
+var Sizes = []int{64, 128, 256, 512, 1024}
+
+const DefaultSize = 256
+
+const jpegQuality = 85
+
+// maxPixels is the size of the largest image decoded, which takes about 4 bytes per pixel in memory
+const maxPixels = 50_000_000
+
+// ErrUnsupported is returned for files that are neither decodable images nor videos ffmpeg can read
+var ErrUnsupported = errors.New("no thumbnail can be made for this kind of file")
+
+func IsSize(size int) bool {
+	for _, s := range Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate returns a JPEG of the file fitting in a size x size square, images are never enlarged
+func Generate(path string, size int) ([]byte, error) {
+	t, err := metadata.MimeType(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var img image.Image
+	orientation := 1
+	switch {
+	case strings.HasPrefix(t, "image/"):
+		img, orientation, err = decodeImage(path)
+		if err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(t, "video/"):
+		img, err = videoFrame(path)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupported
+	}
+
+	thumb := orient(resize(img, size), orientation)
+
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: jpegQuality})
+	return out.Bytes(), err
+}
+
+// decodeImage returns the image at path and its EXIF orientation. Only its header is read before its size is checked.
+func decodeImage(path string) (image.Image, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	// EXIF is at the start of JPEGs
+	head := make([]byte, 128<<10)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+
+	config, _, err := image.DecodeConfig(file)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, 0, ErrUnsupported
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, 0, fmt.Errorf("%w: %vx%v pixels is too large", ErrUnsupported, config.Width, config.Height)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, metadata.Orientation(head[:n]), nil
+}
+
+// fit returns the dimensions of a w x h image scaled down to fit in a size x size square
+func fit(w int, h int, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w >= h {
+		return size, max(1, h*size/w)
+	}
+	return max(1, w*size/h), size
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// resize scales img to fit in size, over a white background as JPEG has no transparency
+func resize(img image.Image, size int) *image.RGBA {
+	src := img.Bounds()
+	w, h := fit(src.Dx(), src.Dy(), size)
+
+	// Large images are first halved with a cheap filter, the good one being slow on many pixels
+	if src.Dx() > 4*w && src.Dy() > 4*h {
+		half := image.NewRGBA(image.Rect(0, 0, 2*w, 2*h))
+		draw.Draw(half, half.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.ApproxBiLinear.Scale(half, half.Bounds(), img, src, draw.Over, nil)
+		img = half
+		src = half.Bounds()
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,70 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePng writes a w x h PNG to a temporary file and returns its path. Only its header claims the size, so huge
+// images take no memory until decoded.
+func writePng(t *testing.T, w int, h int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// The IHDR chunk follows the 8 byte signature: length, type, width, height, 5 more bytes, then its CRC
+	binary.BigEndian.PutUint32(data[16:], uint32(w))
+	binary.BigEndian.PutUint32(data[20:], uint32(h))
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGenerateFitsTheSize(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 400, 100))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "image.png")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	thumb, err := Generate(path, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 64 || config.Height != 16 {
+		t.Errorf("expected a 64x16 thumbnail, got %vx%v", config.Width, config.Height)
+	}
+}
+
+// Images over maxPixels are refused from their header, before decoding them could exhaust the memory
+func TestGenerateRefusesHugeImages(t *testing.T) {
+	_, err := Generate(writePng(t, 100_000, 100_000), DefaultSize)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expected the size to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ffmpegTimeout stops ffmpeg on files it cannot seek in quickly
+const ffmpegTimeout = 30 * time.Second
+
+// HasFfmpeg reports whether ffmpeg is on the PATH, without it videos have no thumbnail
+func HasFfmpeg() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// videoFrame extracts a frame one second into a video, or its first frame if it is shorter
+func videoFrame(path string) (image.Image, error) {
+	if !HasFfmpeg() {
+		return nil, ErrUnsupported
+	}
+
+	for _, at := range []string{"1", "0"} {
+		frame, err := ffmpegFrame(path, at)
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil {
+			return frame, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: ffmpeg found no frame", ErrUnsupported)
+}
+
+// ffmpegFrame returns the frame at the given second, or nil if the video ends before it
+func ffmpegFrame(path string, at string) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-ss", at, "-i", path, "-frames:v", "1", "-f", "image2pipe", "-c:v", "png", "-")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%w: ffmpeg: %v", ErrUnsupported, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, nil
+	}
+
+	frame, _, err := image.Decode(&stdout)
+	return frame, err
+}
@@ -3,6 +3,7 @@ import { AppContextProvider } from "./AppContext"
 import { Tabs } from "./components/Tabs"
 import { Files } from "./Files"
 import { Tags } from "./Tags"
+import { Trash } from "./Trash"
 
 export function App(): JSX.Element {
 	return (
@@ -17,6 +18,10 @@ export function App(): JSX.Element {
 						label: "Tags",
 						children: <Tags />,
 					},
+					{
+						label: "Trash",
+						children: <Trash />,
+					},
 				]}
 			/>
 		</AppContextProvider>
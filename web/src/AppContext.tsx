@@ -1,11 +1,13 @@
-import { createContext, JSX, useContext, Accessor, createResource, createMemo } from "solid-js"
-import { ApiTag, fetchAllTags } from "./api"
+import { createContext, JSX, useContext, Accessor, createResource, createMemo, createSignal, onCleanup } from "solid-js"
+import { ApiEvent, ApiTag, fetchAllTags, subscribeEvents } from "./api"
 
 export type TagWithParent = ApiTag & { parents: TagWithParent[] }
 
 export type AppContext = {
 	tags: Accessor<TagWithParent[]>
 	refreshTags: () => Promise<void>
+	// lastEvent is the latest change made by any client, including this one
+	lastEvent: Accessor<ApiEvent | undefined>
 }
 
 const context = createContext<AppContext>()
@@ -13,6 +15,13 @@ const context = createContext<AppContext>()
 export function AppContextProvider(props: { children: JSX.Element }): JSX.Element {
 	const [tags, { refetch: refetchTags }] = createResource(fetchAllTags, { initialValue: [] })
 
+	const [lastEvent, setLastEvent] = createSignal<ApiEvent>()
+	const unsubscribe = subscribeEvents((event) => {
+		setLastEvent(event)
+		if (event.entity !== "file") void refetchTags()
+	})
+	onCleanup(unsubscribe)
+
 	const tagsWithParent = createMemo(() => {
 		const tagsCopy = tags().map((t) => ({ ...t, parents: [] })) as TagWithParent[]
 
@@ -35,6 +44,7 @@ export function AppContextProvider(props: { children: JSX.Element }): JSX.Elemen
 			value={{
 				tags: tagsWithParent,
 				refreshTags: refetchTags as unknown as () => Promise<void>,
+				lastEvent,
 			}}>
 			{props.children}
 		</context.Provider>
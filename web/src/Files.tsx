@@ -1,7 +1,15 @@
-import { FaSolidFile, FaSolidFloppyDisk, FaSolidFolder, FaSolidPen, FaSolidPlus, FaSolidTrash } from "solid-icons/fa"
+import {
+	FaSolidFile,
+	FaSolidFloppyDisk,
+	FaSolidFolder,
+	FaSolidPen,
+	FaSolidPlus,
+	FaSolidRotateLeft,
+	FaSolidTrash,
+} from "solid-icons/fa"
 import { createEffect, createResource, createSignal, For, JSX } from "solid-js"
 import { createStore } from "solid-js/store"
-import { ApiFile, createFile, deleteFile, fileSrc, openFolder, pickFile, searchFiles, updateFile } from "./api"
+import { ApiFile, createFile, deleteFile, openFolder, pickFile, searchFiles, thumbnailSrc, undo, updateFile } from "./api"
 import { useAppContext } from "./AppContext"
 import { Button } from "./components/Button"
 import { Confirm } from "./components/Confirm"
@@ -12,7 +20,8 @@ import { Select } from "./components/Select"
 import { Tag } from "./components/Tag"
 import { Tooltip } from "./components/Tooltip"
 
-const SUPPORTED_IMAGE_EXTENSIONS = [".jpg", ".jpeg", ".png", ".gif", ".webp"]
+// Videos only have a thumbnail when the server has ffmpeg, the icon is shown otherwise
+const THUMBNAIL_KINDS = ["image", "video"]
 
 type FileModalProps = {
 	file?: ApiFile
@@ -30,7 +39,7 @@ function FileModal(props: FileModalProps) {
 
 	const save = async (): Promise<void> => {
 		if (props.file != null) {
-			await updateFile(props.file.id, { tags: tagIds() })
+			await updateFile(props.file.id, { tags: tagIds() }, props.file.revision)
 		} else {
 			await createFile(path(), tagIds())
 		}
@@ -107,10 +116,11 @@ type ThumbnailProps = {
 	file: ApiFile
 }
 function Thumbnail(props: ThumbnailProps): JSX.Element {
+	const [failed, setFailed] = createSignal(false)
 	return (
 		<>
-			{SUPPORTED_IMAGE_EXTENSIONS.some((ext) => props.file.path.endsWith(ext)) ? (
-				<img class="object-contain w-8 h-8" src={fileSrc(props.file.id)} />
+			{!failed() && THUMBNAIL_KINDS.includes(props.file.kind) ? (
+				<img class="object-contain w-8 h-8" src={thumbnailSrc(props.file.id, 64)} onError={() => setFailed(true)} />
 			) : (
 				<span class="text-indigo-200">
 					<FaSolidFile size={32} />
@@ -127,7 +137,7 @@ type FileItemProps = {
 }
 function FileItem(props: FileItemProps): JSX.Element {
 	const openFolder_ = async (): Promise<void> => {
-		await openFolder(props.file.path)
+		await openFolder(props.file.id)
 	}
 	const delete_ = async (): Promise<void> => {
 		await deleteFile(props.file.id)
@@ -175,7 +185,7 @@ export function Files(): JSX.Element {
 	const [search, setSearch] = createSignal("")
 	const [tagIds, setTagIds] = createSignal([] as number[])
 
-	const { tags } = useAppContext()
+	const { tags, refreshTags, lastEvent } = useAppContext()
 
 	const [files, { refetch: refetchFiles }] = createResource(
 		() => ({ search: search(), tagIds: tagIds() }),
@@ -186,9 +196,21 @@ export function Files(): JSX.Element {
 		}
 	)
 
+	// Files show their tags, so any change can affect them
+	createEffect(() => {
+		if (lastEvent() != null) void refetchFiles()
+	})
+
+	const undo_ = async (): Promise<void> => {
+		await undo()
+
+		await refreshTags()
+		await refetchFiles()
+	}
+
 	return (
 		<>
-			<div class="grid grid-cols-[auto_1fr_1fr] items-center gap-x-1">
+			<div class="grid grid-cols-[auto_auto_1fr_1fr] items-center gap-x-1">
 				<Tooltip content="Add new file">
 					<Button
 						color="primary"
@@ -197,6 +219,10 @@ export function Files(): JSX.Element {
 					/>
 				</Tooltip>
 
+				<Tooltip content="Undo last change">
+					<Button color="neutral" icon={<FaSolidRotateLeft />} onClick={undo_} />
+				</Tooltip>
+
 				<Input
 					placeholder="Search by name"
 					value={search()}
@@ -66,7 +66,7 @@ function TagModal(props: TagModalProps) {
 
 	const save = async (): Promise<void> => {
 		if (props.tag != null) {
-			await updateTag(props.tag.id, { name: name(), color: color(), parentIds: parentIds() })
+			await updateTag(props.tag.id, { name: name(), color: color(), parentIds: parentIds() }, props.tag.revision)
 		} else {
 			await createTag(name(), color(), parentIds())
 		}
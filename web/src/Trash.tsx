@@ -0,0 +1,78 @@
+import { FaSolidFile, FaSolidRotateLeft, FaSolidTrash } from "solid-icons/fa"
+import { createResource, For, JSX } from "solid-js"
+import { fetchTrash, purgeTrash, restoreFile, restoreTag } from "./api"
+import { useAppContext } from "./AppContext"
+import { Button } from "./components/Button"
+import { Confirm } from "./components/Confirm"
+import { Tag } from "./components/Tag"
+import { Tooltip } from "./components/Tooltip"
+
+export function Trash(): JSX.Element {
+	const { refreshTags } = useAppContext()
+
+	const [trash, { refetch }] = createResource(fetchTrash, { initialValue: { tags: [], files: [] } })
+
+	const restoreTag_ = async (tagId: number): Promise<void> => {
+		await restoreTag(tagId)
+
+		await refreshTags()
+		await refetch()
+	}
+	const restoreFile_ = async (id: number): Promise<void> => {
+		await restoreFile(id)
+
+		await refetch()
+	}
+	const purge = async (): Promise<void> => {
+		await purgeTrash()
+
+		await refetch()
+	}
+
+	return (
+		<>
+			<div class="flex flex-row justify-end">
+				<Confirm content="Permanently delete everything in the trash ?" onConfirm={purge}>
+					<Button color="danger" icon={<FaSolidTrash />}>
+						Empty trash
+					</Button>
+				</Confirm>
+			</div>
+
+			<ul>
+				<For each={trash().tags}>
+					{(tag) => (
+						<li class="grid grid-cols-[1fr_1fr_auto] items-center p-2 even:bg-indigo-100">
+							<Tag color={tag.color}>{tag.name}</Tag>
+							<span>{new Date(tag.deletedAt!).toLocaleString()}</span>
+							<Tooltip content="Restore tag">
+								<Button
+									color="primary"
+									icon={<FaSolidRotateLeft />}
+									onClick={() => restoreTag_(tag.id)}
+								/>
+							</Tooltip>
+						</li>
+					)}
+				</For>
+				<For each={trash().files}>
+					{(file) => (
+						<li class="grid grid-cols-[1fr_1fr_auto] items-center p-2 even:bg-indigo-100">
+							<span class="flex flex-row items-center gap-x-1">
+								<FaSolidFile /> {file.name}
+							</span>
+							<span>{new Date(file.deletedAt!).toLocaleString()}</span>
+							<Tooltip content="Restore file">
+								<Button
+									color="primary"
+									icon={<FaSolidRotateLeft />}
+									onClick={() => restoreFile_(file.id)}
+								/>
+							</Tooltip>
+						</li>
+					)}
+				</For>
+			</ul>
+		</>
+	)
+}
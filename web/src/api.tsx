@@ -9,12 +9,18 @@ try {
 } catch (e) {
 	console.error(e)
 }
+const API_BASE = `${API_URL}/api/v1`
 
 export type ApiTag = {
 	id: number
 	name: string
 	color: string
 	parentIds: number[]
+	ownerId: number | null
+	private: boolean
+	revision: number
+	updatedAt: string
+	deletedAt?: string
 }
 
 export type ApiFile = {
@@ -22,47 +28,129 @@ export type ApiFile = {
 	path: string
 	name: string
 	tags: ApiTag[]
+	revision: number
+	updatedAt: string
+	deletedAt?: string
+	mimeType: string
+	kind: "image" | "video" | "audio" | "document" | "archive" | "other"
+	metadata: Record<string, string>
+}
+
+// Body of every error response
+export type ApiProblem = {
+	type: string
+	title: string
+	status: number
+	detail: string
+	code: string
+}
+
+// Sent as If-Match so the server rejects writes based on a stale revision
+function ifMatch(revision?: number): { headers?: Record<string, string> } {
+	return revision != null ? { headers: { "If-Match": `"${revision}"` } } : {}
 }
 
 export async function fetchAllTags(): Promise<ApiTag[]> {
-	return (await axios.get<ApiTag[]>(`${API_URL}/tags`)).data
+	return (await axios.get<ApiTag[]>(`${API_BASE}/tags`)).data
 }
-export async function createTag(name: string, color: string, parentIds: number[]): Promise<void> {
-	await axios.post(`${API_URL}/tags`, { name, color, parentIds })
+export async function fetchTag(tagId: number): Promise<ApiTag> {
+	return (await axios.get<ApiTag>(`${API_BASE}/tags/${tagId}`)).data
+}
+export async function createTag(name: string, color: string, parentIds: number[]): Promise<ApiTag> {
+	return (await axios.post<ApiTag>(`${API_BASE}/tags`, { name, color, parentIds })).data
 }
 export async function updateTag(
 	tagId: number,
-	data: { name?: string; color?: string; parentIds?: number[] }
+	data: { name?: string; color?: string; private?: boolean; parentIds?: number[] },
+	revision?: number
 ): Promise<void> {
-	await axios.put(`${API_URL}/tags/${tagId}`, data)
+	await axios.put(`${API_BASE}/tags/${tagId}`, data, ifMatch(revision))
 }
 export async function updateTagsOrder(ids: number[]): Promise<void> {
-	await axios.put(`${API_URL}/tags/order`, ids)
+	await axios.put(`${API_BASE}/tag-order`, ids)
 }
 export async function deleteTag(tagId: number): Promise<void> {
-	await axios.delete(`${API_URL}/tags/${tagId}`)
+	await axios.delete(`${API_BASE}/tags/${tagId}`)
 }
 
 export async function searchFiles(name: string, tagIds: number[]): Promise<ApiFile[]> {
-	return (await axios.post<ApiFile[]>(`${API_URL}/files/search`, { name, tags: tagIds })).data
+	return (await axios.post<ApiFile[]>(`${API_BASE}/files/search`, { name, tags: tagIds })).data
+}
+export async function fetchFile(id: number): Promise<ApiFile> {
+	return (await axios.get<ApiFile>(`${API_BASE}/files/${id}`)).data
 }
-export async function createFile(path: string, tagIds: number[]): Promise<void> {
-	await axios.post(`${API_URL}/files`, { path, tags: tagIds })
+export async function createFile(path: string, tagIds: number[]): Promise<ApiFile> {
+	return (await axios.post<ApiFile>(`${API_BASE}/files`, { path, tags: tagIds })).data
 }
-export async function updateFile(id: number, data: { tags?: number[] }): Promise<void> {
-	await axios.put(`${API_URL}/files/${id}`, data)
+export async function updateFile(id: number, data: { tags?: number[] }, revision?: number): Promise<void> {
+	await axios.put(`${API_BASE}/files/${id}`, data, ifMatch(revision))
 }
 export async function deleteFile(id: number): Promise<void> {
-	await axios.delete(`${API_URL}/files/${id}`)
+	await axios.delete(`${API_BASE}/files/${id}`)
 }
 
-export async function openFolder(path: string): Promise<void> {
-	await axios.post(`${API_URL}/open-folder`, { path })
+export async function fetchTrash(): Promise<{ tags: ApiTag[]; files: ApiFile[] }> {
+	return (await axios.get<{ tags: ApiTag[]; files: ApiFile[] }>(`${API_BASE}/trash`)).data
+}
+export async function restoreTag(tagId: number): Promise<void> {
+	await axios.post(`${API_BASE}/trash/tags/${tagId}/restore`)
+}
+export async function restoreFile(id: number): Promise<void> {
+	await axios.post(`${API_BASE}/trash/files/${id}/restore`)
+}
+export async function purgeTrash(): Promise<void> {
+	await axios.delete(`${API_BASE}/trash`)
+}
+
+export type ApiOperation = {
+	id: number
+	description: string
+	undone: boolean
+	createdAt: string
+}
+
+export async function fetchHistory(limit = 50): Promise<ApiOperation[]> {
+	return (await axios.get<ApiOperation[]>(`${API_BASE}/history`, { params: { limit } })).data
+}
+export async function undo(): Promise<ApiOperation> {
+	return (await axios.post<ApiOperation>(`${API_BASE}/history/undo`)).data
+}
+export async function redo(): Promise<ApiOperation> {
+	return (await axios.post<ApiOperation>(`${API_BASE}/history/redo`)).data
+}
+
+export type ApiEvent = {
+	id: number
+	// reset is sent instead of the changes missed while disconnected when there are too many, everything must be reloaded
+	type: "created" | "updated" | "deleted" | "reset"
+	entity: "tag" | "file" | "tagOrder"
+	entityId: number
+	operation: string
+	origin: string
+	createdAt: string
+}
+
+// subscribeEvents calls onEvent for every change made by any client, it returns a function closing the stream.
+// EventSource reconnects on its own and resumes after the last received event.
+export function subscribeEvents(onEvent: (event: ApiEvent) => void): () => void {
+	const source = new EventSource(`${API_BASE}/events`)
+	const listener = (e: Event) => onEvent(JSON.parse((e as MessageEvent<string>).data) as ApiEvent)
+	source.addEventListener("change", listener)
+	source.addEventListener("reset", listener)
+	return () => source.close()
+}
+
+export async function openFolder(fileId: number): Promise<void> {
+	await axios.post(`${API_BASE}/files/${fileId}/open-folder`)
 }
 export async function pickFile(): Promise<string> {
-	return (await axios.post<string>(`${API_URL}/file-picker`)).data
+	return (await axios.post<string>(`${API_BASE}/file-picker`)).data
 }
 
 export function fileSrc(id: number): string {
-	return `${API_URL}/files/${id}/file`
+	return `${API_BASE}/files/${id}/file`
+}
+
+export function thumbnailSrc(id: number, size: 64 | 128 | 256 | 512 | 1024 = 256): string {
+	return `${API_BASE}/files/${id}/thumbnail?size=${size}`
 }